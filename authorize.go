@@ -0,0 +1,218 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+var defaultAuthorizationCacheTTL = 5 * time.Minute
+
+// membershipChecker answers the questions about a GitHub user that decide whether they
+// may trigger a build.
+type membershipChecker interface {
+	isOrgMember(org, username string) (bool, error)
+	isTeamMember(org, team, username string) (bool, error)
+	hasWriteAccess(owner, repo, username string) (bool, error)
+}
+
+type githubMembership struct {
+	client *github.Client
+}
+
+func newGitHubMembership(token string) (*githubMembership, error) {
+	client, err := getClientForToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &githubMembership{client}, nil
+}
+
+func (g *githubMembership) isOrgMember(org, username string) (bool, error) {
+	member, _, err := g.client.Organizations.IsMember(context.Background(), org, username)
+	return member, err
+}
+
+func (g *githubMembership) isTeamMember(org, team, username string) (bool, error) {
+	listOptions := github.ListOptions{Page: 1, PerPage: 100}
+
+	for {
+		teams, res, err := g.client.Organizations.ListTeams(context.Background(), org, &listOptions)
+		if err != nil {
+			return false, err
+		}
+
+		for _, t := range teams {
+			if t.ID == nil || t.Slug == nil || !strings.EqualFold(*t.Slug, team) {
+				continue
+			}
+
+			membership, _, err := g.client.Organizations.GetTeamMembership(context.Background(), *t.ID, username)
+			if err != nil {
+				// github answers 404 for users who aren't on the team
+				if errRes, ok := err.(*github.ErrorResponse); ok && errRes.Response != nil && errRes.Response.StatusCode == 404 {
+					return false, nil
+				}
+				return false, err
+			}
+
+			return membership != nil && membership.State != nil && *membership.State == "active", nil
+		}
+
+		if res.NextPage == 0 {
+			break
+		}
+		listOptions.Page = res.NextPage
+	}
+
+	return false, fmt.Errorf("team %v/%v was not found", org, team)
+}
+
+func (g *githubMembership) hasWriteAccess(owner, repo, username string) (bool, error) {
+	return hasWriteAccess(g.client, owner, repo, username)
+}
+
+// cachedMembership remembers the answers of another membershipChecker for a while so that
+// busy repos don't ask GitHub the same questions for every hook.
+type cachedMembership struct {
+	checker membershipChecker
+	cache   *membershipCache
+	ttl     time.Duration
+}
+
+func (c cachedMembership) isOrgMember(org, username string) (bool, error) {
+	return c.cache.lookup(fmt.Sprintf("org:%v:%v", org, username), c.ttl, func() (bool, error) {
+		return c.checker.isOrgMember(org, username)
+	})
+}
+
+func (c cachedMembership) isTeamMember(org, team, username string) (bool, error) {
+	return c.cache.lookup(fmt.Sprintf("team:%v/%v:%v", org, team, username), c.ttl, func() (bool, error) {
+		return c.checker.isTeamMember(org, team, username)
+	})
+}
+
+func (c cachedMembership) hasWriteAccess(owner, repo, username string) (bool, error) {
+	return c.cache.lookup(fmt.Sprintf("collaborator:%v/%v:%v", owner, repo, username), c.ttl, func() (bool, error) {
+		return c.checker.hasWriteAccess(owner, repo, username)
+	})
+}
+
+type membershipCacheEntry struct {
+	member  bool
+	expires time.Time
+}
+
+type membershipCache struct {
+	mu      sync.Mutex
+	entries map[string]membershipCacheEntry
+	now     func() time.Time
+}
+
+func newMembershipCache() *membershipCache {
+	return &membershipCache{entries: make(map[string]membershipCacheEntry), now: time.Now}
+}
+
+// lookup returns the cached answer for key if it hasn't expired and otherwise asks fetch,
+// caching only successful answers.
+func (c *membershipCache) lookup(key string, ttl time.Duration, fetch func() (bool, error)) (bool, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+
+	if ok && c.now().Before(entry.expires) {
+		return entry.member, nil
+	}
+
+	member, err := fetch()
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	c.entries[key] = membershipCacheEntry{member, c.now().Add(ttl)}
+	c.mu.Unlock()
+
+	return member, nil
+}
+
+var authorizationCache = newMembershipCache()
+
+// authorizationSkipReason returns why username may not build the repo, or nil if they
+// may.  Anyone may build when no UsernameWhitelist, AllowedTeams, AllowedOrgs or
+// AllowCollaborators is configured; otherwise matching any one of them is enough.  A rule
+// that can't be checked is logged and the rest are tried; its error, which keeps the kind
+// of GitHub's, is only returned if none of them let username build.
+func authorizationSkipReason(config localConfig, username string, checker membershipChecker, logger *log.Logger) (*string, error) {
+	var rules []string
+	var checkErr error
+
+	failed := func(err error) {
+		logger.Printf("authorizing %v: %v", username, err)
+		checkErr = err
+	}
+
+	if whitelist := config.usernameWhitelist(); len(whitelist) > 0 {
+		rules = append(rules, "UsernameWhitelist")
+		if config.usernameCanBuild(username) {
+			return nil, nil
+		}
+	}
+
+	for _, team := range config.allowedTeams() {
+		rules = append(rules, fmt.Sprintf("team %v", team))
+
+		parts := strings.SplitN(team, "/", 2)
+		if len(parts) != 2 {
+			failed(grimErrorf("AllowedTeams entry %q must be org/team", team).withKind(ConfigError))
+			continue
+		}
+
+		member, err := checker.isTeamMember(parts[0], parts[1], username)
+		if err != nil {
+			failed(grimErrorf("error checking membership of team %v: %v", team, err))
+		} else if member {
+			return nil, nil
+		}
+	}
+
+	for _, org := range config.allowedOrgs() {
+		rules = append(rules, fmt.Sprintf("org %v", org))
+
+		member, err := checker.isOrgMember(org, username)
+		if err != nil {
+			failed(grimErrorf("error checking membership of org %v: %v", org, err))
+		} else if member {
+			return nil, nil
+		}
+	}
+
+	if config.allowCollaborators() {
+		rules = append(rules, "collaborators with write access")
+
+		canWrite, err := checker.hasWriteAccess(config.owner, config.repo, username)
+		if err != nil {
+			failed(grimErrorf("error checking the permissions of %v: %v", username, err))
+		} else if canWrite {
+			return nil, nil
+		}
+	}
+
+	if len(rules) == 0 {
+		return nil, nil
+	} else if checkErr != nil {
+		return nil, checkErr
+	}
+
+	return getStringPtr(fmt.Sprintf("%v is not permitted to build; builds are limited to %v", username, strings.Join(rules, ", "))), nil
+}
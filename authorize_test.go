@@ -0,0 +1,158 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+type testMembership struct {
+	orgs, teams, writers map[string]bool
+	calls                int
+	err, teamErr         error
+}
+
+func (m *testMembership) isOrgMember(org, username string) (bool, error) {
+	m.calls++
+	return m.orgs[org+":"+username], m.err
+}
+
+func (m *testMembership) isTeamMember(org, team, username string) (bool, error) {
+	m.calls++
+	if m.teamErr != nil {
+		return false, m.teamErr
+	}
+	return m.teams[org+"/"+team+":"+username], m.err
+}
+
+func (m *testMembership) hasWriteAccess(owner, repo, username string) (bool, error) {
+	m.calls++
+	return m.writers[owner+"/"+repo+":"+username], m.err
+}
+
+func TestAuthorizationSkipReason(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	checker := &testMembership{
+		orgs:    map[string]bool{"MediaMath:org-member": true},
+		teams:   map[string]bool{"MediaMath/grim-admins:team-member": true},
+		writers: map[string]bool{"MediaMath/grim:collaborator": true},
+	}
+
+	open := testLocalConfig(localConfig{owner: "MediaMath", repo: "grim", local: configMap{}})
+	restricted := testLocalConfig(localConfig{owner: "MediaMath", repo: "grim", local: configMap{
+		"UsernameWhitelist":  []interface{}{"whitelisted"},
+		"AllowedTeams":       []interface{}{"MediaMath/grim-admins"},
+		"AllowedOrgs":        []interface{}{"MediaMath"},
+		"AllowCollaborators": true,
+	}})
+
+	cases := []struct {
+		config   localConfig
+		username string
+		allowed  bool
+	}{
+		{open, "anyone", true},
+		{restricted, "whitelisted", true},
+		{restricted, "team-member", true},
+		{restricted, "org-member", true},
+		{restricted, "collaborator", true},
+		{restricted, "stranger", false},
+	}
+
+	for _, c := range cases {
+		reason, err := authorizationSkipReason(c.config, c.username, checker, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if (reason == nil) != c.allowed {
+			t.Errorf("%v allowed should be %v: %v", c.username, c.allowed, reason)
+		}
+	}
+
+	if _, err := authorizationSkipReason(restricted, "stranger", &testMembership{err: fmt.Errorf("github is down")}, logger); err == nil {
+		t.Errorf("expected failing to reach github to be an error")
+	}
+
+	rateLimited := &testMembership{err: &github.RateLimitError{Message: "API rate limit exceeded"}}
+	if _, err := authorizationSkipReason(restricted, "stranger", rateLimited, logger); !IsTransient(err) {
+		t.Errorf("expected a rate limited lookup to stay transient but got %v", err)
+	}
+
+	missingTeam := &testMembership{orgs: map[string]bool{"MediaMath:org-member": true}, teamErr: fmt.Errorf("team MediaMath/grim-admins was not found")}
+	if reason, err := authorizationSkipReason(restricted, "org-member", missingTeam, logger); err != nil || reason != nil {
+		t.Errorf("a team that can't be checked should not stop the other rules: %v %v", reason, err)
+	}
+}
+
+func TestAuthorizationConfig(t *testing.T) {
+	config := testLocalConfig(localConfig{
+		local:  configMap{"AllowedOrgs": []interface{}{"local"}},
+		global: testGlobalConfig(configMap{"AllowedOrgs": []interface{}{"global"}, "AllowedTeams": []interface{}{"MediaMath/admins"}, "AllowCollaborators": true, "AuthorizationCacheTTL": float64(60)}),
+	})
+
+	if orgs := config.allowedOrgs(); len(orgs) != 1 || orgs[0] != "local" {
+		t.Errorf("repo AllowedOrgs did not override the server's: %v", orgs)
+	}
+
+	if teams := config.allowedTeams(); len(teams) != 1 || teams[0] != "MediaMath/admins" {
+		t.Errorf("server AllowedTeams were not used: %v", teams)
+	}
+
+	if !config.allowCollaborators() {
+		t.Errorf("server AllowCollaborators was not used")
+	}
+
+	if config.authorizationCacheTTL() != time.Minute {
+		t.Errorf("unexpected cache ttl %v", config.authorizationCacheTTL())
+	}
+
+	bad := testLocalConfig(localConfig{local: configMap{"SnsTopicName": "topic", "AllowedTeams": []interface{}{"admins"}}})
+	if errs := bad.errors(); len(errs) != 1 {
+		t.Errorf("expected an invalid team error but got %v", errs)
+	}
+}
+
+func TestCachedMembership(t *testing.T) {
+	now := time.Now()
+	cache := newMembershipCache()
+	cache.now = func() time.Time { return now }
+
+	checker := &testMembership{orgs: map[string]bool{"MediaMath:bhand-mm": true}}
+	cached := cachedMembership{checker, cache, time.Minute}
+
+	for i := 0; i < 3; i++ {
+		if member, _ := cached.isOrgMember("MediaMath", "bhand-mm"); !member {
+			t.Errorf("expected bhand-mm to be a member")
+		}
+	}
+
+	if checker.calls != 1 {
+		t.Errorf("expected one call to github but there were %v", checker.calls)
+	}
+
+	now = now.Add(2 * time.Minute)
+	cached.isOrgMember("MediaMath", "bhand-mm")
+
+	if checker.calls != 2 {
+		t.Errorf("expected the cached answer to expire")
+	}
+
+	checker.err = fmt.Errorf("github is down")
+	now = now.Add(2 * time.Minute)
+	cached.isOrgMember("MediaMath", "bhand-mm")
+	checker.err = nil
+	cached.isOrgMember("MediaMath", "bhand-mm")
+
+	if checker.calls != 4 {
+		t.Errorf("errors should not be cached")
+	}
+}
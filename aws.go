@@ -5,23 +5,188 @@ package grim
 // license that can be found in the LICENSE file.
 
 import (
+	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 )
 
-func getSession(key, secret, region string) *session.Session {
-	creds := credentials.NewStaticCredentials(key, secret, "")
-	return session.New(&aws.Config{Credentials: creds, Region: &region})
+// awsConfig is what an AWS session is built from.  It is comparable so sessions can be
+// reused for as long as the config they were built from doesn't change.
+type awsConfig struct {
+	region      string
+	key         string
+	secret      string
+	profile     string
+	roleARN     string
+	endpoint    string
+	sqsEndpoint string
+	snsEndpoint string
+}
+
+type sessionKey struct {
+	config          awsConfig
+	serviceEndpoint string
+}
+
+var sessions = struct {
+	sync.Mutex
+	cache map[sessionKey]*session.Session
+}{cache: make(map[sessionKey]*session.Session)}
+
+func getSQSSession(config awsConfig) (*session.Session, error) {
+	return getSession(config, config.sqsEndpoint)
+}
+
+func getSNSSession(config awsConfig) (*session.Session, error) {
+	return getSession(config, config.snsEndpoint)
+}
+
+// getSession returns the session for the config, building it the first time it is asked
+// for.  The key and secret are used if they are set and otherwise the default credential
+// chain is: the AWS_* environment variables, the shared credentials file with the
+// profile, then the EC2 or ECS role.  If a role ARN is set the credentials are used to
+// assume it.  A service endpoint is used in place of the config's endpoint for
+// everything but getting the credentials.
+func getSession(config awsConfig, serviceEndpoint string) (*session.Session, error) {
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	key := sessionKey{config, serviceEndpoint}
+	if sess, ok := sessions.cache[key]; ok {
+		return sess, nil
+	}
+
+	sess, err := newSession(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if serviceEndpoint != "" {
+		sess = sess.Copy(&aws.Config{Endpoint: aws.String(serviceEndpoint)})
+	}
+
+	sessions.cache[key] = sess
+
+	return sess, nil
+}
+
+func newSession(config awsConfig) (*session.Session, error) {
+	awsConf := aws.Config{Region: aws.String(config.region)}
+	if config.key != "" && config.secret != "" {
+		awsConf.Credentials = credentials.NewStaticCredentials(config.key, config.secret, "")
+	}
+
+	if config.endpoint != "" {
+		awsConf.Endpoint = aws.String(config.endpoint)
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            awsConf,
+		Profile:           config.profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating AWS session: %v", err)
+	}
+
+	if config.roleARN != "" {
+		creds := stscreds.NewCredentials(sess, config.roleARN)
+		sess = sess.Copy(&aws.Config{Credentials: creds})
+	}
+
+	return sess, nil
+}
+
+// getHookCredentials are the key and secret GitHub's AmazonSNS hook publishes with.  The
+// hook keeps them, so the temporary credentials of a role can't be used.
+func getHookCredentials(config awsConfig) (string, string, error) {
+	if config.key != "" && config.secret != "" {
+		return config.key, config.secret, nil
+	}
+
+	sess, err := getSession(config, "")
+	if err != nil {
+		return "", "", err
+	}
+
+	value, err := sess.Config.Credentials.Get()
+	if err != nil {
+		return "", "", fmt.Errorf("error getting AWS credentials: %v", err)
+	}
+
+	if value.SessionToken != "" {
+		return "", "", fmt.Errorf("the AmazonSNS hook needs long-lived credentials but %v gave temporary ones; set AWSKey and AWSSecret", value.ProviderName)
+	}
+
+	return value.AccessKeyID, value.SecretAccessKey, nil
+}
+
+// splitARN is the parts of arn:partition:service:region:account:resource, or nil if the
+// ARN doesn't have them all.
+func splitARN(arn string) []string {
+	ps := strings.SplitN(arn, ":", 6)
+	if len(ps) > 5 && ps[0] == "arn" {
+		return ps
+	}
+
+	return nil
+}
+
+func getServiceFromARN(arn string) string {
+	if ps := splitARN(arn); ps != nil {
+		return ps[2]
+	}
+
+	return ""
+}
+
+func getRegionFromARN(arn string) string {
+	if ps := splitARN(arn); ps != nil {
+		return ps[3]
+	}
+
+	return ""
 }
 
 func getAccountIDFromARN(arn string) string {
-	ps := strings.Split(arn, ":")
-	if len(ps) > 5 {
+	if ps := splitARN(arn); ps != nil {
 		return ps[4]
 	}
 
 	return ""
 }
+
+func getResourceFromARN(arn string) string {
+	if ps := splitARN(arn); ps != nil {
+		return ps[5]
+	}
+
+	return ""
+}
+
+// checkTopicARN is an error if the topic isn't one that grim's queue in the region can be
+// subscribed to.  The queue ARN may be empty if the queue isn't known.
+func checkTopicARN(topicARN, region, queueARN string) error {
+	if getServiceFromARN(topicARN) != "sns" || getResourceFromARN(topicARN) == "" {
+		return fmt.Errorf("%v is not an SNS topic ARN", topicARN)
+	}
+
+	if topicRegion := getRegionFromARN(topicARN); topicRegion != region {
+		return fmt.Errorf("SNS topic %v is in region %v, not AWSRegion %v", topicARN, topicRegion, region)
+	}
+
+	if queueARN == "" {
+		return nil
+	}
+
+	if topicAccount, queueAccount := getAccountIDFromARN(topicARN), getAccountIDFromARN(queueARN); topicAccount != queueAccount {
+		return fmt.Errorf("SNS topic %v is in account %v, not the queue's account %v", topicARN, topicAccount, queueAccount)
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// The tests in this file provision a queue and topic in an SQS and SNS emulator such as
+// LocalStack, eg. make integration.
+
+func integrationAWSConfig(t *testing.T) awsConfig {
+	if testing.Short() {
+		t.Skipf("Skipping AWS integration test in short mode.")
+	}
+
+	endpoint := getEnvOrSkip(t, "GRIM_TEST_AWS_ENDPOINT")
+
+	return awsConfig{region: "us-east-1", key: "test", secret: "test", endpoint: endpoint}
+}
+
+func integrationName(kind string) string {
+	return fmt.Sprintf("grim-integration-%v-%v", kind, time.Now().UnixNano())
+}
+
+func TestIntegrationProvisionAndPoll(t *testing.T) {
+	config := integrationAWSConfig(t)
+
+	queue, err := prepareSQSQueue(config, integrationName("queue"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer deleteIntegrationQueue(t, config, queue.URL)
+
+	if queue.URL == "" || queue.ARN == "" {
+		t.Fatalf("queue is missing its URL or ARN: %+v", queue)
+	}
+
+	topicName := integrationName("topic")
+	topicARN, err := prepareSNSTopic(config, topicName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer deleteIntegrationTopic(t, config, topicARN)
+
+	again, err := prepareSNSTopic(config, topicName)
+	if err != nil {
+		t.Fatal(err)
+	} else if again != topicARN {
+		t.Errorf("topic %v was created again as %v", topicARN, again)
+	}
+
+	for n := 0; n < 2; n++ {
+		if err := prepareSubscription(config, topicARN, queue.ARN); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := setPolicy(config, "grim-integration", nil, queue.ARN, queue.URL, []string{topicARN}); err != nil {
+		t.Fatal(err)
+	}
+
+	publishIntegrationMessage(t, config, topicARN, `{"ref": "refs/heads/master"}`)
+
+	message := waitForIntegrationMessage(t, config, queue.URL)
+	if !strings.Contains(message, "refs/heads/master") {
+		t.Errorf("expected the published message but got %v", message)
+	}
+
+	if err := removeSubscription(config, topicARN, queue.ARN); err != nil {
+		t.Fatal(err)
+	}
+
+	publishIntegrationMessage(t, config, topicARN, `{"ref": "refs/heads/unsubscribed"}`)
+
+	if message, err := getNextMessage(config, queue.URL); err != nil {
+		t.Fatal(err)
+	} else if message != nil {
+		t.Errorf("got a message after unsubscribing: %v", message.body)
+	}
+}
+
+func TestIntegrationPrepareGrimQueueUsesEndpoint(t *testing.T) {
+	config := integrationAWSConfig(t)
+	queueName := integrationName("queue")
+
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, configFileName, fmt.Sprintf(`{"AWSRegion": %q, "AWSKey": "test", "AWSSecret": "test", "AWSSQSEndpoint": %q, "GrimQueueName": %q}`, config.region, config.endpoint, queueName))
+
+		var i Instance
+		i.SetConfigRoot(path)
+
+		if err := i.PrepareGrimQueue(log.New(&bytes.Buffer{}, "", 0)); err != nil {
+			t.Fatal(err)
+		}
+		defer deleteIntegrationQueue(t, config, i.queue.URL)
+
+		if !strings.HasSuffix(i.queue.URL, queueName) {
+			t.Errorf("expected a URL for %v but got %v", queueName, i.queue.URL)
+		}
+	})
+}
+
+func publishIntegrationMessage(t *testing.T, config awsConfig, topicARN, message string) {
+	session, err := getSNSSession(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = sns.New(session).Publish(&sns.PublishInput{Message: aws.String(message), TopicArn: aws.String(topicARN)})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitForIntegrationMessage(t *testing.T, config awsConfig, queueURL string) string {
+	for n := 0; n < 10; n++ {
+		message, err := getNextMessage(config, queueURL)
+		if err != nil {
+			t.Fatal(err)
+		} else if message != nil {
+			if err := deleteNextMessage(config, queueURL, message); err != nil {
+				t.Fatal(err)
+			}
+			return message.body
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	t.Fatalf("no message was delivered to %v", queueURL)
+	return ""
+}
+
+func deleteIntegrationQueue(t *testing.T, config awsConfig, queueURL string) {
+	session, err := getSQSSession(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sqs.New(session).DeleteQueue(&sqs.DeleteQueueInput{QueueUrl: aws.String(queueURL)}); err != nil {
+		t.Errorf("failed to delete queue %v: %v", queueURL, err)
+	}
+}
+
+func deleteIntegrationTopic(t *testing.T, config awsConfig, topicARN string) {
+	session, err := getSNSSession(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sns.New(session).DeleteTopic(&sns.DeleteTopicInput{TopicArn: aws.String(topicARN)}); err != nil {
+		t.Errorf("failed to delete topic %v: %v", topicARN, err)
+	}
+}
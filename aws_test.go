@@ -0,0 +1,135 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestGetSessionIsReused(t *testing.T) {
+	config := awsConfig{region: "us-east-1", key: "key", secret: "secret"}
+
+	first, err := getSession(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := getSession(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second {
+		t.Errorf("a session was built again for the same config")
+	}
+
+	config.secret = "rotated"
+	rotated, err := getSession(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rotated == first {
+		t.Errorf("a session was reused after the config changed")
+	}
+}
+
+func TestGetHookCredentialsPrefersConfig(t *testing.T) {
+	key, secret, err := getHookCredentials(awsConfig{region: "us-east-1", key: "key", secret: "secret", roleARN: "arn:aws:iam::123456789012:role/grim"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != "key" || secret != "secret" {
+		t.Errorf("expected the configured key and secret but got %q %q", key, secret)
+	}
+}
+
+func TestGlobalAWSConfig(t *testing.T) {
+	gc := testGlobalConfig(configMap{
+		"AWSRegion":  "region",
+		"AWSProfile": "builds",
+		"AWSRoleARN": "arn:aws:iam::123456789012:role/grim",
+	})
+
+	expected := awsConfig{region: "region", profile: "builds", roleARN: "arn:aws:iam::123456789012:role/grim"}
+	if actual := gc.awsConfig(); actual != expected {
+		t.Errorf("expected %+v but got %+v", expected, actual)
+	}
+}
+
+func TestServiceEndpoints(t *testing.T) {
+	config := awsConfig{region: "us-east-1", key: "key", secret: "secret", endpoint: "http://localhost:4566", sqsEndpoint: "http://localhost:9324"}
+
+	sqsSession, err := getSQSSession(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snsSession, err := getSNSSession(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if endpoint := aws.StringValue(sqsSession.Config.Endpoint); endpoint != config.sqsEndpoint {
+		t.Errorf("expected SQS to use %v but got %v", config.sqsEndpoint, endpoint)
+	}
+
+	if endpoint := aws.StringValue(snsSession.Config.Endpoint); endpoint != config.endpoint {
+		t.Errorf("expected SNS to use %v but got %v", config.endpoint, endpoint)
+	}
+}
+
+func TestARNParts(t *testing.T) {
+	arn := "arn:aws:sns:us-east-1:123456789012:grim-MediaMath-grim-repo-topic"
+
+	if service := getServiceFromARN(arn); service != "sns" {
+		t.Errorf("expected sns but got %v", service)
+	}
+
+	if region := getRegionFromARN(arn); region != "us-east-1" {
+		t.Errorf("expected us-east-1 but got %v", region)
+	}
+
+	if account := getAccountIDFromARN(arn); account != "123456789012" {
+		t.Errorf("expected 123456789012 but got %v", account)
+	}
+
+	if resource := getResourceFromARN(arn); resource != "grim-MediaMath-grim-repo-topic" {
+		t.Errorf("expected the topic name but got %v", resource)
+	}
+
+	if resource := getResourceFromARN("x-" + arn); resource != "" {
+		t.Errorf("expected nothing from a string that isn't an ARN but got %v", resource)
+	}
+}
+
+func TestCheckTopicARN(t *testing.T) {
+	queueARN := "arn:aws:sqs:us-east-1:123456789012:grim-queue"
+
+	cases := map[string]string{
+		"arn:aws:sns:us-east-1:123456789012:topic": "",
+		"arn:aws:sns:eu-west-1:123456789012:topic": "is in region eu-west-1, not AWSRegion us-east-1",
+		"arn:aws:sns:us-east-1:210987654321:topic": "is in account 210987654321, not the queue's account 123456789012",
+		"arn:aws:sqs:us-east-1:123456789012:topic": "is not an SNS topic ARN",
+		"topic": "is not an SNS topic ARN",
+	}
+
+	for topicARN, expected := range cases {
+		err := checkTopicARN(topicARN, "us-east-1", queueARN)
+		if expected == "" && err != nil {
+			t.Errorf("%v: unexpected error %v", topicARN, err)
+		} else if expected != "" && (err == nil || !strings.Contains(err.Error(), expected)) {
+			t.Errorf("%v: expected an error containing %q but got %v", topicARN, expected, err)
+		}
+	}
+
+	if err := checkTopicARN("arn:aws:sns:us-east-1:210987654321:topic", "us-east-1", ""); err != nil {
+		t.Errorf("the account can't be checked without the queue but got %v", err)
+	}
+}
@@ -14,8 +14,16 @@ import (
 
 type grimBuilder interface {
 	PrepareWorkspace(basename string) (string, error)
+	LoadRepoConfig(workspacePath string) (configMap, []string, error)
+	SkipReason() (*string, error)
+	Redactor() *redactor
 	FindBuildScript(workspacePath string) (string, error)
+	FindPipeline(workspacePath string) ([]pipelineStep, error)
 	RunBuildScript(workspacePath, buildScript string, outputChan chan string) (*executeResult, error)
+	RunPipelineStep(workspacePath string, step pipelineStep, outputChan chan string) (*executeResult, error)
+	CancelledBy() string
+	FindTimeoutHook(workspacePath string) (string, error)
+	RunTimeoutHook(workspacePath, hookScript string, outputChan chan string) (*executeResult, error)
 }
 
 func (ws *workspaceBuilder) PrepareWorkspace(basename string) (string, error) {
@@ -26,18 +34,58 @@ func (ws *workspaceBuilder) PrepareWorkspace(basename string) (string, error) {
 
 	_, err = cloneRepo(ws.token, workspacePath, ws.clonePath, ws.owner, ws.repo, ws.ref, ws.timeout)
 	if err != nil {
-		return "", fmt.Errorf("failed to download repo archive: %v", err)
+		return "", grimErrorf("failed to download repo archive: %v", err)
 	}
 
 	return workspacePath, nil
 }
 
+func (ws *workspaceBuilder) LoadRepoConfig(workspacePath string) (configMap, []string, error) {
+	inRepo, ignored, err := readRepoConfig(filepath.Join(workspacePath, ws.clonePath))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ws.config = ws.config.withInRepoConfig(inRepo)
+	ws.timeout = ws.config.timeout()
+	ws.grace = ws.config.timeoutGracePeriod()
+
+	return inRepo, ignored, nil
+}
+
+func (ws *workspaceBuilder) SkipReason() (*string, error) {
+	if ws.hook.EventName == "pull_request" && ws.hook.ChangedFiles == nil && ws.config.hasPathFilters() {
+		files, err := listPullRequestFiles(ws.token, ws.owner, ws.repo, ws.hook.PrNumber)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pull request files: %v", err)
+		}
+		ws.hook.ChangedFiles = files
+	}
+
+	return ws.config.hookSkipReason(ws.hook), nil
+}
+
+func (ws *workspaceBuilder) Redactor() *redactor {
+	return ws.redactor
+}
+
 func (ws *workspaceBuilder) FindBuildScript(workspacePath string) (string, error) {
 	configBuildScript := filepath.Join(ws.configRoot, ws.owner, ws.repo, buildScriptName)
 	if fileExists(configBuildScript) {
 		return configBuildScript, nil
 	}
 
+	buildScript, err := ws.config.buildScript()
+	if err != nil {
+		return "", err
+	} else if buildScript != "" {
+		configuredBuildScript := filepath.Join(workspacePath, ws.clonePath, buildScript)
+		if !fileExists(configuredBuildScript) {
+			return "", fmt.Errorf("unable to find the configured build script %v", buildScript)
+		}
+		return configuredBuildScript, nil
+	}
+
 	repoBuildScript := filepath.Join(workspacePath, ws.clonePath, repoBuildScriptName)
 	if fileExists(repoBuildScript) {
 		return repoBuildScript, nil
@@ -51,12 +99,74 @@ func (ws *workspaceBuilder) FindBuildScript(workspacePath string) (string, error
 	return "", fmt.Errorf("unable to find a build script to run; see README.md for more information")
 }
 
+func (ws *workspaceBuilder) FindPipeline(workspacePath string) ([]pipelineStep, error) {
+	return ws.config.pipeline()
+}
+
 func (ws *workspaceBuilder) RunBuildScript(workspacePath, buildScript string, outputChan chan string) (*executeResult, error) {
-	env := os.Environ()
-	env = append(env, fmt.Sprintf("CLONE_PATH=%v", ws.clonePath))
-	env = append(env, ws.extraEnv...)
+	env, err := ws.env()
+	if err != nil {
+		return nil, err
+	}
+
+	return executeWithOutputChan(outputChan, env, ws.sandbox, ws.limits, ws.running.cancelledChan(), workspacePath, buildScript, ws.timeout, ws.grace)
+}
+
+func (ws *workspaceBuilder) RunPipelineStep(workspacePath string, step pipelineStep, outputChan chan string) (*executeResult, error) {
+	env, err := ws.env()
+	if err != nil {
+		return nil, err
+	}
+
+	return executeWithOutputChan(outputChan, env, ws.sandbox, ws.limits, ws.running.cancelledChan(), workspacePath, "/bin/sh", step.timeout(ws.timeout), ws.grace, "-c", step.Command)
+}
+
+func (ws *workspaceBuilder) CancelledBy() string {
+	return ws.running.cancelledBy()
+}
+
+func (ws *workspaceBuilder) FindTimeoutHook(workspacePath string) (string, error) {
+	hookScript, err := ws.config.onTimeoutScript()
+	if err != nil || hookScript == "" {
+		return "", err
+	}
 
-	return executeWithOutputChan(outputChan, env, workspacePath, buildScript, ws.timeout)
+	configuredHookScript := filepath.Join(workspacePath, ws.clonePath, hookScript)
+	if !fileExists(configuredHookScript) {
+		return "", fmt.Errorf("unable to find the configured on timeout script %v", hookScript)
+	}
+
+	return configuredHookScript, nil
+}
+
+// RunTimeoutHook gives the hook as long to run as the build had to exit after SIGTERM.
+// Cancelling the build doesn't stop it cleaning up.
+func (ws *workspaceBuilder) RunTimeoutHook(workspacePath, hookScript string, outputChan chan string) (*executeResult, error) {
+	env, err := ws.env()
+	if err != nil {
+		return nil, err
+	}
+
+	return executeWithOutputChan(outputChan, env, ws.sandbox, ws.limits, nil, workspacePath, hookScript, ws.grace, ws.grace)
+}
+
+func (ws *workspaceBuilder) env() ([]string, error) {
+	configEnv, err := ws.config.env()
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := ws.config.inheritEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	environ := os.Environ()
+	cloneEnv := []string{fmt.Sprintf("CLONE_PATH=%v", ws.clonePath)}
+
+	// the hook's and matrix cell's variables come after Env so a repo's config can't change
+	// what is being built or pin a matrix axis
+	return mergeEnv(defaultBuildEnv(environ), policy.inherit(environ), configEnv, cloneEnv, ws.extraEnv, ws.secrets), nil
 }
 
 type workspaceBuilder struct {
@@ -67,8 +177,16 @@ type workspaceBuilder struct {
 	owner         string
 	repo          string
 	ref           string
+	hook          hookEvent
 	extraEnv      []string
 	timeout       time.Duration
+	grace         time.Duration
+	config        localConfig
+	redactor      *redactor
+	secrets       []string
+	sandbox       *sandboxConfig
+	limits        *resourceLimits
+	running       *runningBuild
 }
 
 func grimBuild(builder grimBuilder, resultPath, basename string) (*executeResult, string, error) {
@@ -79,32 +197,85 @@ func grimBuild(builder grimBuilder, resultPath, basename string) (*executeResult
 	}
 	defer status.Close()
 
-	statusLogger := log.New(status, "", log.Ldate|log.Ltime)
+	statusLogger := log.New(redactingWriter{status, builder.Redactor()}, "", log.Ldate|log.Ltime)
 
 	workspacePath, err := builder.PrepareWorkspace(basename)
 	if err != nil {
 		statusLogger.Printf("failed to prepare workspace %s %v\n", workspacePath, err)
-		return nil, workspacePath, fmt.Errorf("failed to prepare workspace: %v", err)
+		return nil, workspacePath, grimErrorf("failed to prepare workspace: %v", err)
 	}
 	statusLogger.Printf("workspace created %s\n", workspacePath)
 
-	buildScriptPath, err := builder.FindBuildScript(workspacePath)
+	repoConfig, ignored, err := builder.LoadRepoConfig(workspacePath)
+	if err != nil {
+		statusLogger.Printf("failed to load repo config %v\n", err)
+		return nil, workspacePath, grimErrorf("failed to load repo config: %v", err).withKind(ConfigError)
+	}
+
+	if len(ignored) > 0 {
+		statusLogger.Printf("ignoring repo config keys that can only be set on the grim server: %v\n", ignored)
+	}
+
+	skipReason, err := builder.SkipReason()
 	if err != nil {
 		statusLogger.Printf("%v\n", err)
 		return nil, workspacePath, err
 	}
-	statusLogger.Printf("build script found %s\n", buildScriptPath)
 
-	outputChan := make(chan string)
-	go writeOutput(resultPath, outputChan)
+	if skipReason != nil {
+		statusLogger.Printf("build skipped %v\n", *skipReason)
+		os.RemoveAll(workspacePath)
+
+		result := &executeResult{RepoConfig: repoConfig, SkipReason: *skipReason}
+		if err := appendResult(resultPath, *result); err != nil {
+			return result, workspacePath, fatalGrimErrorf("error while storing result: %v", err)
+		}
+
+		return result, workspacePath, nil
+	}
+
+	if by := builder.CancelledBy(); by != "" {
+		statusLogger.Printf("build cancelled by %v before it started\n", by)
+
+		result := &executeResult{RepoConfig: repoConfig, ExitCode: exitCodeCancelled, Cancelled: true, CancelledBy: by}
+		if err := appendResult(resultPath, *result); err != nil {
+			return result, workspacePath, fatalGrimErrorf("error while storing result: %v", err)
+		}
+
+		return result, workspacePath, nil
+	}
+
+	steps, err := builder.FindPipeline(workspacePath)
+	if err != nil {
+		statusLogger.Printf("%v\n", err)
+		return nil, workspacePath, grimErrorf("%v", err).withKind(ConfigError)
+	}
+
+	var result *executeResult
+	if len(steps) > 0 {
+		statusLogger.Printf("pipeline found with %d steps\n", len(steps))
+		statusLogger.Println("build started ...")
+		result, err = runPipeline(builder, workspacePath, resultPath, steps, statusLogger)
+	} else {
+		result, err = runBuildScript(builder, workspacePath, resultPath, statusLogger)
+	}
 
-	statusLogger.Println("build started ...")
-	result, err := builder.RunBuildScript(workspacePath, buildScriptPath, outputChan)
 	if err != nil {
 		statusLogger.Printf("build error %v\n", err)
 		return nil, workspacePath, err
 	}
 
+	if result.Cancelled {
+		result.CancelledBy = builder.CancelledBy()
+		statusLogger.Printf("build cancelled by %v\n", result.CancelledBy)
+	} else if result.timedOut() {
+		statusLogger.Println("build timed out")
+		runTimeoutHook(builder, workspacePath, resultPath, statusLogger)
+	}
+
+	result.RepoConfig = repoConfig
+	result.InitialEnv = builder.Redactor().redactEnv(result.InitialEnv)
+
 	if result.ExitCode == 0 {
 		statusLogger.Println("build success")
 		os.RemoveAll(workspacePath)
@@ -121,7 +292,113 @@ func grimBuild(builder grimBuilder, resultPath, basename string) (*executeResult
 	return result, workspacePath, nil
 }
 
-func build(token, configRoot, workspaceRoot, resultPath, clonePath, owner, repo, ref string, extraEnv []string, basename string, timeout time.Duration) (*executeResult, string, error) {
-	ws := &workspaceBuilder{workspaceRoot, clonePath, token, configRoot, owner, repo, ref, extraEnv, timeout}
+func runBuildScript(builder grimBuilder, workspacePath, resultPath string, statusLogger *log.Logger) (*executeResult, error) {
+	buildScriptPath, err := builder.FindBuildScript(workspacePath)
+	if err != nil {
+		return nil, grimErrorf("%v", err).withKind(ConfigError)
+	}
+	statusLogger.Printf("build script found %s\n", buildScriptPath)
+
+	outputChan := make(chan string)
+	go writeOutput(resultPath, outputChan, builder.Redactor())
+
+	statusLogger.Println("build started ...")
+	return builder.RunBuildScript(workspacePath, buildScriptPath, outputChan)
+}
+
+// runTimeoutHook runs the repo's on timeout script, if it has one.  Its own failure is
+// only logged since the build has already failed.
+func runTimeoutHook(builder grimBuilder, workspacePath, resultPath string, statusLogger *log.Logger) {
+	hookScript, err := builder.FindTimeoutHook(workspacePath)
+	if err != nil {
+		statusLogger.Printf("on timeout error %v\n", err)
+		return
+	} else if hookScript == "" {
+		return
+	}
+
+	hookPath, err := makeTree(resultPath, "on_timeout")
+	if err != nil {
+		statusLogger.Printf("failed to create result directory for on timeout script: %v\n", err)
+		return
+	}
+
+	outputChan := make(chan string)
+	go writeOutput(hookPath, outputChan, builder.Redactor())
+
+	statusLogger.Printf("on timeout script %s started ...\n", hookScript)
+	result, err := builder.RunTimeoutHook(workspacePath, hookScript, outputChan)
+	if err != nil {
+		statusLogger.Printf("on timeout error %v\n", err)
+		return
+	}
+
+	result.InitialEnv = builder.Redactor().redactEnv(result.InitialEnv)
+	if err := writeResult(hookPath, result); err != nil {
+		statusLogger.Printf("error while storing result of on timeout script: %v\n", err)
+	}
+
+	statusLogger.Printf("on timeout script done %v\n", result.ExitCode)
+}
+
+func build(configRoot, resultPath string, config localConfig, hook hookEvent, extraEnv []string, basename string, running *runningBuild) (*executeResult, string, error) {
+	ws, err := newWorkspaceBuilder(configRoot, config, hook, extraEnv)
+	if err != nil {
+		return nil, "", err
+	}
+	ws.running = running
+
 	return grimBuild(ws, resultPath, basename)
 }
+
+func newWorkspaceBuilder(configRoot string, config localConfig, hook hookEvent, extraEnv []string) (*workspaceBuilder, error) {
+	ws := &workspaceBuilder{
+		workspaceRoot: config.workspaceRoot(),
+		clonePath:     config.pathToCloneIn(),
+		token:         config.gitHubToken(),
+		configRoot:    configRoot,
+		owner:         hook.Owner,
+		repo:          hook.Repo,
+		ref:           hook.Ref,
+		hook:          hook,
+		extraEnv:      extraEnv,
+		timeout:       config.timeout(),
+		grace:         config.timeoutGracePeriod(),
+		config:        config,
+		redactor:      config.redactor(),
+	}
+
+	limits, err := config.limits()
+	if err != nil {
+		return nil, err
+	}
+	ws.limits = limits
+
+	sandbox, err := config.sandbox()
+	if err != nil {
+		return nil, err
+	} else if sandbox != nil {
+		sandbox.hide(configRoot, config.workspaceRoot(), config.resultRoot(), awsCredentialsDir())
+		ws.sandbox = sandbox
+	}
+
+	// code from a fork never gets the secrets, whatever the ForkPolicy
+	if hook.fromFork() {
+		return ws, nil
+	}
+
+	sources, err := config.secrets()
+	if err != nil {
+		return nil, err
+	}
+
+	secretEnv, values, err := loadSecrets(filepath.Join(configRoot, hook.Owner, hook.Repo), config.secretsKeyFile(), sources)
+	if err != nil {
+		return nil, err
+	}
+
+	ws.secrets = secretEnv
+	ws.redactor.add(values...)
+
+	return ws, nil
+}
@@ -70,17 +70,54 @@ type testBuilder struct {
 	buildScriptPath string
 	buildErr        error
 	buildResult     *executeResult
+	pipelineErr     error
+	pipeline        []pipelineStep
+	stepResults     map[string]*executeResult
+	repoConfig      configMap
+	repoConfigErr   error
+	skipReason      *string
+	redactor        *redactor
+	timeoutHookPath string
+	ranTimeoutHook  bool
+	cancelledBy     string
 }
 
 func (tb *testBuilder) PrepareWorkspace(basename string) (string, error) {
 	return tb.workspaceResult, tb.workspaceErr
 }
+func (tb *testBuilder) LoadRepoConfig(workspacePath string) (configMap, []string, error) {
+	return tb.repoConfig, nil, tb.repoConfigErr
+}
+func (tb *testBuilder) SkipReason() (*string, error) {
+	return tb.skipReason, nil
+}
+func (tb *testBuilder) Redactor() *redactor {
+	return tb.redactor
+}
 func (tb *testBuilder) FindBuildScript(workspacePath string) (string, error) {
 	return tb.buildScriptPath, tb.buildScriptErr
 }
+func (tb *testBuilder) FindPipeline(workspacePath string) ([]pipelineStep, error) {
+	return tb.pipeline, tb.pipelineErr
+}
 func (tb *testBuilder) RunBuildScript(workspacePath, buildScript string, outputChan chan string) (*executeResult, error) {
 	return tb.buildResult, tb.buildErr
 }
+func (tb *testBuilder) RunPipelineStep(workspacePath string, step pipelineStep, outputChan chan string) (*executeResult, error) {
+	close(outputChan)
+	return tb.stepResults[step.Name], tb.buildErr
+}
+func (tb *testBuilder) CancelledBy() string {
+	return tb.cancelledBy
+}
+func (tb *testBuilder) FindTimeoutHook(workspacePath string) (string, error) {
+	return tb.timeoutHookPath, nil
+}
+func (tb *testBuilder) RunTimeoutHook(workspacePath, hookScript string, outputChan chan string) (*executeResult, error) {
+	close(outputChan)
+	tb.ranTimeoutHook = true
+	return &executeResult{ExitCode: 0}, nil
+}
 
 func TestOnBuildStatusFileError(t *testing.T) {
 	resultPath, _ := ioutil.TempDir("", "build-status-file-error")
@@ -204,3 +241,38 @@ func TestOnRunBuildScriptFailure(t *testing.T) {
 		t.Errorf("Failed to log build failure")
 	}
 }
+
+func TestOnRunBuildScriptTimeout(t *testing.T) {
+	resultPath, _ := ioutil.TempDir("", "build-script-timeout")
+	defer os.RemoveAll(resultPath)
+
+	tb := &testBuilder{buildScriptPath: "!@#", timeoutHookPath: "cleanup.sh", buildResult: &executeResult{ExitCode: exitCodeTimedOut, TimedOut: true}}
+	grimBuild(tb, resultPath, "")
+
+	buildFile, _ := ioutil.ReadFile(resultPath + "/build.txt")
+	buildText := string(buildFile)
+
+	if !strings.Contains(buildText, "build timed out") {
+		t.Errorf("Failed to log build timeout")
+	}
+
+	if !tb.ranTimeoutHook || !strings.Contains(buildText, "on timeout script cleanup.sh started") {
+		t.Errorf("Failed to run the on timeout script")
+	}
+
+	if !fileExists(resultPath + "/on_timeout/result.json") {
+		t.Errorf("Failed to store the result of the on timeout script")
+	}
+}
+
+func TestTimeoutHookNotRunOnFailure(t *testing.T) {
+	resultPath, _ := ioutil.TempDir("", "build-script-failure-no-hook")
+	defer os.RemoveAll(resultPath)
+
+	tb := &testBuilder{buildScriptPath: "!@#", timeoutHookPath: "cleanup.sh", buildResult: &executeResult{ExitCode: 1}}
+	grimBuild(tb, resultPath, "")
+
+	if tb.ranTimeoutHook {
+		t.Errorf("on timeout script ran for a build that did not time out")
+	}
+}
@@ -0,0 +1,104 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"strings"
+	"sync"
+)
+
+// runningBuild is a build that grimd is running which can be cancelled.  A nil
+// runningBuild is never cancelled.
+type runningBuild struct {
+	owner string
+	repo  string
+	id    string
+
+	once      sync.Once
+	mu        sync.Mutex
+	by        string
+	cancelled chan struct{}
+}
+
+func (b *runningBuild) cancel(user string) {
+	b.once.Do(func() {
+		b.mu.Lock()
+		b.by = user
+		b.mu.Unlock()
+
+		close(b.cancelled)
+	})
+}
+
+// cancelledChan is closed when the build is cancelled.  It's nil, and never ready, for a
+// nil runningBuild.
+func (b *runningBuild) cancelledChan() <-chan struct{} {
+	if b == nil {
+		return nil
+	}
+
+	return b.cancelled
+}
+
+// cancelledBy is the user who cancelled the build or empty if it hasn't been.
+func (b *runningBuild) cancelledBy() string {
+	if b == nil {
+		return ""
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.by
+}
+
+// buildRegistry keeps track of the builds grimd is running so they can be cancelled.
+type buildRegistry struct {
+	mu     sync.Mutex
+	builds []*runningBuild
+}
+
+var runningBuilds = &buildRegistry{}
+
+func (r *buildRegistry) start(owner, repo, id string) *runningBuild {
+	b := &runningBuild{owner: owner, repo: repo, id: id, cancelled: make(chan struct{})}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.builds = append(r.builds, b)
+	return b
+}
+
+func (r *buildRegistry) finish(b *runningBuild) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, running := range r.builds {
+		if running == b {
+			r.builds = append(r.builds[:i], r.builds[i+1:]...)
+			return
+		}
+	}
+}
+
+// cancel cancels the repo's running builds, or just the one with the id if it isn't
+// empty, and returns the ids of those it cancelled.
+func (r *buildRegistry) cancel(owner, repo, id, user string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var ids []string
+	for _, b := range r.builds {
+		if !strings.EqualFold(b.owner, owner) || !strings.EqualFold(b.repo, repo) || (id != "" && b.id != id) {
+			continue
+		}
+
+		b.cancel(user)
+		ids = append(ids, b.id)
+	}
+
+	return ids
+}
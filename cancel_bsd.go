@@ -0,0 +1,45 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerUser asks the kernel which user is on the other end of the cancel socket.
+func peerUser(conn net.Conn) (string, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return "", fmt.Errorf("not a unix socket")
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		cred    *unix.Xucred
+		credErr error
+	)
+
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	})
+	if err == nil {
+		err = credErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return usernameForUID(cred.Uid), nil
+}
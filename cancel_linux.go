@@ -0,0 +1,41 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// peerUser asks the kernel which user is on the other end of the cancel socket.
+func peerUser(conn net.Conn) (string, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return "", fmt.Errorf("not a unix socket")
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		cred    *syscall.Ucred
+		credErr error
+	)
+
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err == nil {
+		err = credErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return usernameForUID(cred.Uid), nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// peerUser can't ask the kernel who is on the other end of the cancel socket here, and
+// what the client says can't be trusted, so builds can't be cancelled over the socket.
+func peerUser(conn net.Conn) (string, error) {
+	return "", fmt.Errorf("the cancel socket can't tell who connected on %v", runtime.GOOS)
+}
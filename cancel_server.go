@@ -0,0 +1,331 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how long a client of the cancel socket has to send its request and read the answer
+const cancelSocketTimeout = 10 * time.Second
+
+// how long the HTTP endpoint gives a request, which asks GitHub who the token belongs to
+const cancelHTTPTimeout = time.Minute
+
+// cancelPathPrefix is where the HTTP endpoint is served: POST /cancel/<owner>/<repo>[/<build-id>]
+const cancelPathPrefix = "/cancel/"
+
+type cancelRequest struct {
+	Owner   string
+	Repo    string
+	BuildID string `json:",omitempty"`
+}
+
+type cancelResponse struct {
+	Cancelled []string
+	Error     string `json:",omitempty"`
+}
+
+// ServeCancellations listens for requests to cancel running builds on the CancelSocket
+// and, when HTTPAddress is set, over HTTP.
+func (i *Instance) ServeCancellations(logger *log.Logger) error {
+	configRoot := getEffectiveConfigRoot(i.configRoot)
+
+	config, err := readGlobalConfig(configRoot)
+	if err != nil {
+		return grimErrorf("error while reading config: %v", err).withKind(ConfigError)
+	}
+
+	if err := cancelServerError(config); err != nil {
+		return grimError(err).withKind(ConfigError)
+	}
+
+	socket, err := listenOnCancelSocket(config.cancelSocket())
+	if err != nil {
+		return grimErrorf("error listening on cancel socket %v: %v", config.cancelSocket(), err)
+	}
+	go serveCancelSocket(socket, runningBuilds, logger)
+
+	if address := config.httpAddress(); address != "" {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return grimErrorf("error listening on %v: %v", address, err)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle(cancelPathPrefix, &cancelHandler{runningBuilds, githubCanceller(configRoot), logger})
+		server := &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: cancelSocketTimeout,
+			ReadTimeout:       cancelSocketTimeout,
+			WriteTimeout:      cancelHTTPTimeout,
+			IdleTimeout:       cancelHTTPTimeout,
+		}
+
+		go func() {
+			var err error
+			if certFile := config.httpCertFile(); certFile != "" {
+				err = server.ServeTLS(listener, certFile, config.httpKeyFile())
+			} else {
+				err = server.Serve(listener)
+			}
+			logger.Printf("cancel server on %v stopped: %v", address, err)
+		}()
+	}
+
+	return nil
+}
+
+// cancelServerError checks that GitHub tokens sent to the HTTP endpoint are either
+// encrypted or never leave the machine.
+func cancelServerError(config globalConfig) error {
+	address, certFile, keyFile := config.httpAddress(), config.httpCertFile(), config.httpKeyFile()
+
+	if (certFile == "") != (keyFile == "") {
+		return fmt.Errorf("HTTPCertFile and HTTPKeyFile must be set together")
+	} else if address == "" || certFile != "" {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid HTTPAddress %q: %v", address, err)
+	}
+
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("HTTPAddress %q must be a loopback address unless HTTPCertFile and HTTPKeyFile are set", address)
+	}
+
+	return nil
+}
+
+// CancelBuild asks the running grimd to cancel the repo's builds, or only the one with
+// the build id if it isn't empty, and returns the ids of the builds it cancelled.
+func (i *Instance) CancelBuild(owner, repo, buildID string) ([]string, error) {
+	configRoot := getEffectiveConfigRoot(i.configRoot)
+
+	config, err := readGlobalConfig(configRoot)
+	if err != nil {
+		return nil, grimErrorf("error while reading config: %v", err).withKind(ConfigError)
+	}
+
+	conn, err := net.DialTimeout("unix", config.cancelSocket(), cancelSocketTimeout)
+	if err != nil {
+		return nil, fatalGrimErrorf("error connecting to grimd: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(cancelSocketTimeout))
+
+	request := cancelRequest{Owner: owner, Repo: repo, BuildID: buildID}
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return nil, fatalGrimErrorf("error sending cancel request: %v", err)
+	}
+
+	var response cancelResponse
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return nil, fatalGrimErrorf("error reading cancel response: %v", err)
+	}
+
+	if response.Error != "" {
+		return nil, fatalGrimErrorf("%v", response.Error)
+	}
+
+	return response.Cancelled, nil
+}
+
+// listenOnCancelSocket replaces a socket left behind by an earlier grimd.  Anyone who can
+// write to the socket can cancel builds so it is only open to grimd's user and group.
+func listenOnCancelSocket(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%v exists and is not a socket", path)
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// usernameForUID names the user the kernel says is on the other end of the cancel socket.
+func usernameForUID(uid uint32) string {
+	id := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(id); err == nil {
+		return u.Username
+	}
+
+	return "uid " + id
+}
+
+func serveCancelSocket(listener net.Listener, registry *buildRegistry, logger *log.Logger) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			logger.Printf("cancel socket: %v", err)
+			return
+		}
+
+		go handleCancelConn(conn, registry, logger)
+	}
+}
+
+func handleCancelConn(conn net.Conn, registry *buildRegistry, logger *log.Logger) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(cancelSocketTimeout))
+
+	response := cancelResponse{}
+
+	var request cancelRequest
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		response.Error = fmt.Sprintf("invalid cancel request: %v", err)
+	} else if username, err := peerUser(conn); err != nil {
+		response.Error = fmt.Sprintf("unable to tell who is cancelling: %v", err)
+	} else {
+		response = cancelBuilds(registry, request, username, logger)
+	}
+
+	json.NewEncoder(conn).Encode(response)
+}
+
+func cancelBuilds(registry *buildRegistry, request cancelRequest, username string, logger *log.Logger) cancelResponse {
+	if request.Owner == "" || request.Repo == "" {
+		return cancelResponse{Error: "an owner and repo are required"}
+	} else if username == "" {
+		return cancelResponse{Error: "unable to tell who is cancelling"}
+	}
+
+	ids := registry.cancel(request.Owner, request.Repo, request.BuildID, username)
+	if len(ids) == 0 {
+		return cancelResponse{Error: fmt.Sprintf("no running build of %v/%v matched", request.Owner, request.Repo)}
+	}
+
+	logger.Printf("%v cancelled builds %v of %v/%v", username, ids, request.Owner, request.Repo)
+	return cancelResponse{Cancelled: ids}
+}
+
+// canceller is who a GitHub token belongs to, if they may cancel the repo's builds.
+type canceller func(token, owner, repo string) (string, error)
+
+// githubCanceller allows users with write access to the repo to cancel its builds.
+func githubCanceller(configRoot string) canceller {
+	return func(token, owner, repo string) (string, error) {
+		if !isConfiguredRepo(configRoot, owner, repo) {
+			return "", fmt.Errorf("%v/%v is not a configured repo", owner, repo)
+		}
+
+		config, err := readLocalConfig(configRoot, owner, repo)
+		if err != nil {
+			return "", err
+		}
+
+		client, err := getClientForToken(token)
+		if err != nil {
+			return "", err
+		}
+
+		u, _, err := client.Users.Get(context.Background(), "")
+		if err != nil {
+			return "", fmt.Errorf("invalid token: %v", err)
+		} else if u.Login == nil {
+			return "", fmt.Errorf("invalid token: no user")
+		}
+
+		checker, err := newGitHubMembership(config.gitHubToken())
+		if err != nil {
+			return "", err
+		}
+
+		canWrite, err := checker.hasWriteAccess(owner, repo, *u.Login)
+		if err != nil {
+			return "", err
+		} else if !canWrite {
+			return "", fmt.Errorf("%v may not cancel builds of %v/%v", *u.Login, owner, repo)
+		}
+
+		return *u.Login, nil
+	}
+}
+
+// cancelHandler serves POST /cancel/<owner>/<repo>[/<build-id>] for users authenticated
+// with a GitHub token in the Authorization header.
+type cancelHandler struct {
+	registry  *buildRegistry
+	canceller canceller
+	logger    *log.Logger
+}
+
+func (h *cancelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeCancelResponse(w, http.StatusMethodNotAllowed, cancelResponse{Error: "only POST is allowed"})
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, cancelPathPrefix), "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		writeCancelResponse(w, http.StatusNotFound, cancelResponse{Error: "expected /cancel/<owner>/<repo>[/<build-id>]"})
+		return
+	}
+
+	request := cancelRequest{Owner: parts[0], Repo: parts[1]}
+	if len(parts) == 3 {
+		request.BuildID = parts[2]
+	}
+
+	token := authorizationToken(r.Header.Get("Authorization"))
+	if token == "" {
+		writeCancelResponse(w, http.StatusUnauthorized, cancelResponse{Error: "a GitHub token is required"})
+		return
+	}
+
+	username, err := h.canceller(token, request.Owner, request.Repo)
+	if err != nil {
+		writeCancelResponse(w, http.StatusForbidden, cancelResponse{Error: err.Error()})
+		return
+	}
+
+	response := cancelBuilds(h.registry, request, username, h.logger)
+	if response.Error != "" {
+		writeCancelResponse(w, http.StatusNotFound, response)
+		return
+	}
+
+	writeCancelResponse(w, http.StatusOK, response)
+}
+
+// authorizationToken accepts both of the forms GitHub does, "token <token>" and "Bearer <token>".
+func authorizationToken(header string) string {
+	fields := strings.Fields(header)
+	if len(fields) != 2 || !(strings.EqualFold(fields[0], "token") || strings.EqualFold(fields[0], "bearer")) {
+		return ""
+	}
+
+	return fields[1]
+}
+
+func writeCancelResponse(w http.ResponseWriter, status int, response cancelResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
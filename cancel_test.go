@@ -0,0 +1,289 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildRegistryCancel(t *testing.T) {
+	registry := &buildRegistry{}
+	first := registry.start("MediaMath", "grim", "1")
+	second := registry.start("MediaMath", "grim", "2")
+	other := registry.start("MediaMath", "other", "3")
+
+	if ids := registry.cancel("mediamath", "GRIM", "2", "bob"); !reflect.DeepEqual(ids, []string{"2"}) {
+		t.Errorf("expected only build 2 to be cancelled but got %v", ids)
+	}
+
+	if second.cancelledBy() != "bob" || first.cancelledBy() != "" {
+		t.Errorf("wrong builds were cancelled: %q %q", first.cancelledBy(), second.cancelledBy())
+	}
+
+	select {
+	case <-second.cancelledChan():
+	default:
+		t.Errorf("cancelled build was not signalled")
+	}
+
+	registry.finish(first)
+	if ids := registry.cancel("MediaMath", "grim", "", "alice"); !reflect.DeepEqual(ids, []string{"2"}) {
+		t.Errorf("finished build was cancelled: %v", ids)
+	}
+
+	if second.cancelledBy() != "bob" {
+		t.Errorf("a build can only be cancelled once: %v", second.cancelledBy())
+	}
+
+	if other.cancelledBy() != "" {
+		t.Errorf("another repo's build was cancelled")
+	}
+
+	var notRunning *runningBuild
+	if notRunning.cancelledChan() != nil || notRunning.cancelledBy() != "" {
+		t.Errorf("a nil build should never be cancelled")
+	}
+}
+
+func TestCancelRunningCommand(t *testing.T) {
+	withTempDir(t, func(path string) {
+		script := filepath.Join(path, "forever.sh")
+		if err := ioutil.WriteFile(script, []byte("#!/bin/sh\nwhile true; do sleep 0.1; done\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		cancelled := make(chan struct{})
+		time.AfterFunc(100*time.Millisecond, func() { close(cancelled) })
+
+		result, err := executeWithOutputChan(nil, nil, nil, nil, cancelled, path, script, testBuildtimeout, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !result.Cancelled || result.TimedOut || result.ExitCode != exitCodeCancelled {
+			t.Errorf("expected the command to be cancelled but got: %+v", result)
+		}
+	})
+}
+
+func TestBuildCancelledBeforeItStarts(t *testing.T) {
+	resultPath, _ := ioutil.TempDir("", "build-cancelled")
+	defer os.RemoveAll(resultPath)
+
+	tb := &testBuilder{buildScriptPath: "!@#", cancelledBy: "bob", buildResult: &executeResult{ExitCode: 0}}
+	result, _, err := grimBuild(tb, resultPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Cancelled || result.CancelledBy != "bob" || result.ExitCode != exitCodeCancelled {
+		t.Errorf("expected a cancelled result but got: %+v", result)
+	}
+
+	bs, _ := ioutil.ReadFile(filepath.Join(resultPath, "result.json"))
+	if !strings.Contains(string(bs), `"CancelledBy":"bob"`) {
+		t.Errorf("cancellation was not stored in result.json: %s", bs)
+	}
+}
+
+func TestPipelineCancelledStepStops(t *testing.T) {
+	resultPath, _ := ioutil.TempDir("", "pipeline-cancelled")
+	defer os.RemoveAll(resultPath)
+
+	tb := &testBuilder{
+		cancelledBy: "bob",
+		pipeline: []pipelineStep{
+			{Name: "lint", Command: "sleep 100", ContinueOnFailure: true},
+			{Name: "test", Command: "true"},
+		},
+		stepResults: map[string]*executeResult{
+			"lint": {ExitCode: exitCodeCancelled, Cancelled: true},
+		},
+	}
+
+	result := aggregateStepResults([]stepResult{
+		{Name: "lint", ExitCode: exitCodeCancelled, ContinueOnFailure: true, Cancelled: true},
+		{Name: "test", Skipped: true},
+	}, []*executeResult{{}})
+
+	if !result.Cancelled || result.ExitCode != exitCodeCancelled {
+		t.Errorf("a cancelled step allowed to fail should still cancel the build: %+v", result)
+	}
+
+	ran, err := runPipeline(tb, "", resultPath, tb.pipeline, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ran.Steps[1].Skipped {
+		t.Errorf("steps after a cancelled step should be skipped: %+v", ran.Steps)
+	}
+}
+
+func TestCancelledNotification(t *testing.T) {
+	tempDir, _ := ioutil.TempDir("", "cancelled-notification")
+	defer os.RemoveAll(tempDir)
+
+	var buf bytes.Buffer
+	logger := log.New(&buf, "", log.Lshortfile)
+
+	hook := hookEvent{Owner: "MediaMath", Repo: "grim", EventName: "push", Target: "master", UserName: "alice"}
+	onHookBuild("not-used", localConfig{global: testGlobalConfig(configMap{"ResultRoot": tempDir})}, hook, logger, func(r string, resultPath string, c localConfig, h hookEvent, s string, b *runningBuild) (*executeResult, string, error) {
+		if ids := runningBuilds.cancel("MediaMath", "grim", "", "bob"); len(ids) != 1 || b.cancelledBy() != "bob" {
+			t.Errorf("build was not registered while running: %v", ids)
+		}
+		return &executeResult{ExitCode: exitCodeCancelled, Cancelled: true, CancelledBy: b.cancelledBy()}, "", nil
+	})
+
+	if !strings.Contains(buf.String(), "was cancelled by bob") {
+		t.Errorf("cancellation was not notified: %v", buf.String())
+	}
+
+	if ids := runningBuilds.cancel("MediaMath", "grim", "", "bob"); len(ids) != 0 {
+		t.Errorf("finished build is still registered: %v", ids)
+	}
+}
+
+func TestCancelSocket(t *testing.T) {
+	withTempDir(t, func(path string) {
+		socket := filepath.Join(path, "grimd.sock")
+		ioutil.WriteFile(filepath.Join(path, configFileName), []byte(fmt.Sprintf(`{"CancelSocket": %q}`, socket)), 0600)
+
+		listener, err := listenOnCancelSocket(socket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+
+		go serveCancelSocket(listener, runningBuilds, log.New(ioutil.Discard, "", 0))
+
+		running := runningBuilds.start("MediaMath", "socket-test", "7")
+		defer runningBuilds.finish(running)
+
+		var i Instance
+		i.SetConfigRoot(path)
+
+		ids, err := i.CancelBuild("MediaMath", "socket-test", "7")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		current, _ := user.Current()
+		if !reflect.DeepEqual(ids, []string{"7"}) || running.cancelledBy() != current.Username {
+			t.Errorf("unexpected cancellation %v by %q", ids, running.cancelledBy())
+		}
+
+		if _, err := i.CancelBuild("MediaMath", "socket-test", "8"); err == nil {
+			t.Errorf("expected an error cancelling a build that isn't running")
+		}
+
+		if fi, err := os.Stat(socket); err != nil || fi.Mode().Perm() != 0660 {
+			t.Errorf("cancel socket should only be open to grimd's user and group: %v %v", fi.Mode(), err)
+		}
+	})
+}
+
+func TestCancelHandler(t *testing.T) {
+	registry := &buildRegistry{}
+	running := registry.start("MediaMath", "grim", "42")
+
+	canceller := func(token, owner, repo string) (string, error) {
+		if token != "good" {
+			return "", fmt.Errorf("bad token")
+		}
+		return "bob", nil
+	}
+
+	handler := &cancelHandler{registry, canceller, log.New(ioutil.Discard, "", 0)}
+
+	cases := []struct {
+		method, path, authorization string
+		status                      int
+	}{
+		{"GET", "/cancel/MediaMath/grim", "token good", http.StatusMethodNotAllowed},
+		{"POST", "/cancel/MediaMath", "token good", http.StatusNotFound},
+		{"POST", "/cancel/MediaMath/grim", "", http.StatusUnauthorized},
+		{"POST", "/cancel/MediaMath/grim", "token bad", http.StatusForbidden},
+		{"POST", "/cancel/MediaMath/grim/41", "Bearer good", http.StatusNotFound},
+		{"POST", "/cancel/MediaMath/grim/42", "Bearer good", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		if c.authorization != "" {
+			req.Header.Set("Authorization", c.authorization)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != c.status {
+			t.Errorf("%v %v: expected %v but got %v %v", c.method, c.path, c.status, w.Code, w.Body.String())
+		}
+	}
+
+	if running.cancelledBy() != "bob" {
+		t.Errorf("build was not cancelled by the token's user")
+	}
+
+	req := httptest.NewRequest("POST", "/cancel/MediaMath/grim", nil)
+	req.Header.Set("Authorization", "token good")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	var response cancelResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || !reflect.DeepEqual(response.Cancelled, []string{"42"}) {
+		t.Errorf("unexpected response %v %v", w.Body.String(), err)
+	}
+}
+
+func TestGitHubCancellerOnlyReadsConfiguredRepos(t *testing.T) {
+	withTempDir(t, func(root string) {
+		writeConfig(t, root, configFileName, `{"GitHubToken": "not-for-cancelling"}`)
+		writeConfig(t, root, "MediaMath/grim/config.json", `{}`)
+
+		cancel := githubCanceller(root)
+		for _, r := range [][2]string{{"..", filepath.Base(root)}, {"MediaMath", "grim/.."}, {"MediaMath/grim", "."}, {"MediaMath", "other"}} {
+			if _, err := cancel("token", r[0], r[1]); err == nil || !strings.Contains(err.Error(), "is not a configured repo") {
+				t.Errorf("expected %v/%v to be refused but got %v", r[0], r[1], err)
+			}
+		}
+	})
+}
+
+func TestCancelServerError(t *testing.T) {
+	cases := []struct {
+		config globalConfig
+		ok     bool
+	}{
+		{globalConfig{}, true},
+		{testGlobalConfig(configMap{"HTTPAddress": "127.0.0.1:8080"}), true},
+		{testGlobalConfig(configMap{"HTTPAddress": "localhost:8080"}), true},
+		{testGlobalConfig(configMap{"HTTPAddress": "[::1]:8080"}), true},
+		{testGlobalConfig(configMap{"HTTPAddress": ":8080"}), false},
+		{testGlobalConfig(configMap{"HTTPAddress": "grim.example.com:8080"}), false},
+		{testGlobalConfig(configMap{"HTTPAddress": ":8443", "HTTPCertFile": "grim.crt", "HTTPKeyFile": "grim.key"}), true},
+		{testGlobalConfig(configMap{"HTTPAddress": ":8443", "HTTPCertFile": "grim.crt"}), false},
+	}
+
+	for _, c := range cases {
+		if err := cancelServerError(c.config); (err == nil) != c.ok {
+			t.Errorf("%v: expected ok to be %v but got %v", c.config, c.ok, err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package grim
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -9,6 +10,12 @@ import (
 const badPath = "/\\/\\/\\"
 const badContents = "}{"
 
+func TestMain(m *testing.M) {
+	// sandboxed and limited builds re-execute the test binary
+	MaybeReexec()
+	os.Exit(m.Run())
+}
+
 func getEnvOrSkip(t *testing.T, name string) string {
 	value := os.Getenv(name)
 
@@ -65,3 +72,41 @@ func withTempDir(t *testing.T, f func(string)) {
 
 	f(dir)
 }
+
+// testSettingError is the error of a repo config.json that sets key to the JSON value,
+// either from decoding its settings or from the accessor check.
+func testSettingError(t *testing.T, key, value string, check func(localConfig) error) error {
+	var val interface{}
+	if err := json.Unmarshal([]byte(value), &val); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := newLocalConfig("MediaMath", "grim", configMap{key: val}, testGlobalConfig(configMap{}))
+	if err != nil {
+		return err
+	}
+
+	return check(config)
+}
+
+// testGlobalConfig decodes the settings of a global config built by a test, which must be
+// valid.
+func testGlobalConfig(raw configMap) globalConfig {
+	gc, err := newGlobalConfig(raw)
+	if err != nil {
+		panic(err)
+	}
+
+	return gc
+}
+
+// testLocalConfig decodes the settings of a repo config built by a test, which must be
+// valid.
+func testLocalConfig(lc localConfig) localConfig {
+	decoded, err := newLocalConfig(lc.owner, lc.repo, lc.local, lc.global)
+	if err != nil {
+		panic(err)
+	}
+
+	return decoded.withInRepoConfig(lc.inRepo)
+}
@@ -16,11 +16,15 @@ var (
 	defaultConfigRoot         = "/etc/grim"
 	defaultResultRoot         = "/var/log/grim"
 	defaultWorkspaceRoot      = "/var/tmp/grim"
+	defaultCancelSocket       = "/var/run/grimd.sock"
 	defaultTimeout            = 5 * time.Minute
 	configFileName            = "config.json"
 	buildScriptName           = "build.sh"
 	repoBuildScriptName       = "grim_build.sh"
 	repoHiddenBuildScriptName = ".grim_build.sh"
+	repoConfigFileName        = ".grim.yml"
+	repoJSONConfigFileName    = ".grim.json"
+	secretsFileName           = "secrets.enc"
 	defaultTemplateForStart   = templateForStart()
 	defaultTemplateForError   = templateForFailureandError("Error during")
 	defaultTemplateForSuccess = templateForSuccess()
@@ -28,8 +32,19 @@ var (
 	defaultColorForFailure    = colorForFailure()
 	defaultColorForError      = colorForError()
 	defaultColorForPending    = colorForPending()
+	defaultColorForSkipped    = colorForSkipped()
 	defaultTemplateForFailure = templateForFailureandError("Failure during")
+	defaultTemplateForSkipped = templateForFailureandError("Skipped")
+	defaultTemplateForTag     = templateForTag()
 	defaultHipChatVersion     = 1
+
+	defaultTemplateForAwaitingApproval = templateForAwaitingApproval()
+	defaultTemplateForUnauthorized     = templateForUnauthorized()
+	defaultTemplateForLimit            = templateForLimit()
+	defaultTemplateForTimeout          = templateForFailureandError("Timed out during")
+	defaultTemplateForCancelled        = templateForCancelled()
+	defaultTimeoutGracePeriod          = 10 * time.Second
+	defaultConfigPollInterval          = 30 * time.Second
 )
 
 type configMap map[string]interface{}
@@ -75,6 +90,18 @@ func getAllConfiguredRepos(configRoot string) []repo {
 	return repos
 }
 
+// isConfiguredRepo is whether owner/name is one of the repos in the config root, which
+// owner and name from a request have to be before they are used as paths.
+func isConfiguredRepo(configRoot, owner, name string) bool {
+	for _, r := range getAllConfiguredRepos(configRoot) {
+		if r.owner == owner && r.name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
 func templateForStart() *string {
 	s := fmt.Sprintf("Starting build of {{.Owner}}/{{.Repo}} initiated by a {{.EventName}} to {{.Target}} by {{.UserName}}")
 	return &s
@@ -85,6 +112,31 @@ func templateForSuccess() *string {
 	return &s
 }
 
+func templateForTag() *string {
+	s := fmt.Sprintf("Success after build of {{.Owner}}/{{.Repo}} tag {{.Tag}} initiated by a {{.EventName}} by {{.UserName}} ({{.Workspace}})")
+	return &s
+}
+
+func templateForAwaitingApproval() *string {
+	s := fmt.Sprintf("Build of {{.Owner}}/{{.Repo}} pull request to {{.Target}} by {{.UserName}} is waiting for a maintainer to comment %q", okToTestCommand)
+	return &s
+}
+
+func templateForUnauthorized() *string {
+	s := fmt.Sprintf("Not building {{.Owner}}/{{.Repo}} {{.EventName}} to {{.Target}}: {{.Reason}}")
+	return &s
+}
+
+func templateForLimit() *string {
+	s := fmt.Sprintf("Failure during build of {{.Owner}}/{{.Repo}} initiated by a {{.EventName}} to {{.Target}} by {{.UserName}}: exceeded its {{.Limit}} limit ({{.LogDir}})")
+	return &s
+}
+
+func templateForCancelled() *string {
+	s := fmt.Sprintf("Build of {{.Owner}}/{{.Repo}} initiated by a {{.EventName}} to {{.Target}} by {{.UserName}} was {{.Reason}}")
+	return &s
+}
+
 func templateForFailureandError(preamble string) *string {
 	s := fmt.Sprintf("%s build of {{.Owner}}/{{.Repo}} initiated by a {{.EventName}} to {{.Target}} by {{.UserName}} ({{.LogDir}})", preamble)
 	return &s
@@ -105,38 +157,12 @@ func colorForError() *string {
 	return &c
 }
 
-func colorForPending() *string {
-	c := string(ColorYellow)
+func colorForSkipped() *string {
+	c := string(ColorGray)
 	return &c
 }
 
-func readStringWithDefaults(m map[string]interface{}, key string, strs ...string) string {
-	val, _ := m[key]
-	str, _ := val.(string)
-
-	if str == "" {
-		for _, str = range strs {
-			if str != "" {
-				break
-			}
-		}
-	}
-
-	return str
-}
-
-func readIntWithDefaults(m map[string]interface{}, key string, ints ...int) int {
-	val, _ := m[key]
-	f, _ := val.(float64)
-	i := int(f)
-
-	if i == 0 {
-		for _, i = range ints {
-			if i != 0 {
-				break
-			}
-		}
-	}
-
-	return i
+func colorForPending() *string {
+	c := string(ColorYellow)
+	return &c
 }
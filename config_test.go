@@ -10,7 +10,7 @@ import (
 // license that can be found in the LICENSE file.
 
 func TestGlobalEffectiveFailureTemplate(t *testing.T) {
-	gc := globalConfig{"FailureTemplate": "template"}
+	gc := testGlobalConfig(configMap{"FailureTemplate": "template"})
 
 	if gc.failureTemplate() != "template" {
 		t.Errorf("Did not set effective correctly %v", gc)
@@ -24,7 +24,7 @@ func TestGlobalEffectiveFailureTemplate(t *testing.T) {
 }
 
 func TestGlobalEffectiveFailureColor(t *testing.T) {
-	gc := globalConfig{"FailureColor": "purple"}
+	gc := testGlobalConfig(configMap{"FailureColor": "purple"})
 
 	if gc.failureColor() != "purple" {
 		t.Errorf("Did not set effective correctly %v", gc)
@@ -38,7 +38,7 @@ func TestGlobalEffectiveFailureColor(t *testing.T) {
 }
 
 func TestGlobalEffectiveSuccessTemplate(t *testing.T) {
-	gc := globalConfig{"SuccessTemplate": "template"}
+	gc := testGlobalConfig(configMap{"SuccessTemplate": "template"})
 
 	if gc.successTemplate() != "template" {
 		t.Errorf("Did not set effective correctly %v", gc)
@@ -52,7 +52,7 @@ func TestGlobalEffectiveSuccessTemplate(t *testing.T) {
 }
 
 func TestGlobalEffectiveSuccessColor(t *testing.T) {
-	gc := globalConfig{"SuccessColor": "purple"}
+	gc := testGlobalConfig(configMap{"SuccessColor": "purple"})
 
 	if gc.successColor() != "purple" {
 		t.Errorf("Did not set effective correctly %v", gc)
@@ -66,7 +66,7 @@ func TestGlobalEffectiveSuccessColor(t *testing.T) {
 }
 
 func TestGlobalEffectiveErrorTemplate(t *testing.T) {
-	gc := globalConfig{"ErrorTemplate": "template"}
+	gc := testGlobalConfig(configMap{"ErrorTemplate": "template"})
 
 	if gc.errorTemplate() != "template" {
 		t.Errorf("Did not set effective correctly %v", gc)
@@ -80,7 +80,7 @@ func TestGlobalEffectiveErrorTemplate(t *testing.T) {
 }
 
 func TestGlobalEffectiveErrorColor(t *testing.T) {
-	gc := globalConfig{"ErrorColor": "purple"}
+	gc := testGlobalConfig(configMap{"ErrorColor": "purple"})
 
 	if gc.errorColor() != "purple" {
 		t.Errorf("Did not set effective correctly %v", gc)
@@ -94,7 +94,7 @@ func TestGlobalEffectiveErrorColor(t *testing.T) {
 }
 
 func TestGlobalEffectivePendingColor(t *testing.T) {
-	gc := globalConfig{"PendingColor": "purple"}
+	gc := testGlobalConfig(configMap{"PendingColor": "purple"})
 
 	if gc.pendingColor() != "purple" {
 		t.Errorf("Did not set effective correctly %v", gc)
@@ -108,7 +108,7 @@ func TestGlobalEffectivePendingColor(t *testing.T) {
 }
 
 func TestGlobalEffectivePendingTemplate(t *testing.T) {
-	gc := globalConfig{"PendingTemplate": "template"}
+	gc := testGlobalConfig(configMap{"PendingTemplate": "template"})
 
 	if gc.pendingTemplate() != "template" {
 		t.Errorf("Did not set effective correctly %v", gc)
@@ -122,13 +122,13 @@ func TestGlobalEffectivePendingTemplate(t *testing.T) {
 }
 
 func TestGlobalEffectiveGrimServerId(t *testing.T) {
-	gc := globalConfig{"GrimServerID": "id"}
+	gc := testGlobalConfig(configMap{"GrimServerID": "id"})
 
 	if gc.grimServerID() != "id" {
 		t.Errorf("Did not set effective correctly %v", gc)
 	}
 
-	noidButQueue := globalConfig{"GrimQueueName": "q"}
+	noidButQueue := testGlobalConfig(configMap{"GrimQueueName": "q"})
 
 	if noidButQueue.grimServerID() != "q" {
 		t.Errorf("No defaulting to q name %v", noidButQueue.grimServerID())
@@ -142,7 +142,7 @@ func TestGlobalEffectiveGrimServerId(t *testing.T) {
 }
 
 func TestGlobalEffectiveWorkSpaceRoot(t *testing.T) {
-	gc := globalConfig{"WorkspaceRoot": "ws"}
+	gc := testGlobalConfig(configMap{"WorkspaceRoot": "ws"})
 
 	if gc.workspaceRoot() != "ws" {
 		t.Errorf("Did not set effective correctly %v", gc)
@@ -156,7 +156,7 @@ func TestGlobalEffectiveWorkSpaceRoot(t *testing.T) {
 }
 
 func TestGlobalEffectiveResultRoot(t *testing.T) {
-	gc := globalConfig{"ResultRoot": "result"}
+	gc := testGlobalConfig(configMap{"ResultRoot": "result"})
 
 	if gc.resultRoot() != "result" {
 		t.Errorf("Did not set effective correctly %v", gc)
@@ -170,7 +170,7 @@ func TestGlobalEffectiveResultRoot(t *testing.T) {
 }
 
 func TestGlobalEffectiveGrimQueueName(t *testing.T) {
-	gc := globalConfig{"GrimQueueName": "queue"}
+	gc := testGlobalConfig(configMap{"GrimQueueName": "queue"})
 
 	if gc.grimQueueName() != "queue" {
 		t.Errorf("Did not set effective correctly %v", gc)
@@ -184,14 +184,14 @@ func TestGlobalEffectiveGrimQueueName(t *testing.T) {
 }
 
 func TestGlobalEffectiveConfigNoDefaults(t *testing.T) {
-	gc := globalConfig{
+	gc := testGlobalConfig(configMap{
 		"AWSRegion":    "region",
 		"AWSKey":       "key",
 		"AWSSecret":    "secret",
 		"GitHubToken":  "ghtoken",
 		"HipChatRoom":  "hcRoom",
 		"HipChatToken": "hcToken",
-	}
+	})
 
 	if gc.awsRegion() != "region" ||
 		gc.awsKey() != "key" ||
@@ -215,9 +215,9 @@ func TestGlobalEffectiveConfigNoDefaults(t *testing.T) {
 }
 
 func TestLocalEffectiveConfigSnsTopic(t *testing.T) {
-	gc := globalConfig{"SNSTopicName": "global"}
-	has := localConfig{"foo", "bar", configMap{"SNSTopicName": "local"}, gc}
-	none := localConfig{"foo", "bar", configMap{}, gc}
+	gc := testGlobalConfig(configMap{"SNSTopicName": "global"})
+	has := testLocalConfig(localConfig{owner: "foo", repo: "bar", local: configMap{"SNSTopicName": "local"}, global: gc})
+	none := localConfig{owner: "foo", repo: "bar", local: configMap{}, global: gc}
 
 	if has.snsTopicName() != "local" {
 		t.Errorf("local didnt exists %v", has)
@@ -228,8 +228,26 @@ func TestLocalEffectiveConfigSnsTopic(t *testing.T) {
 	}
 }
 
+func TestLocalConfigSnsTopicARN(t *testing.T) {
+	gc := testGlobalConfig(configMap{"AWSRegion": "us-east-1"})
+	arn := testLocalConfig(localConfig{owner: "foo", repo: "bar", local: configMap{"SNSTopicName": "ignored", "SNSTopicARN": "arn:aws:sns:us-east-1:123456789012:existing"}, global: gc})
+
+	if arn.snsTopicName() != "existing" {
+		t.Errorf("topic name wasn't taken from the ARN: %v", arn.snsTopicName())
+	}
+
+	if errs := arn.errors(); len(errs) != 0 {
+		t.Errorf("valid topic ARN failed validation: %v", errs)
+	}
+
+	elsewhere := testLocalConfig(localConfig{owner: "foo", repo: "bar", local: configMap{"SNSTopicARN": "arn:aws:sns:eu-west-1:123456789012:existing"}, global: gc})
+	if errs := elsewhere.errors(); len(errs) == 0 || !strings.Contains(errs[0].Error(), "not AWSRegion us-east-1") {
+		t.Errorf("topic in another region passed validation: %v", errs)
+	}
+}
+
 func TestLocalEffectiveConfigDoesOverwriteGlobals(t *testing.T) {
-	gc := globalConfig{
+	gc := testGlobalConfig(configMap{
 		"PendingTemplate": "global",
 		"ErrorTemplate":   "global",
 		"SuccessTemplate": "global",
@@ -238,9 +256,9 @@ func TestLocalEffectiveConfigDoesOverwriteGlobals(t *testing.T) {
 		"PathToCloneIn":   "global",
 		"HipChatRoom":     "global",
 		"HipChatToken":    "global",
-	}
+	})
 
-	has := localConfig{"foo", "bar", configMap{
+	has := testLocalConfig(localConfig{owner: "foo", repo: "bar", local: configMap{
 		"PendingTemplate": "local",
 		"ErrorTemplate":   "local",
 		"SuccessTemplate": "local",
@@ -249,9 +267,9 @@ func TestLocalEffectiveConfigDoesOverwriteGlobals(t *testing.T) {
 		"PathToCloneIn":   "local",
 		"HipChatRoom":     "local",
 		"HipChatToken":    "local",
-	}, gc}
+	}, global: gc})
 
-	none := localConfig{"foo", "bar", configMap{}, gc}
+	none := localConfig{owner: "foo", repo: "bar", local: configMap{}, global: gc}
 
 	if has.gitHubToken() != "local" ||
 		has.pendingTemplate() != "local" ||
@@ -276,7 +294,7 @@ func TestLocalEffectiveConfigDoesOverwriteGlobals(t *testing.T) {
 }
 
 func TestLocalEffectiveConfigDoesntOverwriteGlobals(t *testing.T) {
-	gc := globalConfig{
+	gc := testGlobalConfig(configMap{
 		"GrimQueueName": "global.grimQueueName",
 		"ResultRoot":    "global.resultRoot",
 		"WorkspaceRoot": "global.workspaceRoot",
@@ -284,9 +302,9 @@ func TestLocalEffectiveConfigDoesntOverwriteGlobals(t *testing.T) {
 		"AWSKey":        "global.awsKey",
 		"AWSSecret":     "global.awsSecret",
 		"GrimServerID":  "grimServerID",
-	}
+	})
 
-	local := localConfig{"foo", "bar", configMap{
+	local := testLocalConfig(localConfig{owner: "foo", repo: "bar", local: configMap{
 		"GrimQueueName": "local.grimQueueName",
 		"ResultRoot":    "local.resultRoot",
 		"WorkspaceRoot": "local.workspaceRoot",
@@ -294,7 +312,7 @@ func TestLocalEffectiveConfigDoesntOverwriteGlobals(t *testing.T) {
 		"AWSKey":        "local.awsKey",
 		"AWSSecret":     "local.awsSecret",
 		"GrimServerID":  "local.grimServerID",
-	}, gc}
+	}, global: gc})
 
 	if local.grimQueueName() != "global.grimQueueName" ||
 		local.resultRoot() != "global.resultRoot" ||
@@ -309,7 +327,7 @@ func TestLocalEffectiveConfigDoesntOverwriteGlobals(t *testing.T) {
 
 func TestValidateLocalEffectiveConfig(t *testing.T) {
 	snsTopicName := "foo.go"
-	errs := localConfig{local: configMap{"SNSTopicName": snsTopicName}}.errors()
+	errs := testLocalConfig(localConfig{local: configMap{"SNSTopicName": snsTopicName}}).errors()
 	if len(errs) == 0 {
 		t.Errorf("validated with period in name")
 	}
@@ -325,9 +343,11 @@ func TestValidateEffectiveConfig(t *testing.T) {
 		shouldValidate bool
 	}{
 		{globalConfig{}, false},
-		{globalConfig{"AWSRegion": "reg", "AWSKey": "key"}, false},
-		{globalConfig{"AWSSecret": "secret", "AWSRegion": "region"}, false},
-		{globalConfig{"AWSSecret": "secret", "AWSRegion": "region", "AWSKey": "key"}, true},
+		{testGlobalConfig(configMap{"AWSRegion": "reg", "AWSKey": "key"}), false},
+		{testGlobalConfig(configMap{"AWSSecret": "secret", "AWSRegion": "region"}), false},
+		{testGlobalConfig(configMap{"AWSSecret": "secret", "AWSRegion": "region", "AWSKey": "key"}), true},
+		{testGlobalConfig(configMap{"AWSRegion": "region"}), true},
+		{testGlobalConfig(configMap{"AWSRegion": "region", "AWSProfile": "builds"}), true},
 	}
 	for _, check := range checks {
 		errs := check.gc.errors()
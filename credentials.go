@@ -0,0 +1,135 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	envReferencePrefix  = "env:"
+	fileReferencePrefix = "file:"
+)
+
+// applyEnvOverrides sets the keys of the global config.json whose GRIM_* environment
+// variable is set, so the variable is used in place of what is in the file.
+func applyEnvOverrides(config configMap, lookupEnv func(string) (string, bool)) {
+	for key, field := range globalSettingsFields {
+		if field.env == "" {
+			continue
+		}
+
+		if value, ok := lookupEnv(field.env); ok {
+			config[key] = value
+		}
+	}
+}
+
+// resolveCredentials replaces the env:NAME and file:/path references of the credential
+// keys of a config.json with what they refer to, and returns the names of the environment
+// variables referred to so that builds don't inherit them.  A relative path is relative to
+// the directory of the config.json.
+func resolveCredentials(config configMap, fields map[string]settingsField, dir string) (envNames []string, err error) {
+	for _, key := range sortedKeys(config) {
+		if !fields[key].credential {
+			continue
+		}
+
+		reference, ok := config[key].(string)
+		if !ok {
+			continue
+		}
+
+		value, err := resolveCredential(reference, dir)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", key, err)
+		}
+
+		if strings.HasPrefix(reference, envReferencePrefix) {
+			envNames = append(envNames, strings.TrimPrefix(reference, envReferencePrefix))
+		}
+
+		config[key] = value
+	}
+
+	return envNames, nil
+}
+
+func resolveCredential(reference, dir string) (string, error) {
+	switch {
+	case strings.HasPrefix(reference, envReferencePrefix):
+		name := strings.TrimPrefix(reference, envReferencePrefix)
+
+		value := os.Getenv(name)
+		if value == "" {
+			return "", fmt.Errorf("environment variable %v is not set", name)
+		}
+
+		return value, nil
+	case strings.HasPrefix(reference, fileReferencePrefix):
+		path := strings.TrimPrefix(reference, fileReferencePrefix)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+
+		value := strings.TrimSpace(string(bs))
+		if value == "" {
+			return "", fmt.Errorf("%v is empty", path)
+		}
+
+		return value, nil
+	}
+
+	return reference, nil
+}
+
+// awsCredentialEnv are the variables the AWS credential chain reads grimd's credentials
+// from when AWSKey and AWSSecret aren't set.
+var awsCredentialEnv = []string{
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+	"AWS_SECURITY_TOKEN",
+	"AWS_PROFILE",
+	"AWS_DEFAULT_PROFILE",
+	"AWS_SHARED_CREDENTIALS_FILE",
+	"AWS_CONFIG_FILE",
+	"AWS_ROLE_ARN",
+	"AWS_WEB_IDENTITY_TOKEN_FILE",
+	"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+	"AWS_CONTAINER_CREDENTIALS_FULL_URI",
+	"AWS_CONTAINER_AUTHORIZATION_TOKEN",
+}
+
+// isCredentialOverride is whether the environment variable could hold a credential that
+// builds mustn't inherit from grimd.
+func isCredentialOverride(name string) bool {
+	for _, field := range globalSettingsFields {
+		if field.credential && field.env == name {
+			return true
+		}
+	}
+
+	return containsString(awsCredentialEnv, name)
+}
+
+// awsCredentialsDir is where the AWS credential chain finds grimd's shared credentials
+// file, which a sandbox hides from builds.
+func awsCredentialsDir() string {
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".aws")
+	}
+
+	return ""
+}
@@ -0,0 +1,135 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveCredential(t *testing.T) {
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, "token", "file-token\n")
+		writeConfig(t, path, "empty", "\n")
+
+		os.Setenv("GRIM_TEST_TOKEN", "env-token")
+		defer os.Unsetenv("GRIM_TEST_TOKEN")
+
+		cases := map[string]string{
+			"plain-token":                          "plain-token",
+			"env:GRIM_TEST_TOKEN":                  "env-token",
+			"file:token":                           "file-token",
+			"file:" + filepath.Join(path, "token"): "file-token",
+			"environment:GRIM_TEST_TOKEN":          "environment:GRIM_TEST_TOKEN",
+		}
+
+		for reference, expected := range cases {
+			if actual, err := resolveCredential(reference, path); err != nil || actual != expected {
+				t.Errorf("%v: expected %q but got %q %v", reference, expected, actual, err)
+			}
+		}
+
+		for _, reference := range []string{"env:GRIM_TEST_UNSET", "file:missing", "file:empty"} {
+			if _, err := resolveCredential(reference, path); err == nil {
+				t.Errorf("%v: expected an error", reference)
+			}
+		}
+	})
+}
+
+func TestGlobalConfigOverridesAndReferences(t *testing.T) {
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, configFileName, `{"AWSKey": "env:GRIM_TEST_AWS_KEY", "AWSSecret": "in-file", "GitHubToken": "file:github-token", "HipChatRoom": "file-room"}`)
+		writeConfig(t, path, "github-token", "github\n")
+		writeConfig(t, path, "aws-secret", "secret\n")
+
+		for name, value := range map[string]string{
+			"GRIM_TEST_AWS_KEY": "key",
+			"GRIM_AWS_SECRET":   "file:aws-secret",
+			"GRIM_HIPCHAT_ROOM": "env-room",
+		} {
+			os.Setenv(name, value)
+			defer os.Unsetenv(name)
+		}
+
+		gc, err := readGlobalConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if gc.awsKey() != "key" || gc.awsSecret() != "secret" || gc.gitHubToken() != "github" || gc.hipChatRoom() != "env-room" {
+			t.Errorf("unexpected config %v", gc)
+		}
+
+		os.Unsetenv("GRIM_TEST_AWS_KEY")
+		if _, err := readGlobalConfig(path); err == nil || !strings.Contains(err.Error(), "AWSKey: environment variable GRIM_TEST_AWS_KEY is not set") {
+			t.Errorf("expected a missing variable error but got %v", err)
+		}
+	})
+}
+
+func TestRepoConfigReferences(t *testing.T) {
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, configFileName, `{}`)
+		writeConfig(t, path, "MediaMath/grim/config.json", `{"HipChatToken": "file:hipchat-token", "HipChatRoom": "file:not-a-credential"}`)
+		writeConfig(t, path, "MediaMath/grim/hipchat-token", "hipchat\n")
+
+		lc, err := readLocalConfig(path, "MediaMath", "grim")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if lc.hipChatToken() != "hipchat" || lc.hipChatRoom() != "file:not-a-credential" {
+			t.Errorf("unexpected config %v", lc.local)
+		}
+
+		writeConfig(t, path, "MediaMath/grim/config.json", `{"GitHubToken": "file:missing"}`)
+		if _, err := readLocalConfig(path, "MediaMath", "grim"); err == nil || !strings.HasPrefix(err.Error(), "GitHubToken: ") {
+			t.Errorf("expected an unreadable file error but got %v", err)
+		}
+	})
+}
+
+func TestInheritAllSkipsCredentialOverrides(t *testing.T) {
+	policy := inheritEnvPolicy{all: true}
+
+	inherited := policy.inherit([]string{"LANG=C", "GRIM_GITHUB_TOKEN=github", "GRIM_AWS_SECRET=secret", "GRIM_AWS_REGION=us-east-1"})
+	if !reflect.DeepEqual(inherited, []string{"LANG=C", "GRIM_AWS_REGION=us-east-1"}) {
+		t.Errorf("credentials were inherited: %v", inherited)
+	}
+}
+
+func TestInheritAllSkipsReferencedCredentials(t *testing.T) {
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, configFileName, `{"GitHubToken": "env:GRIM_TEST_GITHUB_TOKEN", "InheritEnv": "all"}`)
+		writeConfig(t, path, "MediaMath/grim/config.json", `{"HipChatToken": "env:GRIM_TEST_HIPCHAT_TOKEN"}`)
+
+		for name, value := range map[string]string{
+			"GRIM_TEST_GITHUB_TOKEN":  "github",
+			"GRIM_TEST_HIPCHAT_TOKEN": "hipchat",
+		} {
+			os.Setenv(name, value)
+			defer os.Unsetenv(name)
+		}
+
+		lc, err := readLocalConfig(path, "MediaMath", "grim")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		policy, err := lc.inheritEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		inherited := policy.inherit([]string{"LANG=C", "GRIM_TEST_GITHUB_TOKEN=github", "GRIM_TEST_HIPCHAT_TOKEN=hipchat"})
+		if !reflect.DeepEqual(inherited, []string{"LANG=C"}) {
+			t.Errorf("referenced credentials were inherited: %v", inherited)
+		}
+	})
+}
@@ -62,10 +62,10 @@ func (pn *pathNames) isConsistent() (bool, error) {
 }
 
 func builtForHook(tempDir, owner, repo string, exitCode int) error {
-	return onHookBuild("not-used", localConfig{global: globalConfig{"ResultRoot": tempDir, "WorkspaceRoot": tempDir}}, hookEvent{Owner: owner, Repo: repo}, nil, stubBuild)
+	return onHookBuild("not-used", localConfig{global: testGlobalConfig(configMap{"ResultRoot": tempDir, "WorkspaceRoot": tempDir})}, hookEvent{Owner: owner, Repo: repo}, nil, stubBuild)
 }
 
-func stubBuild(configRoot string, resultPath string, config localConfig, hook hookEvent, basename string) (*executeResult, string, error) {
+func stubBuild(configRoot string, resultPath string, config localConfig, hook hookEvent, basename string, running *runningBuild) (*executeResult, string, error) {
 	pathsNames.resultPath = resultPath
 	return built(config.gitHubToken(), configRoot, config.workspaceRoot(), resultPath, config.pathToCloneIn(), hook.Owner, hook.Repo, hook.Ref, hook.env(), basename)
 }
@@ -86,10 +86,42 @@ func (tb *testWorkSpaceBuilder) PrepareWorkspace(basename string) (string, error
 	return workSpacePath, err
 }
 
+func (tb *testWorkSpaceBuilder) LoadRepoConfig(workspacePath string) (configMap, []string, error) {
+	return nil, nil, nil
+}
+
+func (tb *testWorkSpaceBuilder) SkipReason() (*string, error) {
+	return nil, nil
+}
+
+func (tb *testWorkSpaceBuilder) Redactor() *redactor {
+	return nil
+}
+
 func (tb *testWorkSpaceBuilder) FindBuildScript(workspacePath string) (string, error) {
 	return ".", nil
 }
 
+func (tb *testWorkSpaceBuilder) FindPipeline(workspacePath string) ([]pipelineStep, error) {
+	return nil, nil
+}
+
 func (tb *testWorkSpaceBuilder) RunBuildScript(workspacePath, buildScript string, outputChan chan string) (*executeResult, error) {
 	return &executeResult{ExitCode: 0}, nil
 }
+
+func (tb *testWorkSpaceBuilder) RunPipelineStep(workspacePath string, step pipelineStep, outputChan chan string) (*executeResult, error) {
+	return &executeResult{ExitCode: 0}, nil
+}
+
+func (tb *testWorkSpaceBuilder) CancelledBy() string {
+	return ""
+}
+
+func (tb *testWorkSpaceBuilder) FindTimeoutHook(workspacePath string) (string, error) {
+	return "", nil
+}
+
+func (tb *testWorkSpaceBuilder) RunTimeoutHook(workspacePath, hookScript string, outputChan chan string) (*executeResult, error) {
+	return &executeResult{ExitCode: 0}, nil
+}
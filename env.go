@@ -0,0 +1,119 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const defaultBuildPath = "/usr/local/bin:/usr/bin:/bin"
+
+// inheritEnvPolicy is which of grimd's own environment variables a build sees.
+type inheritEnvPolicy struct {
+	all   bool
+	names []string
+
+	// variables that "all" doesn't include because credentials were read from them
+	withheld []string
+}
+
+// parseInheritEnv reads an InheritEnv setting which is either "none", "all" or a list
+// of variable names.  Nothing is inherited when it isn't set.
+func parseInheritEnv(val *stringOrList) (inheritEnvPolicy, error) {
+	if val == nil {
+		return inheritEnvPolicy{}, nil
+	}
+
+	if val.str != nil {
+		switch *val.str {
+		case "none":
+			return inheritEnvPolicy{}, nil
+		case "all":
+			return inheritEnvPolicy{all: true}, nil
+		}
+
+		return inheritEnvPolicy{}, fmt.Errorf("InheritEnv must be \"none\", \"all\" or a list of variable names")
+	}
+
+	for _, name := range val.list {
+		if !validEnvName.MatchString(name) {
+			return inheritEnvPolicy{}, fmt.Errorf("InheritEnv %q is not a valid environment variable name", name)
+		}
+	}
+
+	return inheritEnvPolicy{names: val.list}, nil
+}
+
+func (p inheritEnvPolicy) inherit(environ []string) []string {
+	if p.all {
+		// the credentials grimd was given are still its own, whether they are GRIM_*
+		// variables or the ones env: references in its configs name
+		var inherited []string
+		for _, kv := range environ {
+			if name := strings.SplitN(kv, "=", 2)[0]; !isCredentialOverride(name) && !p.withholds(name) {
+				inherited = append(inherited, kv)
+			}
+		}
+		return inherited
+	}
+
+	var inherited []string
+	for _, kv := range environ {
+		for _, name := range p.names {
+			if strings.HasPrefix(kv, name+"=") {
+				inherited = append(inherited, kv)
+			}
+		}
+	}
+
+	return inherited
+}
+
+func (p inheritEnvPolicy) withholds(name string) bool {
+	for _, withheld := range p.withheld {
+		if withheld == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// defaultBuildEnv is the PATH and HOME every build gets unless they are configured.
+func defaultBuildEnv(environ []string) []string {
+	path, home := defaultBuildPath, "/"
+	for _, kv := range environ {
+		if strings.HasPrefix(kv, "PATH=") {
+			path = strings.TrimPrefix(kv, "PATH=")
+		} else if strings.HasPrefix(kv, "HOME=") {
+			home = strings.TrimPrefix(kv, "HOME=")
+		}
+	}
+
+	return []string{fmt.Sprintf("PATH=%v", path), fmt.Sprintf("HOME=%v", home)}
+}
+
+// mergeEnv combines lists of NAME=value pairs with later lists overriding earlier ones.
+func mergeEnv(lists ...[]string) []string {
+	vars := make(map[string]string)
+	for _, list := range lists {
+		for _, kv := range list {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) == 2 {
+				vars[parts[0]] = kv
+			}
+		}
+	}
+
+	env := make([]string, 0, len(vars))
+	for _, kv := range vars {
+		env = append(env, kv)
+	}
+	sort.Strings(env)
+
+	return env
+}
@@ -0,0 +1,127 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseInheritEnv(t *testing.T) {
+	environ := []string{"PATH=/bin", "LANG=C", "AWS_SECRET_ACCESS_KEY=xxxx"}
+
+	cases := []struct {
+		val      string
+		expected []string
+	}{
+		{`null`, nil},
+		{`"none"`, nil},
+		{`"all"`, []string{"PATH=/bin", "LANG=C"}},
+		{`["LANG", "GOPATH"]`, []string{"LANG=C"}},
+	}
+
+	for _, c := range cases {
+		policy, err := parseInheritEnv(testInheritEnv(t, c.val))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if inherited := policy.inherit(environ); !reflect.DeepEqual(inherited, c.expected) {
+			t.Errorf("%v inherited %v instead of %v", c.val, inherited, c.expected)
+		}
+	}
+
+	for _, val := range []string{`"some"`, `["1BAD"]`} {
+		if _, err := parseInheritEnv(testInheritEnv(t, val)); err == nil {
+			t.Errorf("expected %v to be invalid", val)
+		}
+	}
+}
+
+func testInheritEnv(t *testing.T, val string) *stringOrList {
+	var setting *stringOrList
+	if err := json.Unmarshal([]byte(val), &setting); err != nil {
+		t.Fatal(err)
+	}
+
+	return setting
+}
+
+func TestInheritAllWithholdsAWSCredentials(t *testing.T) {
+	environ := []string{
+		"AWS_ACCESS_KEY_ID=AKIA",
+		"AWS_SECRET_ACCESS_KEY=xxxx",
+		"AWS_SESSION_TOKEN=token",
+		"AWS_PROFILE=grim",
+		"AWS_REGION=us-east-1",
+		"GRIM_AWS_SECRET=yyyy",
+	}
+
+	policy := inheritEnvPolicy{all: true}
+
+	if inherited := policy.inherit(environ); !reflect.DeepEqual(inherited, []string{"AWS_REGION=us-east-1"}) {
+		t.Errorf("expected grimd's AWS credentials to be withheld but got %v", inherited)
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	env := mergeEnv([]string{"PATH=/bin", "HOME=/"}, []string{"FOO=a", "PATH=/usr/bin"}, []string{"FOO=b=c", "notavar"})
+	expected := []string{"FOO=b=c", "HOME=/", "PATH=/usr/bin"}
+
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("%v != %v", env, expected)
+	}
+}
+
+func TestDefaultBuildEnv(t *testing.T) {
+	if env := defaultBuildEnv(nil); !reflect.DeepEqual(env, []string{"PATH=" + defaultBuildPath, "HOME=/"}) {
+		t.Errorf("unexpected defaults %v", env)
+	}
+
+	if env := defaultBuildEnv([]string{"HOME=/home/grim", "PATH=/opt/bin"}); !reflect.DeepEqual(env, []string{"PATH=/opt/bin", "HOME=/home/grim"}) {
+		t.Errorf("unexpected defaults %v", env)
+	}
+}
+
+func TestBuildEnvDoesNotInheritByDefault(t *testing.T) {
+	os.Setenv("GRIM_TEST_INHERITED", "leaked")
+	defer os.Unsetenv("GRIM_TEST_INHERITED")
+
+	ws := &workspaceBuilder{
+		clonePath: "src",
+		extraEnv:  []string{"GH_OWNER=MediaMath"},
+		config:    testLocalConfig(localConfig{local: configMap{"Env": map[string]interface{}{"FOO": "bar", "GH_OWNER": "overridden"}}}),
+	}
+
+	env, err := ws.env()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "GRIM_TEST_INHERITED=") {
+			t.Errorf("grimd's environment was inherited: %v", env)
+		}
+	}
+
+	for _, expected := range []string{"CLONE_PATH=src", "FOO=bar", "GH_OWNER=MediaMath"} {
+		if !containsString(env, expected) {
+			t.Errorf("%v missing from %v", expected, env)
+		}
+	}
+
+	ws.config.global = testGlobalConfig(configMap{"InheritEnv": []interface{}{"GRIM_TEST_INHERITED"}})
+	if env, _ := ws.env(); !containsString(env, "GRIM_TEST_INHERITED=leaked") {
+		t.Errorf("allowlisted variable was not inherited: %v", env)
+	}
+
+	ws.config = testLocalConfig(localConfig{local: configMap{"InheritEnv": "none"}, global: ws.config.global})
+	if env, _ := ws.env(); containsString(env, "GRIM_TEST_INHERITED=leaked") {
+		t.Errorf("repo InheritEnv should override the global one: %v", env)
+	}
+}
@@ -4,16 +4,50 @@ package grim
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
+
+// ErrorKind classifies a Grim error so that callers can decide whether to retry, back off
+// or give up.
+type ErrorKind int
+
+// These kinds are what Kind returns for the errors of Grim methods.
+const (
+	UnknownError   ErrorKind = iota
+	TransientError           // a network failure, throttling or a server error that may pass
+	ConfigError              // the configuration is missing or invalid
+	AuthError                // credentials were rejected or aren't allowed to do something
+	BuildError               // a build couldn't be run
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case TransientError:
+		return "transient"
+	case ConfigError:
+		return "config"
+	case AuthError:
+		return "auth"
+	case BuildError:
+		return "build"
+	}
+
+	return "unknown"
+}
 
 type gerror struct {
 	err     error
 	isFatal bool
+	kind    ErrorKind
+	retryAt time.Time // when the error asked to be retried, if it did
 }
 
 // Error models failures in Grim methods
 type Error interface {
 	IsFatal() bool
+	Kind() ErrorKind
 }
 
 // Error implements the Error interface.
@@ -26,6 +60,17 @@ func (ge *gerror) IsFatal() bool {
 	return ge.isFatal
 }
 
+// Kind is what sort of failure the error is.
+func (ge *gerror) Kind() ErrorKind {
+	return ge.kind
+}
+
+// withKind sets the kind of the error, eg. fatalGrimErrorf(...).withKind(ConfigError).
+func (ge *gerror) withKind(kind ErrorKind) *gerror {
+	ge.kind = kind
+	return ge
+}
+
 // IsFatal will determine if a Grim error is recoverable.
 func IsFatal(err error) bool {
 	if grimErr, ok := err.(*gerror); ok {
@@ -34,18 +79,72 @@ func IsFatal(err error) bool {
 	return false
 }
 
+// Kind is what sort of failure the error is.  Errors that don't come from Grim are
+// classified by their GitHub status code or AWS error code.
+func Kind(err error) ErrorKind {
+	if grimErr, ok := err.(*gerror); ok && grimErr != nil {
+		return grimErr.Kind()
+	}
+	return classifyError(err)
+}
+
+// IsTransient will determine if retrying what failed might succeed.
+func IsTransient(err error) bool {
+	return Kind(err) == TransientError
+}
+
+// RetryAt is when retrying what failed is worth it, eg. once a GitHub rate limit resets,
+// or the zero time if the error doesn't say.
+func RetryAt(err error) time.Time {
+	if grimErr, ok := err.(*gerror); ok {
+		if grimErr == nil {
+			return time.Time{}
+		}
+		return grimErr.retryAt
+	} else if delay, ok := retryAfter(err); ok {
+		return time.Now().Add(delay)
+	}
+	return time.Time{}
+}
+
+// argsRetryAt is the latest time any error in the args asked to be retried at.
+func argsRetryAt(args []interface{}) time.Time {
+	var latest time.Time
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			if retryAt := RetryAt(err); retryAt.After(latest) {
+				latest = retryAt
+			}
+		}
+	}
+	return latest
+}
+
+// argsKind is the kind of the first classified error in the args, so that wrapping an
+// error keeps its kind.
+func argsKind(args []interface{}) ErrorKind {
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			if kind := Kind(err); kind != UnknownError {
+				return kind
+			}
+		}
+	}
+	return UnknownError
+}
+
 func grimError(err error) *gerror {
-	return &gerror{err, false}
+	return &gerror{err, false, Kind(err), RetryAt(err)}
 }
 
 func grimErrorf(format string, args ...interface{}) *gerror {
-	return &gerror{fmt.Errorf(format, args...), false}
+	return &gerror{fmt.Errorf(format, args...), false, argsKind(args), argsRetryAt(args)}
 }
 
 func fatalGrimError(err error) *gerror {
-	return &gerror{err, true}
+	return &gerror{err, true, Kind(err), RetryAt(err)}
 }
 
 func fatalGrimErrorf(format string, args ...interface{}) *gerror {
-	return &gerror{fmt.Errorf(format, args...), true}
+	return &gerror{fmt.Errorf(format, args...), true, argsKind(args), argsRetryAt(args)}
 }
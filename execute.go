@@ -15,7 +15,11 @@ import (
 	"time"
 )
 
-var errTimeout = fmt.Errorf("build timed out")
+// the exit codes of builds that grim stopped
+const (
+	exitCodeTimedOut  = -23
+	exitCodeCancelled = -25
+)
 
 type eitherStringOrError struct {
 	str string
@@ -25,7 +29,7 @@ type eitherStringOrError struct {
 func execute(env []string, workingDir string, execPath string, timeout time.Duration, args ...string) (*executeResult, error) {
 	outputChan := make(chan string)
 
-	res, err := executeWithOutputChan(outputChan, env, workingDir, execPath, timeout, args...)
+	res, err := executeWithOutputChan(outputChan, env, nil, nil, nil, workingDir, execPath, timeout, 0, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -40,7 +44,10 @@ func execute(env []string, workingDir string, execPath string, timeout time.Dura
 	return res, nil
 }
 
-func executeWithOutputChan(outputChan chan string, env []string, workingDir string, execPath string, timeout time.Duration, args ...string) (*executeResult, error) {
+// executeWithOutputChan runs a command, inside the sandbox and within the limits if they are given.
+// A command still running after the timeout, or when cancelled is closed, is sent SIGTERM
+// and then killed if it hasn't exited after the grace period.
+func executeWithOutputChan(outputChan chan string, env []string, sandbox *sandboxConfig, limits *resourceLimits, cancelled <-chan struct{}, workingDir string, execPath string, timeout, grace time.Duration, args ...string) (*executeResult, error) {
 
 	startTime := time.Now()
 
@@ -49,6 +56,23 @@ func executeWithOutputChan(outputChan chan string, env []string, workingDir stri
 	cmd.Env = env
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
+	enforcer, err := limits.enforcer(cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer enforcer.close()
+
+	if sandbox != nil {
+		err = sandbox.wrap(cmd, enforcer.rlimitsToSet())
+	} else {
+		err = wrapRlimits(cmd, enforcer.rlimitsToSet())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	limiter := limits.outputLimiter()
+
 	var startErr error
 
 	if outputChan != nil {
@@ -65,8 +89,8 @@ func executeWithOutputChan(outputChan chan string, env []string, workingDir stri
 		}
 
 		wg.Add(2)
-		go sendLines(outReader, outputChan, &wg)
-		go sendLines(errReader, outputChan, &wg)
+		go sendLines(outReader, outputChan, limiter, &wg)
+		go sendLines(errReader, outputChan, limiter, &wg)
 		go closeAfterDone(outputChan, &wg)
 	}
 
@@ -75,23 +99,31 @@ func executeWithOutputChan(outputChan chan string, env []string, workingDir stri
 		return nil, fmt.Errorf("error starting process: %v", startErr)
 	}
 
-	exitCode, err := killProcessOnTimeout(cmd, timeout)
+	exitCode, err := killProcessOnTimeout(cmd, timeout, grace, limiter.exceededChan(), cancelled)
 	if err != nil {
 		return nil, err
 	}
 
+	limitExceeded := enforcer.exceeded(cmd.ProcessState)
+	if limiter.hasExceeded() {
+		limitExceeded = limitOutput
+	}
+
 	return &executeResult{
-		StartTime:  startTime,
-		EndTime:    time.Now(),
-		SysTime:    cmd.ProcessState.SystemTime(),
-		UserTime:   cmd.ProcessState.UserTime(),
-		InitialEnv: cmd.Env,
-		ExitCode:   exitCode,
+		StartTime:     startTime,
+		EndTime:       time.Now(),
+		SysTime:       cmd.ProcessState.SystemTime(),
+		UserTime:      cmd.ProcessState.UserTime(),
+		InitialEnv:    cmd.Env,
+		ExitCode:      exitCode,
+		LimitExceeded: limitExceeded,
+		TimedOut:      exitCode == exitCodeTimedOut,
+		Cancelled:     exitCode == exitCodeCancelled,
 	}, nil
 }
 
-// kills a cmd process based on config timeout settings
-func killProcessOnTimeout(cmd *exec.Cmd, timeout time.Duration) (exitCode int, err error) {
+// kills a cmd process based on config timeout settings, once it has written too much output or when it is cancelled
+func killProcessOnTimeout(cmd *exec.Cmd, timeout, grace time.Duration, outputExceeded, cancelled <-chan struct{}) (exitCode int, err error) {
 	// 1 deep channel for done
 	done := make(chan error, 1)
 
@@ -109,10 +141,36 @@ func killProcessOnTimeout(cmd *exec.Cmd, timeout time.Duration) (exitCode int, e
 		return 0, err
 	}
 
+	// never signal grim's own process group
+	signalBuild := func(sig syscall.Signal) {
+		if grimProcessGroupID != processGroupID {
+			syscall.Kill(-processGroupID, sig)
+		} else {
+			cmd.Process.Signal(sig)
+		}
+	}
+
+	terminate := func() {
+		signalBuild(syscall.SIGTERM)
+		select {
+		case <-done:
+		case <-time.After(grace):
+			signalBuild(syscall.SIGKILL)
+			<-done
+		}
+	}
+
 	select {
 	case <-time.After(timeout):
-		exitCode = -23
-		err = errTimeout
+		terminate()
+		exitCode = exitCodeTimedOut
+	case <-cancelled:
+		terminate()
+		exitCode = exitCodeCancelled
+	case <-outputExceeded:
+		signalBuild(syscall.SIGKILL)
+		<-done
+		exitCode = exitCodeLimitExceeded
 	case err := <-done:
 		if err != nil {
 			exitCode, err = getExitCode(err)
@@ -122,6 +180,7 @@ func killProcessOnTimeout(cmd *exec.Cmd, timeout time.Duration) (exitCode int, e
 		}
 	}
 
+	// anything the build left running in its process group goes with it
 	if grimProcessGroupID != processGroupID {
 		syscall.Kill(-processGroupID, syscall.SIGKILL)
 	}
@@ -144,10 +203,14 @@ func getExitCode(err error) (int, error) {
 	return exitCode, nil
 }
 
-func sendLines(rc io.ReadCloser, linesChan chan string, wg *sync.WaitGroup) {
+// sendLines stops sending once the output limit is passed but keeps reading so that the
+// process isn't blocked writing before it is killed.
+func sendLines(rc io.ReadCloser, linesChan chan string, limiter *outputLimiter, wg *sync.WaitGroup) {
 	scanner := bufio.NewScanner(rc)
 	for scanner.Scan() {
-		linesChan <- scanner.Text()
+		if line := scanner.Text(); limiter.add(line) {
+			linesChan <- line
+		}
 	}
 	wg.Done()
 }
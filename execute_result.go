@@ -19,7 +19,35 @@ type executeResult struct {
 	UserTime   time.Duration
 	InitialEnv []string
 	ExitCode   int
-	Output     string `json:"-"`
+	Steps      []stepResult `json:",omitempty"`
+	RepoConfig configMap    `json:",omitempty"`
+	SkipReason string       `json:",omitempty"`
+	Output     string       `json:"-"`
+
+	// the resource limit that stopped the build, if any
+	LimitExceeded string `json:",omitempty"`
+
+	// whether the build was stopped for running longer than its timeout
+	TimedOut bool `json:",omitempty"`
+
+	// whether the build was cancelled while it ran, and by whom
+	Cancelled   bool   `json:",omitempty"`
+	CancelledBy string `json:",omitempty"`
+}
+
+// timedOut is whether the build, or any step of it, ran too long.
+func (r *executeResult) timedOut() bool {
+	if r.TimedOut {
+		return true
+	}
+
+	for _, step := range r.Steps {
+		if step.TimedOut {
+			return true
+		}
+	}
+
+	return false
 }
 
 func appendResult(resultPath string, result executeResult) error {
@@ -36,7 +64,7 @@ func buildStatusFile(path string) (*os.File, error) {
 	return os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, defaultFileMode)
 }
 
-func writeOutput(path string, outputChan chan string) {
+func writeOutput(path string, outputChan chan string, r *redactor) {
 	filename := filepath.Join(path, "output.txt")
 
 	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, defaultFileMode)
@@ -44,8 +72,9 @@ func writeOutput(path string, outputChan chan string) {
 		return
 	}
 
+	lr := &lineRedactor{r: r}
 	for line := range outputChan {
-		file.WriteString(line + "\n")
+		file.WriteString(lr.redact(line) + "\n")
 	}
 	file.Close()
 }
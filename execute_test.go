@@ -63,7 +63,7 @@ func TestRunEchoWithChan(t *testing.T) {
 		}
 
 		outputChan := make(chan string)
-		result, err := executeWithOutputChan(outputChan, nil, "", echoPath, testBuildtimeout, "test")
+		result, err := executeWithOutputChan(outputChan, nil, nil, nil, nil, "", echoPath, testBuildtimeout, 0, "test")
 		if err != nil {
 			t.Error(err)
 		}
@@ -95,7 +95,7 @@ func TestGetExitCode(t *testing.T) {
 		t.Error("can not start the command.")
 	}
 
-	exCode, err := killProcessOnTimeout(cmd, timeoutTime)
+	exCode, err := killProcessOnTimeout(cmd, timeoutTime, 0, nil, nil)
 	if err != nil {
 		t.Error("process still running")
 	}
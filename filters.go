@@ -0,0 +1,101 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// matchGlob matches name against a path.Match style pattern in which a "**" segment
+// also matches any number of whole path segments, including none.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		if matched, err := path.Match(pattern[0], name[0]); err != nil || !matched {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
+
+func matchAnyGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validateGlob(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// includedByFilter reports whether name matches at least one include pattern, or
+// there are none, and doesn't match any exclude pattern.
+func includedByFilter(name string, include, exclude []string) bool {
+	if len(include) > 0 && !matchAnyGlob(include, name) {
+		return false
+	}
+
+	return !matchAnyGlob(exclude, name)
+}
+
+func refFilterSkipReason(kind, ref string, include, exclude []string) *string {
+	if ref == "" || includedByFilter(ref, include, exclude) {
+		return nil
+	}
+
+	if len(include) > 0 && !matchAnyGlob(include, ref) {
+		return getStringPtr(fmt.Sprintf("because the %v %q did not match any of %q", kind, ref, include))
+	}
+
+	return getStringPtr(fmt.Sprintf("because the %v %q matched one of the excluded %q", kind, ref, exclude))
+}
+
+// pathFilterSkipReason returns why a build shouldn't happen if none of the changed files
+// pass the path filters.  A nil list of changed files means they aren't known and
+// nothing is skipped.
+func pathFilterSkipReason(changedFiles, include, exclude []string) *string {
+	if changedFiles == nil || (len(include) == 0 && len(exclude) == 0) {
+		return nil
+	}
+
+	for _, file := range changedFiles {
+		if includedByFilter(file, include, exclude) {
+			return nil
+		}
+	}
+
+	return getStringPtr(fmt.Sprintf("because none of the %d changed files matched the path filters", len(changedFiles)))
+}
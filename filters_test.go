@@ -0,0 +1,163 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	matches := map[string][]string{
+		"master":       {"master"},
+		"release/*":    {"release/1.2"},
+		"docs/**":      {"docs/a.md", "docs/a/b/c.md", "docs"},
+		"**/*.go":      {"main.go", "a/b/main.go"},
+		"src/**/*.go":  {"src/main.go", "src/a/main.go"},
+		"feature-?":    {"feature-1"},
+		"*":            {"anything"},
+		"docs/**/a.md": {"docs/a.md", "docs/x/y/a.md"},
+	}
+
+	for pattern, names := range matches {
+		for _, name := range names {
+			if !matchGlob(pattern, name) {
+				t.Errorf("expected %q to match %q", pattern, name)
+			}
+		}
+	}
+
+	misses := map[string][]string{
+		"master":      {"master2", "feature/master"},
+		"release/*":   {"release/1.2/hotfix", "release"},
+		"**/*.go":     {"main.py", "a/b/main.py"},
+		"src/**/*.go": {"main.go", "lib/a/main.go"},
+		"*":           {"a/b"},
+	}
+
+	for pattern, names := range misses {
+		for _, name := range names {
+			if matchGlob(pattern, name) {
+				t.Errorf("expected %q not to match %q", pattern, name)
+			}
+		}
+	}
+}
+
+func TestPushBranchFilters(t *testing.T) {
+	config := testLocalConfig(localConfig{local: configMap{
+		"Branches":        []interface{}{"master", "release/*"},
+		"ExcludeBranches": []interface{}{"release/old"},
+	}})
+
+	for branch, skipped := range map[string]bool{
+		"master":      false,
+		"release/1.2": false,
+		"release/old": true,
+		"feature":     true,
+	} {
+		if skipReason := config.hookSkipReason(hookEvent{EventName: "push", Target: branch}); (skipReason != nil) != skipped {
+			t.Errorf("push to %q skipped should be %v: %v", branch, skipped, skipReason)
+		}
+	}
+
+	if config.hookSkipReason(hookEvent{EventName: "pull_request", Target: "feature"}) != nil {
+		t.Errorf("push branch filters should not apply to pull requests")
+	}
+
+	if config.hookSkipReason(hookEvent{Target: "feature"}) != nil {
+		t.Errorf("filters should not apply to manual builds")
+	}
+}
+
+func TestPullRequestBranchFilters(t *testing.T) {
+	config := testLocalConfig(localConfig{local: configMap{
+		"ExcludePullRequestBranches": []interface{}{"gh-pages"},
+	}})
+
+	if config.hookSkipReason(hookEvent{EventName: "pull_request", Target: "gh-pages"}) == nil {
+		t.Errorf("pull request to an excluded branch should be skipped")
+	}
+
+	if config.hookSkipReason(hookEvent{EventName: "pull_request", Target: "master"}) != nil {
+		t.Errorf("pull request to master should not be skipped")
+	}
+
+	if config.hookSkipReason(hookEvent{EventName: "push", Target: "gh-pages"}) != nil {
+		t.Errorf("pull request branch filters should not apply to pushes")
+	}
+}
+
+func TestTagFilters(t *testing.T) {
+	config := testLocalConfig(localConfig{local: configMap{
+		"Tags":        []interface{}{"v*"},
+		"ExcludeTags": []interface{}{"*-rc*"},
+		"Paths":       []interface{}{"src/**"},
+	}})
+
+	for tag, skipped := range map[string]bool{
+		"v1.2":     false,
+		"v1.3-rc1": true,
+		"nightly":  true,
+	} {
+		for _, eventName := range []string{"tag", "release"} {
+			if skipReason := config.hookSkipReason(hookEvent{EventName: eventName, Tag: tag, ChangedFiles: []string{"README.md"}}); (skipReason != nil) != skipped {
+				t.Errorf("%v of %q skipped should be %v: %v", eventName, tag, skipped, skipReason)
+			}
+		}
+	}
+}
+
+func TestPathFilters(t *testing.T) {
+	config := testLocalConfig(localConfig{local: configMap{
+		"Paths":        []interface{}{"src/**"},
+		"ExcludePaths": []interface{}{"**/*.md"},
+	}})
+
+	cases := []struct {
+		files   []string
+		skipped bool
+	}{
+		{[]string{"src/main.go"}, false},
+		{[]string{"README.md", "src/main.go"}, false},
+		{[]string{"README.md"}, true},
+		{[]string{"src/README.md"}, true},
+		{[]string{"docs/index.html"}, true},
+		{[]string{}, true},
+		{nil, false},
+	}
+
+	for _, c := range cases {
+		for _, eventName := range []string{"push", "pull_request"} {
+			hook := hookEvent{EventName: eventName, Target: "master", ChangedFiles: c.files}
+			if skipReason := config.hookSkipReason(hook); (skipReason != nil) != c.skipped {
+				t.Errorf("%v changing %q skipped should be %v: %v", eventName, c.files, c.skipped, skipReason)
+			}
+		}
+	}
+}
+
+func TestRepoFiltersAreOverriddenByServer(t *testing.T) {
+	config := testLocalConfig(localConfig{local: configMap{"Paths": []interface{}{"server/**"}}}).withInRepoConfig(configMap{
+		"Paths":           []interface{}{"repo/**"},
+		"ExcludeBranches": []interface{}{"wip/*"},
+	})
+
+	if config.hookSkipReason(hookEvent{EventName: "push", Target: "master", ChangedFiles: []string{"server/a"}}) != nil {
+		t.Errorf("server paths should take precedence")
+	}
+
+	if config.hookSkipReason(hookEvent{EventName: "push", Target: "wip/a", ChangedFiles: []string{"server/a"}}) == nil {
+		t.Errorf("repo branch exclusions should still apply")
+	}
+}
+
+func TestInvalidFilterPatterns(t *testing.T) {
+	config := testLocalConfig(localConfig{local: configMap{
+		"SnsTopicName": "topic",
+		"ExcludePaths": []interface{}{"docs/[", "ok/**"},
+	}})
+
+	if errs := config.errors(); len(errs) != 1 {
+		t.Errorf("expected one invalid pattern but got %v", errs)
+	}
+}
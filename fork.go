@@ -0,0 +1,147 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// ForkPolicy values say how pull requests from forks are built.
+const (
+	forkPolicyBuild           = "build"
+	forkPolicyWithoutSecrets  = "build-without-secrets"
+	forkPolicyRequireApproval = "require-approval"
+)
+
+const (
+	okToTestCommand      = "/grim ok-to-test"
+	okToTestAction       = "ok-to-test"
+	defaultApprovalLabel = "ok-to-test"
+)
+
+func validateForkPolicy(policy string) error {
+	switch policy {
+	case forkPolicyBuild, forkPolicyWithoutSecrets, forkPolicyRequireApproval:
+		return nil
+	}
+
+	return fmt.Errorf("ForkPolicy %q must be one of %q, %q or %q", policy, forkPolicyBuild, forkPolicyWithoutSecrets, forkPolicyRequireApproval)
+}
+
+// isOkToTest is true if any line of a comment is the ok-to-test command.
+func isOkToTest(comment string) bool {
+	for _, line := range strings.Split(comment, "\n") {
+		if strings.TrimSpace(line) == okToTestCommand {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pullRequestHookForComment turns an ok-to-test comment into a build of the pull request
+// it was made on.
+func pullRequestHookForComment(comment hookEvent, pull *pullRequest) hookEvent {
+	return hookEvent{
+		EventName: "pull_request",
+		Action:    okToTestAction,
+		UserName:  pull.User.Login,
+		Owner:     comment.Owner,
+		Repo:      comment.Repo,
+		Target:    pull.Base.Ref,
+		StatusRef: pull.Head.Sha,
+		URL:       pull.URL,
+		PrNumber:  comment.PrNumber,
+		HeadRepo:  pull.Head.Repo.FullName,
+		Labels:    pull.labelNames(),
+		Approver:  comment.UserName,
+	}
+}
+
+// forkSkipReason returns why a pull request shouldn't be built under the repo's fork
+// policy and whether it is waiting on a maintainer's approval.  Hooks that only approve
+// a build are skipped unless they are needed.
+func forkSkipReason(config localConfig, hook hookEvent, approved func() (bool, error)) (*string, bool, error) {
+	if hook.EventName != "pull_request" {
+		return nil, false, nil
+	}
+
+	policy, err := config.forkPolicy()
+	if err != nil {
+		return nil, false, err
+	}
+
+	needsApproval := hook.fromFork() && policy == forkPolicyRequireApproval
+
+	switch {
+	case hook.Action == "labeled" && (!needsApproval || hook.Label != config.approvalLabel()):
+		return getStringPtr(fmt.Sprintf("because the label %q does not approve a build", hook.Label)), false, nil
+	case hook.Action == okToTestAction && !needsApproval:
+		return getStringPtr("because the pull request does not need approval to build"), false, nil
+	case !needsApproval:
+		return nil, false, nil
+	}
+
+	ok, err := approved()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !ok {
+		return getStringPtr("because pull requests from forks need a maintainer's approval"), true, nil
+	}
+
+	return nil, false, nil
+}
+
+// forkApproved is true when the hook is a maintainer approving the pull request's current
+// head: the approval label being added, or an ok-to-test comment from someone with write
+// access to the repo.  Approval only covers the commits it was given for, so a push to
+// the pull request removes the label and needs approving again.
+func forkApproved(token string, hook hookEvent, approvalLabel string) (bool, error) {
+	checker, err := newGitHubMembership(token)
+	if err != nil {
+		return false, err
+	}
+
+	if hook.Action == "synchronize" && containsString(hook.Labels, approvalLabel) {
+		_, err := checker.client.Issues.RemoveLabelForIssue(context.Background(), hook.Owner, hook.Repo, int(hook.PrNumber), approvalLabel)
+		if err != nil {
+			return false, fmt.Errorf("error removing the %q label approving earlier commits: %v", approvalLabel, err)
+		}
+	}
+
+	return forkApproval(hook, approvalLabel, checker)
+}
+
+// forkApproval is whether the hook itself approves building the pull request.  Labels
+// and comments left before the pull request's latest push don't.
+func forkApproval(hook hookEvent, approvalLabel string, checker membershipChecker) (bool, error) {
+	switch {
+	case hook.Action == "labeled" && hook.Label == approvalLabel:
+		return true, nil
+	case hook.Action == okToTestAction && hook.Approver != "":
+		return checker.hasWriteAccess(hook.Owner, hook.Repo, hook.Approver)
+	}
+
+	return false, nil
+}
+
+func hasWriteAccess(client *github.Client, owner, repo, username string) (bool, error) {
+	level, _, err := client.Repositories.GetPermissionLevel(context.Background(), owner, repo, username)
+	if err != nil {
+		return false, err
+	}
+
+	if level == nil || level.Permission == nil {
+		return false, nil
+	}
+
+	return *level.Permission == "admin" || *level.Permission == "write", nil
+}
@@ -0,0 +1,202 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestForkPolicy(t *testing.T) {
+	config := testLocalConfig(localConfig{local: configMap{}, global: globalConfig{}})
+	if policy, _ := config.forkPolicy(); policy != forkPolicyWithoutSecrets {
+		t.Errorf("default fork policy was %q", policy)
+	}
+
+	config = testLocalConfig(localConfig{local: configMap{"ForkPolicy": forkPolicyBuild}, global: testGlobalConfig(configMap{"ForkPolicy": forkPolicyRequireApproval})})
+	if policy, _ := config.forkPolicy(); policy != forkPolicyBuild {
+		t.Errorf("repo fork policy did not override the server's: %q", policy)
+	}
+
+	config = testLocalConfig(localConfig{local: configMap{"ForkPolicy": "trust-everyone"}})
+	if _, err := config.forkPolicy(); err == nil {
+		t.Errorf("expected an invalid fork policy to be an error")
+	}
+
+	config = testLocalConfig(localConfig{local: configMap{"SnsTopicName": "topic"}, inRepo: configMap{"ForkPolicy": forkPolicyBuild}})
+	if policy, _ := config.forkPolicy(); policy != forkPolicyWithoutSecrets {
+		t.Errorf("fork policy should not be read from the repo's own config")
+	}
+}
+
+func TestIsOkToTest(t *testing.T) {
+	cases := map[string]bool{
+		"/grim ok-to-test":                   true,
+		"  /grim ok-to-test  ":               true,
+		"looks fine to me\n/grim ok-to-test": true,
+		"is this /grim ok-to-test?":          false,
+		"ok-to-test":                         false,
+	}
+
+	for comment, expected := range cases {
+		if isOkToTest(comment) != expected {
+			t.Errorf("%q ok-to-test should be %v", comment, expected)
+		}
+	}
+}
+
+func TestForkSkipReason(t *testing.T) {
+	requireApproval := testLocalConfig(localConfig{local: configMap{"ForkPolicy": forkPolicyRequireApproval}})
+	build := testLocalConfig(localConfig{local: configMap{"ForkPolicy": forkPolicyBuild}})
+
+	fork := hookEvent{EventName: "pull_request", Action: "opened", Owner: "MediaMath", Repo: "grim", HeadRepo: "someone/grim"}
+	branch := hookEvent{EventName: "pull_request", Action: "opened", Owner: "MediaMath", Repo: "grim", HeadRepo: "MediaMath/grim"}
+
+	labeled := fork
+	labeled.Action, labeled.Label = "labeled", defaultApprovalLabel
+
+	otherLabel := labeled
+	otherLabel.Label = "bug"
+
+	okToTest := fork
+	okToTest.Action = okToTestAction
+
+	approved := func() (bool, error) { return true, nil }
+	notApproved := func() (bool, error) { return false, nil }
+	failed := func() (bool, error) { return false, fmt.Errorf("github is down") }
+
+	cases := []struct {
+		name     string
+		config   localConfig
+		hook     hookEvent
+		approved func() (bool, error)
+		skipped  bool
+		awaiting bool
+		err      bool
+	}{
+		{"fork built under build", build, fork, notApproved, false, false, false},
+		{"branch built under require-approval", requireApproval, branch, notApproved, false, false, false},
+		{"unapproved fork", requireApproval, fork, notApproved, true, true, false},
+		{"approved fork", requireApproval, fork, approved, false, false, false},
+		{"approval check fails", requireApproval, fork, failed, false, false, true},
+		{"approval label", requireApproval, labeled, approved, false, false, false},
+		{"other label", requireApproval, otherLabel, approved, true, false, false},
+		{"label not needed", build, labeled, approved, true, false, false},
+		{"ok-to-test", requireApproval, okToTest, approved, false, false, false},
+		{"ok-to-test not needed", build, okToTest, approved, true, false, false},
+		{"push", requireApproval, hookEvent{EventName: "push"}, notApproved, false, false, false},
+	}
+
+	for _, c := range cases {
+		skipReason, awaiting, err := forkSkipReason(c.config, c.hook, c.approved)
+		if (skipReason != nil) != c.skipped || awaiting != c.awaiting || (err != nil) != c.err {
+			t.Errorf("%v: unexpected skip reason %v, awaiting %v, error %v", c.name, skipReason, awaiting, err)
+		}
+	}
+}
+
+func TestForkApproval(t *testing.T) {
+	writers := &testMembership{writers: map[string]bool{"MediaMath/grim:maintainer": true}}
+	fork := hookEvent{EventName: "pull_request", Owner: "MediaMath", Repo: "grim", HeadRepo: "someone/grim"}
+
+	labeled := fork
+	labeled.Action, labeled.Label, labeled.Labels = "labeled", defaultApprovalLabel, []string{defaultApprovalLabel}
+
+	okToTest := fork
+	okToTest.Action, okToTest.Approver = okToTestAction, "maintainer"
+
+	strangerOkToTest := okToTest
+	strangerOkToTest.Approver = "someone"
+
+	// pushing after being approved by the label or a comment
+	pushedAfterLabel := fork
+	pushedAfterLabel.Action, pushedAfterLabel.Labels = "synchronize", []string{defaultApprovalLabel}
+
+	reopened := pushedAfterLabel
+	reopened.Action = "reopened"
+
+	cases := []struct {
+		name     string
+		hook     hookEvent
+		approved bool
+	}{
+		{"approval label added", labeled, true},
+		{"ok-to-test from a maintainer", okToTest, true},
+		{"ok-to-test from someone without write access", strangerOkToTest, false},
+		{"push after approval", pushedAfterLabel, false},
+		{"reopened with the label", reopened, false},
+	}
+
+	for _, c := range cases {
+		approved, err := forkApproval(c.hook, defaultApprovalLabel, writers)
+		if err != nil || approved != c.approved {
+			t.Errorf("%v: expected approved %v but got %v %v", c.name, c.approved, approved, err)
+		}
+	}
+}
+
+func TestPullRequestHookForComment(t *testing.T) {
+	pull := new(pullRequest)
+	pull.URL = "https://github.com/MediaMath/grim/pull/7"
+	pull.User.Login = "someone"
+	pull.Head.Sha = "abc"
+	pull.Head.Repo.FullName = "someone/grim"
+	pull.Base.Ref = "master"
+
+	comment := hookEvent{EventName: "issue_comment", Action: "created", UserName: "maintainer", Owner: "MediaMath", Repo: "grim", PrNumber: 7, Comment: okToTestCommand}
+	hook := pullRequestHookForComment(comment, pull)
+
+	expected := hookEvent{
+		EventName: "pull_request",
+		Action:    okToTestAction,
+		UserName:  "someone",
+		Owner:     "MediaMath",
+		Repo:      "grim",
+		Target:    "master",
+		StatusRef: "abc",
+		URL:       "https://github.com/MediaMath/grim/pull/7",
+		PrNumber:  7,
+		HeadRepo:  "someone/grim",
+		Approver:  "maintainer",
+	}
+
+	failIfDifferent(t, hook, expected)
+}
+
+func TestForkSecretsPolicy(t *testing.T) {
+	configRoot, _ := ioutil.TempDir("", "fork-secrets")
+	defer os.RemoveAll(configRoot)
+
+	os.MkdirAll(filepath.Join(configRoot, "MediaMath", "grim"), 0700)
+	ioutil.WriteFile(filepath.Join(configRoot, "MediaMath", "grim", "npm_token"), []byte("npm-secret"), 0600)
+
+	fork := hookEvent{EventName: "pull_request", Owner: "MediaMath", Repo: "grim", HeadRepo: "someone/grim"}
+
+	for _, policy := range []string{forkPolicyBuild, forkPolicyWithoutSecrets, forkPolicyRequireApproval} {
+		config := testLocalConfig(localConfig{owner: "MediaMath", repo: "grim", local: configMap{
+			"ForkPolicy": policy,
+			"Secrets":    map[string]interface{}{"NPM_TOKEN": map[string]interface{}{"file": "npm_token"}},
+		}})
+
+		ws, err := newWorkspaceBuilder(configRoot, config, fork, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		env, _ := ws.env()
+		found := false
+		for _, kv := range env {
+			found = found || strings.HasPrefix(kv, "NPM_TOKEN=")
+		}
+
+		if found {
+			t.Errorf("secrets were injected into a fork under %q", policy)
+		}
+	}
+}
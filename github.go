@@ -5,6 +5,7 @@ package grim
 // license that can be found in the LICENSE file.
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"time"
@@ -68,3 +69,33 @@ func pollForMergeCommitSha(token, owner, repo string, number int64) (string, err
 	}
 	return "", nil
 }
+
+func listPullRequestFiles(token, owner, repo string, number int64) ([]string, error) {
+	client, err := getClientForToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	listOptions := github.ListOptions{Page: 1, PerPage: 100}
+	files := []string{}
+
+	for {
+		commitFiles, res, err := client.PullRequests.ListFiles(context.Background(), owner, repo, int(number), &listOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range commitFiles {
+			if file.Filename != nil {
+				files = append(files, *file.Filename)
+			}
+		}
+
+		if res.NextPage == 0 {
+			break
+		}
+		listOptions.Page = res.NextPage
+	}
+
+	return files, nil
+}
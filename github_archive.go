@@ -15,6 +15,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/google/go-github/github"
 )
 
 func cloneRepo(token string, workspacePath string, clonePath string, owner string, repo string, ref string, timeOut time.Duration) (string, error) {
@@ -48,12 +50,16 @@ func unarchiveRepo(file, workspacePath, clonePath string, timeOut time.Duration)
 
 	//extracts the folder into the finalName directory pulling off the top level folder
 	//will break if github starts returning a different tar format
-	result, err := executeWithOutputChan(nil, nil, workspacePath, tarPath, timeOut, "-xvf", file, "-C", finalName, "--strip-components=1")
+	result, err := executeWithOutputChan(nil, nil, nil, nil, nil, workspacePath, tarPath, timeOut, 0, "-xvf", file, "-C", finalName, "--strip-components=1")
 
 	if err != nil {
 		return "", err
 	}
 
+	if result.TimedOut {
+		return "", fmt.Errorf("extract archive timed out after %v", timeOut)
+	}
+
 	if result.ExitCode != 0 {
 		return "", fmt.Errorf("extract archive failed: %v %v", result.ExitCode, strings.TrimSpace(result.Output))
 	}
@@ -73,13 +79,22 @@ func downloadRepo(token, owner, repo, ref string, location string) (string, erro
 		return "", err
 	}
 
-	temp, err := ioutil.TempFile(location, "download")
-	if err != nil {
-		return "", err
-	}
-
-	resp, err := client.Do(context.Background(), req, temp)
-	temp.Close()
+	var temp *os.File
+	var resp *github.Response
+	err = defaultRetryPolicy.do(func() error {
+		temp, err = ioutil.TempFile(location, "download")
+		if err != nil {
+			return err
+		}
+
+		resp, err = client.Do(context.Background(), req, temp)
+		temp.Close()
+		if err != nil {
+			// a partial download isn't resumed
+			os.Remove(temp.Name())
+		}
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
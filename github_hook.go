@@ -25,12 +25,36 @@ type hookEvent struct {
 	URL       string
 	PrNumber  int64
 	Deleted   bool
+	Tag       string `json:",omitempty"`
+	HeadRepo  string `json:",omitempty"`
+
+	// the labels of a pull request and, for a "labeled" action, the one just added
+	Labels []string `json:",omitempty"`
+	Label  string   `json:",omitempty"`
+
+	// the body of an issue comment, which can approve building a pull request, and for a
+	// pull request built because of one, who made it
+	Comment  string `json:",omitempty"`
+	Approver string `json:",omitempty"`
+
+	// the files changed by a push or pull request, used by path filters
+	ChangedFiles []string `json:",omitempty"`
+
+	// set when the hook is being built as one cell of a build matrix
+	MatrixCell string   `json:",omitempty"`
+	MatrixEnv  []string `json:",omitempty"`
 }
 
 func (hook hookEvent) Describe() string {
 	return fmt.Sprintf("hook of %v/%v initiated by a %q to %q by %q", hook.Owner, hook.Repo, hook.EventName, hook.Target, hook.UserName)
 }
 
+// fromFork is true for pull requests whose changes don't come from the repo itself,
+// including those whose head repo has since been deleted.
+func (hook hookEvent) fromFork() bool {
+	return hook.EventName == "pull_request" && !strings.EqualFold(hook.HeadRepo, fmt.Sprintf("%v/%v", hook.Owner, hook.Repo))
+}
+
 func (hook hookEvent) env() []string {
 	return []string{
 		fmt.Sprintf("GH_EVENT_NAME=%v", hook.EventName),
@@ -43,27 +67,62 @@ func (hook hookEvent) env() []string {
 		fmt.Sprintf("GH_STATUS_REF=%v", hook.StatusRef),
 		fmt.Sprintf("GH_URL=%v", hook.URL),
 		fmt.Sprintf("GH_PR_NUMBER=%v", hook.PrNumber),
+		fmt.Sprintf("GH_TAG=%v", hook.Tag),
 	}
 }
 
+type label struct {
+	Name string `json:"name"`
+}
+
 type pullRequest struct {
 	URL            string `json:"html_url"`
 	MergeCommitSha string `json:"merge_commit_sha"`
 	Head           struct {
-		Ref string `json:"ref"`
-		Sha string `json:"sha"`
+		Ref  string `json:"ref"`
+		Sha  string `json:"sha"`
+		Repo struct {
+			FullName string `json:"full_name"`
+		} `json:"repo"`
 	} `json:"head"`
 	Base struct {
 		Ref string `json:"ref"`
 		Sha string `json:"sha"`
 	} `json:"base"`
+	User struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Labels []label `json:"labels"`
+}
+
+type release struct {
+	URL        string `json:"html_url"`
+	TagName    string `json:"tag_name"`
+	Name       string `json:"name"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
 }
 
 type githubHook struct {
+	// Release fields
+	Release release `json:"release"`
+
 	// Pull Request fields
 	Action      string      `json:"action"`
 	Number      int64       `json:"number"`
 	PullRequest pullRequest `json:"pull_request"`
+	Label       label       `json:"label"`
+
+	// Issue Comment fields
+	Issue struct {
+		Number      int64 `json:"number"`
+		PullRequest *struct {
+			URL string `json:"url"`
+		} `json:"pull_request"`
+	} `json:"issue"`
+	Comment struct {
+		Body string `json:"body"`
+	} `json:"comment"`
 
 	// Push fields
 	Ref        string `json:"ref"`
@@ -72,6 +131,11 @@ type githubHook struct {
 	HeadCommit struct {
 		ID string `json:"id"`
 	} `json:"head_commit"`
+	Commits []struct {
+		Added    []string `json:"added"`
+		Removed  []string `json:"removed"`
+		Modified []string `json:"modified"`
+	} `json:"commits"`
 
 	// Common fields
 	Sender struct {
@@ -86,6 +150,43 @@ type githubHook struct {
 	} `json:"repository"`
 }
 
+func (pull *pullRequest) labelNames() []string {
+	var names []string
+	for _, l := range pull.Labels {
+		names = append(names, l.Name)
+	}
+
+	return names
+}
+
+// maxPushCommits is the most commits GitHub lists in a push hook.  A push with more has
+// its list cut short.
+const maxPushCommits = 20
+
+// changedFiles is every file added, removed or modified by the commits of a push.  It is
+// nil, meaning the files aren't known, if the list of commits may have been cut short.
+func (parsed *githubHook) changedFiles() []string {
+	if len(parsed.Commits) >= maxPushCommits {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var files []string
+
+	for _, commit := range parsed.Commits {
+		for _, list := range [][]string{commit.Added, commit.Removed, commit.Modified} {
+			for _, file := range list {
+				if !seen[file] {
+					seen[file] = true
+					files = append(files, file)
+				}
+			}
+		}
+	}
+
+	return files
+}
+
 type hookWrapper struct {
 	Message string
 }
@@ -108,7 +209,23 @@ func extractHookEvent(body string) (*hookEvent, error) {
 	hook.UserName = parsed.Sender.Login
 	hook.Repo = parsed.Repository.Name
 	hook.Deleted = parsed.Deleted
-	if parsed.Action != "" {
+	if parsed.Release.TagName != "" {
+		hook.EventName = "release"
+		hook.Action = parsed.Action
+		hook.Owner = parsed.Repository.Owner.Login
+		hook.Tag = parsed.Release.TagName
+		hook.Target = parsed.Release.TagName
+		hook.Ref = parsed.Release.TagName
+		hook.URL = parsed.Release.URL
+	} else if parsed.Issue.Number != 0 {
+		hook.EventName = "issue_comment"
+		hook.Action = parsed.Action
+		hook.Owner = parsed.Repository.Owner.Login
+		hook.Comment = parsed.Comment.Body
+		if parsed.Issue.PullRequest != nil {
+			hook.PrNumber = parsed.Issue.Number
+		}
+	} else if parsed.Action != "" {
 		hook.EventName = "pull_request"
 		hook.Action = parsed.Action
 		hook.Owner = parsed.Repository.Owner.Login
@@ -116,6 +233,9 @@ func extractHookEvent(body string) (*hookEvent, error) {
 		hook.StatusRef = parsed.PullRequest.Head.Sha
 		hook.URL = parsed.PullRequest.URL
 		hook.PrNumber = parsed.Number
+		hook.HeadRepo = parsed.PullRequest.Head.Repo.FullName
+		hook.Labels = parsed.PullRequest.labelNames()
+		hook.Label = parsed.Label.Name
 	} else {
 		hook.EventName = "push"
 		hook.Owner = parsed.Repository.Owner.Name
@@ -123,6 +243,14 @@ func extractHookEvent(body string) (*hookEvent, error) {
 		hook.Ref = parsed.HeadCommit.ID
 		hook.StatusRef = parsed.HeadCommit.ID
 		hook.URL = parsed.Compare
+		hook.ChangedFiles = parsed.changedFiles()
+
+		if strings.HasPrefix(parsed.Ref, "refs/tags/") {
+			hook.EventName = "tag"
+			hook.Tag = strings.TrimPrefix(parsed.Ref, "refs/tags/")
+			hook.Target = hook.Tag
+			hook.ChangedFiles = nil
+		}
 	}
 
 	hook.Target = strings.TrimPrefix(hook.Target, "refs/heads/")
@@ -146,17 +274,57 @@ func prepareAmazonSNSService(token, owner, repo, snsTopic, awsKey, awsSecret, aw
 	return err
 }
 
+// removeAmazonSNSService deletes the repo's AmazonSNS hook if it has one.
+func removeAmazonSNSService(token, owner, repo string) error {
+	client, err := getClientForToken(token)
+	if err != nil {
+		return err
+	}
+
+	hookID, err := findExistingAmazonSNSHookID(client, owner, repo)
+	if err != nil || hookID == 0 {
+		return err
+	}
+
+	_, err = client.Repositories.DeleteHook(context.Background(), owner, repo, hookID)
+	return err
+}
+
+func hasAmazonSNSService(token, owner, repo string) (bool, error) {
+	hook, err := getAmazonSNSService(token, owner, repo)
+	return hook != nil, err
+}
+
+// getAmazonSNSService is the repo's AmazonSNS hook, or nil if it doesn't have one.
+func getAmazonSNSService(token, owner, repo string) (*github.Hook, error) {
+	client, err := getClientForToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return findExistingAmazonSNSHook(client, owner, repo)
+}
+
 func findExistingAmazonSNSHookID(client *github.Client, owner, repo string) (int, error) {
+	hook, err := findExistingAmazonSNSHook(client, owner, repo)
+	if err != nil || hook == nil {
+		return 0, err
+	}
+
+	return *hook.ID, nil
+}
+
+func findExistingAmazonSNSHook(client *github.Client, owner, repo string) (*github.Hook, error) {
 	listOptions := github.ListOptions{Page: 1, PerPage: 100}
 
 	for {
 		hooks, res, err := client.Repositories.ListHooks(context.Background(), owner, repo, &listOptions)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 		for _, hook := range hooks {
 			if hook.Name != nil && *hook.Name == "amazonsns" && hook.ID != nil {
-				return *hook.ID, nil
+				return hook, nil
 			}
 		}
 		if res.NextPage == 0 {
@@ -165,7 +333,48 @@ func findExistingAmazonSNSHookID(client *github.Client, owner, repo string) (int
 		listOptions.Page = res.NextPage
 	}
 
-	return 0, nil
+	return nil, nil
+}
+
+// amazonSNSHookDrift names what differs between the repo's AmazonSNS hook and the one it
+// should have, or is nil if nothing does.  GitHub doesn't return the AWS secret, so a
+// changed secret isn't noticed.
+func amazonSNSHookDrift(hook, want *github.Hook) []string {
+	if hook == nil {
+		return []string{"missing"}
+	}
+
+	var drift []string
+	if hook.Active == nil || *hook.Active != *want.Active {
+		drift = append(drift, "active")
+	}
+
+	if !sameStrings(hook.Events, want.Events) {
+		drift = append(drift, "events")
+	}
+
+	for _, key := range []string{"sns_topic", "sns_region", "aws_key"} {
+		if fmt.Sprint(hook.Config[key]) != fmt.Sprint(want.Config[key]) {
+			drift = append(drift, key)
+		}
+	}
+
+	return drift
+}
+
+// sameStrings is whether the lists have the same strings in any order.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for _, s := range a {
+		if !containsString(b, s) {
+			return false
+		}
+	}
+
+	return true
 }
 
 func createAmazonSNSHook(client *github.Client, owner, repo, snsTopic, awsKey, awsSecret, awsRegion string) error {
@@ -185,7 +394,7 @@ func githubAmazonSNSHookStruct(snsTopic, awsKey, awsSecret, awsRegion string) *g
 	active := true
 	return &github.Hook{
 		Name:   &name,
-		Events: []string{"push", "pull_request"},
+		Events: []string{"push", "pull_request", "release", "issue_comment"},
 		Active: &active,
 		Config: map[string]interface{}{
 			"sns_topic":  snsTopic,
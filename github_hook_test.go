@@ -5,7 +5,9 @@ package grim
 // license that can be found in the LICENSE file.
 
 import (
+	"encoding/json"
 	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -16,21 +18,41 @@ func TestPushHook(t *testing.T) {
 	}
 
 	expected := hookEvent{
-		EventName: "push",
-		Action:    "",
-		UserName:  "bhand-mm",
-		Owner:     "MediaMath",
-		Repo:      "grim",
-		Target:    "test",
-		Ref:       "ade10d0a64f122d095e1b33cdb5719099f542288",
-		StatusRef: "ade10d0a64f122d095e1b33cdb5719099f542288",
-		URL:       "https://github.com/MediaMath/grim/compare/d6bc37a5a405...ade10d0a64f1",
-		PrNumber:  0,
+		EventName:    "push",
+		Action:       "",
+		UserName:     "bhand-mm",
+		Owner:        "MediaMath",
+		Repo:         "grim",
+		Target:       "test",
+		Ref:          "ade10d0a64f122d095e1b33cdb5719099f542288",
+		StatusRef:    "ade10d0a64f122d095e1b33cdb5719099f542288",
+		URL:          "https://github.com/MediaMath/grim/compare/d6bc37a5a405...ade10d0a64f1",
+		PrNumber:     0,
+		ChangedFiles: []string{"test"},
 	}
 
 	failIfDifferent(t, *hook, expected)
 }
 
+func TestTruncatedPushHasUnknownChangedFiles(t *testing.T) {
+	parsed := new(githubHook)
+	if err := json.Unmarshal([]byte(`{"commits": [{"modified": ["README.md"]}]}`), parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	if files := parsed.changedFiles(); !reflect.DeepEqual(files, []string{"README.md"}) {
+		t.Errorf("expected the files of the commits but got %v", files)
+	}
+
+	for len(parsed.Commits) < maxPushCommits {
+		parsed.Commits = append(parsed.Commits, parsed.Commits[0])
+	}
+
+	if files := parsed.changedFiles(); files != nil {
+		t.Errorf("expected the changed files of a push with %v commits to be unknown but got %v", maxPushCommits, files)
+	}
+}
+
 func TestPullRequestHook(t *testing.T) {
 	hook, err := extractHookEvent(prBody)
 	if err != nil {
@@ -48,11 +70,122 @@ func TestPullRequestHook(t *testing.T) {
 		StatusRef: "566f52c6f30600abe63cd43ffbb74a2da30dba68",
 		URL:       "https://github.com/MediaMath/grim/pull/34",
 		PrNumber:  34,
+		HeadRepo:  "bhand-mm/grim",
 	}
 
 	failIfDifferent(t, *hook, expected)
 }
 
+func TestTagPushHook(t *testing.T) {
+	hook, err := extractHookEvent(snsBody(t, `{
+		"ref": "refs/tags/v1.2",
+		"compare": "https://github.com/MediaMath/grim/compare/v1.2",
+		"head_commit": {"id": "ade10d0a64f122d095e1b33cdb5719099f542288"},
+		"commits": [],
+		"sender": {"login": "bhand-mm"},
+		"repository": {"name": "grim", "owner": {"name": "MediaMath"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := hookEvent{
+		EventName: "tag",
+		UserName:  "bhand-mm",
+		Owner:     "MediaMath",
+		Repo:      "grim",
+		Target:    "v1.2",
+		Ref:       "ade10d0a64f122d095e1b33cdb5719099f542288",
+		StatusRef: "ade10d0a64f122d095e1b33cdb5719099f542288",
+		URL:       "https://github.com/MediaMath/grim/compare/v1.2",
+		Tag:       "v1.2",
+	}
+
+	failIfDifferent(t, *hook, expected)
+}
+
+func TestReleaseHook(t *testing.T) {
+	hook, err := extractHookEvent(snsBody(t, `{
+		"action": "published",
+		"release": {"html_url": "https://github.com/MediaMath/grim/releases/v1.2", "tag_name": "v1.2", "name": "1.2"},
+		"sender": {"login": "bhand-mm"},
+		"repository": {"name": "grim", "owner": {"login": "MediaMath"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := hookEvent{
+		EventName: "release",
+		Action:    "published",
+		UserName:  "bhand-mm",
+		Owner:     "MediaMath",
+		Repo:      "grim",
+		Target:    "v1.2",
+		Ref:       "v1.2",
+		URL:       "https://github.com/MediaMath/grim/releases/v1.2",
+		Tag:       "v1.2",
+	}
+
+	failIfDifferent(t, *hook, expected)
+}
+
+func TestIssueCommentHook(t *testing.T) {
+	hook, err := extractHookEvent(snsBody(t, `{
+		"action": "created",
+		"issue": {"number": 7, "pull_request": {"url": "https://api.github.com/repos/MediaMath/grim/pulls/7"}},
+		"comment": {"body": "/grim ok-to-test"},
+		"sender": {"login": "bhand-mm"},
+		"repository": {"name": "grim", "owner": {"login": "MediaMath"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := hookEvent{
+		EventName: "issue_comment",
+		Action:    "created",
+		UserName:  "bhand-mm",
+		Owner:     "MediaMath",
+		Repo:      "grim",
+		PrNumber:  7,
+		Comment:   "/grim ok-to-test",
+	}
+
+	failIfDifferent(t, *hook, expected)
+}
+
+func TestLabeledPullRequestHook(t *testing.T) {
+	hook, err := extractHookEvent(snsBody(t, `{
+		"action": "labeled",
+		"number": 7,
+		"label": {"name": "ok-to-test"},
+		"pull_request": {
+			"head": {"sha": "abc", "repo": {"full_name": "someone/grim"}},
+			"base": {"ref": "master"},
+			"labels": [{"name": "bug"}, {"name": "ok-to-test"}]
+		},
+		"sender": {"login": "bhand-mm"},
+		"repository": {"name": "grim", "owner": {"login": "MediaMath"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hook.EventName != "pull_request" || hook.Label != "ok-to-test" || !reflect.DeepEqual(hook.Labels, []string{"bug", "ok-to-test"}) || !hook.fromFork() {
+		t.Errorf("labeled pull request was not parsed: %+v", hook)
+	}
+}
+
+func snsBody(t *testing.T, message string) string {
+	bs, err := json.Marshal(hookWrapper{Message: message})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(bs)
+}
+
 func failIfDifferent(t *testing.T, first, second hookEvent) {
 	firstStr := fmt.Sprintf("%+v", first)
 	secondStr := fmt.Sprintf("%+v", second)
@@ -85,3 +218,23 @@ var (
   "UnsubscribeURL" : "https://sns.us-east-1.amazonaws.com/?Action=Unsubscribe&SubscriptionArn=arn:aws:sns:us-east-1:888665229551:grim-MediaMath-grim-repo-topic:7feefee7-4a04-486d-8d41-fd1b08dbb26c"
 }`
 )
+
+func TestAmazonSNSHookDrift(t *testing.T) {
+	want := githubAmazonSNSHookStruct("arn:topic", "key", "secret", "us-east-1")
+
+	if drift := amazonSNSHookDrift(nil, want); !reflect.DeepEqual(drift, []string{"missing"}) {
+		t.Errorf("expected a missing hook but got %v", drift)
+	}
+
+	same := githubAmazonSNSHookStruct("arn:topic", "key", "********", "us-east-1")
+	same.Events = []string{"release", "push", "issue_comment", "pull_request"}
+	if drift := amazonSNSHookDrift(same, want); drift != nil {
+		t.Errorf("expected no drift but got %v", drift)
+	}
+
+	drifted := githubAmazonSNSHookStruct("arn:old-topic", "old-key", "secret", "us-east-1")
+	drifted.Events = []string{"push"}
+	if drift := amazonSNSHookDrift(drifted, want); !reflect.DeepEqual(drift, []string{"events", "sns_topic", "aws_key"}) {
+		t.Errorf("expected events, topic and key drift but got %v", drift)
+	}
+}
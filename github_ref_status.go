@@ -27,7 +27,12 @@ func setRefStatus(token, owner, repo, ref string, statusBefore *github.RepoStatu
 		return err
 	}
 
-	repoStatus, res, err := client.Repositories.CreateStatus(context.Background(), owner, repo, ref, statusBefore)
+	var repoStatus *github.RepoStatus
+	var res *github.Response
+	err = defaultRetryPolicy.do(func() (err error) {
+		repoStatus, res, err = client.Repositories.CreateStatus(context.Background(), owner, repo, ref, statusBefore)
+		return
+	})
 	if err != nil {
 		return err
 	}
@@ -44,26 +49,58 @@ func setRefStatus(token, owner, repo, ref string, statusBefore *github.RepoStatu
 }
 
 func getMergeCommitSha(token, owner, repo string, number int64) (string, error) {
-	client, err := getClientForToken(token)
+	pull, err := getPullRequest(token, owner, repo, number)
 	if err != nil {
 		return "", err
 	}
 
+	return pull.MergeCommitSha, nil
+}
+
+func getPullRequest(token, owner, repo string, number int64) (*pullRequest, error) {
+	client, err := getClientForToken(token)
+	if err != nil {
+		return nil, err
+	}
+
 	u := fmt.Sprintf("repos/%v/%v/pulls/%d", owner, repo, int(number))
 	req, err := client.NewRequest("GET", u, nil)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	pull := new(pullRequest)
 	_, err = client.Do(context.Background(), req, pull)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if pull == nil {
-		return "", fmt.Errorf("github client returned nil for pull request")
+		return nil, fmt.Errorf("github client returned nil for pull request")
 	}
 
-	return pull.MergeCommitSha, nil
+	return pull, nil
+}
+
+func getCommitSha(token, owner, repo, ref string) (string, error) {
+	client, err := getClientForToken(token)
+	if err != nil {
+		return "", err
+	}
+
+	u := fmt.Sprintf("repos/%v/%v/commits/%v", owner, repo, ref)
+	req, err := client.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+
+	commit := new(struct {
+		Sha string `json:"sha"`
+	})
+	_, err = client.Do(context.Background(), req, commit)
+	if err != nil {
+		return "", err
+	}
+
+	return commit.Sha, nil
 }
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"time"
 )
@@ -15,29 +16,60 @@ import (
 var errNilGlobalConfig = fmt.Errorf("global config was nil")
 
 func readGlobalConfig(configRoot string) (gc globalConfig, err error) {
-	gc = make(globalConfig)
+	raw := make(configMap)
+	var credentialEnv []string
 
 	bs, err := ioutil.ReadFile(filepath.Join(configRoot, configFileName))
 	if err == nil {
-		err = json.Unmarshal(bs, &gc)
+		err = json.Unmarshal(bs, &raw)
+	}
+
+	if err == nil {
+		applyEnvOverrides(raw, os.LookupEnv)
+		credentialEnv, err = resolveCredentials(raw, globalSettingsFields, configRoot)
+	}
+
+	if err == nil {
+		gc, err = newGlobalConfig(raw)
+		gc.credentialEnv = credentialEnv
 	}
 
 	return
 }
 
-type globalConfig configMap
+// globalConfig is the global config.json as read and decoded into its settings.
+type globalConfig struct {
+	raw      configMap
+	settings globalSettings
+
+	// the environment variables its credentials were read from
+	credentialEnv []string
+}
+
+// newGlobalConfig decodes the settings of the global config.json.  The settings that have a
+// value of the wrong type are left unset and returned as settingsErrors.
+func newGlobalConfig(raw configMap) (globalConfig, error) {
+	gc := globalConfig{raw: raw}
+	_, errs := decodeSettings(raw, &gc.settings)
+	return gc, settingsError(errs)
+}
 
 func (gc globalConfig) errors() (errs []error) {
 	if gc.awsRegion() == "" {
 		errs = append(errs, fmt.Errorf("AWS region is required"))
 	}
 
-	if gc.awsKey() == "" {
-		errs = append(errs, fmt.Errorf("AWS key is required"))
+	// without either the default credential chain is used
+	if gc.awsKey() != "" && gc.awsSecret() == "" {
+		errs = append(errs, fmt.Errorf("AWS secret is required with an AWS key"))
+	}
+
+	if gc.awsSecret() != "" && gc.awsKey() == "" {
+		errs = append(errs, fmt.Errorf("AWS key is required with an AWS secret"))
 	}
 
-	if gc.awsSecret() == "" {
-		errs = append(errs, fmt.Errorf("AWS secret is required"))
+	if err := cancelServerError(gc); err != nil {
+		errs = append(errs, err)
 	}
 
 	return
@@ -65,47 +97,104 @@ func (gc globalConfig) warnings() (errs []error) {
 }
 
 func (gc globalConfig) grimQueueName() string {
-	return readStringWithDefaults(gc, "GrimQueueName", defaultGrimQueueName)
+	return firstString(defaultGrimQueueName, gc.settings.GrimQueueName)
 }
 
 func (gc globalConfig) resultRoot() string {
-	return readStringWithDefaults(gc, "ResultRoot", defaultResultRoot)
+	return firstString(defaultResultRoot, gc.settings.ResultRoot)
 }
 
 func (gc globalConfig) workspaceRoot() string {
-	return readStringWithDefaults(gc, "WorkspaceRoot", defaultWorkspaceRoot)
+	return firstString(defaultWorkspaceRoot, gc.settings.WorkspaceRoot)
 }
 
 func (gc globalConfig) awsRegion() string {
-	return readStringWithDefaults(gc, "AWSRegion")
+	return firstString("", gc.settings.AWSRegion)
 }
 
 func (gc globalConfig) awsKey() string {
-	return readStringWithDefaults(gc, "AWSKey")
+	return firstString("", gc.settings.AWSKey)
 }
 
 func (gc globalConfig) awsSecret() string {
-	return readStringWithDefaults(gc, "AWSSecret")
+	return firstString("", gc.settings.AWSSecret)
+}
+
+func (gc globalConfig) awsProfile() string {
+	return firstString("", gc.settings.AWSProfile)
+}
+
+func (gc globalConfig) awsRoleARN() string {
+	return firstString("", gc.settings.AWSRoleARN)
+}
+
+func (gc globalConfig) awsEndpoint() string {
+	return firstString("", gc.settings.AWSEndpoint)
+}
+
+func (gc globalConfig) awsSQSEndpoint() string {
+	return firstString("", gc.settings.AWSSQSEndpoint)
+}
+
+func (gc globalConfig) awsSNSEndpoint() string {
+	return firstString("", gc.settings.AWSSNSEndpoint)
+}
+
+func (gc globalConfig) awsConfig() awsConfig {
+	return awsConfig{
+		region:      gc.awsRegion(),
+		key:         gc.awsKey(),
+		secret:      gc.awsSecret(),
+		profile:     gc.awsProfile(),
+		roleARN:     gc.awsRoleARN(),
+		endpoint:    gc.awsEndpoint(),
+		sqsEndpoint: gc.awsSQSEndpoint(),
+		snsEndpoint: gc.awsSNSEndpoint(),
+	}
 }
 
 func (gc globalConfig) gitHubToken() string {
-	return readStringWithDefaults(gc, "GitHubToken")
+	return firstString("", gc.settings.GitHubToken)
 }
 
 func (gc globalConfig) snsTopicName() string {
-	return readStringWithDefaults(gc, "SNSTopicName")
+	return firstString("", gc.settings.SNSTopicName)
 }
 
 func (gc globalConfig) hipChatRoom() string {
-	return readStringWithDefaults(gc, "HipChatRoom")
+	return firstString("", gc.settings.HipChatRoom)
+}
+
+func (gc globalConfig) secretsKeyFile() string {
+	return firstString("", gc.settings.SecretsKeyFile)
+}
+
+func (gc globalConfig) cgroupRoot() string {
+	return firstString("", gc.settings.CgroupRoot)
+}
+
+func (gc globalConfig) cancelSocket() string {
+	return firstString(defaultCancelSocket, gc.settings.CancelSocket)
+}
+
+func (gc globalConfig) httpAddress() string {
+	return firstString("", gc.settings.HTTPAddress)
+}
+
+func (gc globalConfig) httpCertFile() string {
+	return firstString("", gc.settings.HTTPCertFile)
+}
+
+func (gc globalConfig) httpKeyFile() string {
+	return firstString("", gc.settings.HTTPKeyFile)
 }
 
 func (gc globalConfig) hipChatToken() string {
-	return readStringWithDefaults(gc, "HipChatToken")
+	return firstString("", gc.settings.HipChatToken)
 }
 
 func (gc globalConfig) hipChatVersion() int {
-	return readIntWithDefaults(gc, "HipChatVersion", defaultHipChatVersion)
+	return firstInt(defaultHipChatVersion, gc.settings.HipChatVersion)
 }
 
 func (gc globalConfig) grimServerID() string {
@@ -117,15 +206,17 @@ func (gc globalConfig) grimServerID() string {
 }
 
 func (gc globalConfig) rawGrimServerID() string {
-	return readStringWithDefaults(gc, "GrimServerID", gc.grimQueueName(), defaultGrimQueueName)
+	return firstString(gc.grimQueueName(), gc.settings.GrimServerID)
 }
 
 func (gc globalConfig) grimServerIDSource() string {
-	if _, ok := gc["GrimServerID"]; ok {
+	settings := gc.settings
+
+	if settings.GrimServerID != nil {
 		return "GrimServerID"
 	}
 
-	if _, ok := gc["GrimQueueName"]; ok {
+	if settings.GrimQueueName != nil {
 		return "GrimQueueName"
 	}
 
@@ -137,45 +228,90 @@ func (gc globalConfig) grimServerIDWasTruncated() bool {
 }
 
 func (gc globalConfig) pendingTemplate() string {
-	return readStringWithDefaults(gc, "PendingTemplate", *defaultTemplateForStart)
+	return firstString(*defaultTemplateForStart, gc.settings.PendingTemplate)
 }
 
 func (gc globalConfig) errorTemplate() string {
-	return readStringWithDefaults(gc, "ErrorTemplate", *defaultTemplateForError)
+	return firstString(*defaultTemplateForError, gc.settings.ErrorTemplate)
 }
 
 func (gc globalConfig) successTemplate() string {
-	return readStringWithDefaults(gc, "SuccessTemplate", *defaultTemplateForSuccess)
+	return firstString(*defaultTemplateForSuccess, gc.settings.SuccessTemplate)
 }
 
 func (gc globalConfig) successColor() string {
-	return readStringWithDefaults(gc, "SuccessColor", *defaultColorForSuccess)
+	return firstString(*defaultColorForSuccess, gc.settings.SuccessColor)
 }
 
 func (gc globalConfig) errorColor() string {
-	return readStringWithDefaults(gc, "ErrorColor", *defaultColorForError)
+	return firstString(*defaultColorForError, gc.settings.ErrorColor)
 }
 
 func (gc globalConfig) failureColor() string {
-	return readStringWithDefaults(gc, "FailureColor", *defaultColorForFailure)
+	return firstString(*defaultColorForFailure, gc.settings.FailureColor)
+}
+
+func (gc globalConfig) skippedColor() string {
+	return firstString(*defaultColorForSkipped, gc.settings.SkippedColor)
 }
 
 func (gc globalConfig) pendingColor() string {
-	return readStringWithDefaults(gc, "PendingColor", *defaultColorForPending)
+	return firstString(*defaultColorForPending, gc.settings.PendingColor)
+}
+
+func (gc globalConfig) tagTemplate() string {
+	return firstString(*defaultTemplateForTag, gc.settings.TagTemplate)
+}
+
+func (gc globalConfig) limitTemplate() string {
+	return firstString(*defaultTemplateForLimit, gc.settings.LimitTemplate)
+}
+
+func (gc globalConfig) timeoutTemplate() string {
+	return firstString(*defaultTemplateForTimeout, gc.settings.TimeoutTemplate)
+}
+
+func (gc globalConfig) skippedTemplate() string {
+	return firstString(*defaultTemplateForSkipped, gc.settings.SkippedTemplate)
 }
 
 func (gc globalConfig) failureTemplate() string {
-	return readStringWithDefaults(gc, "FailureTemplate", *defaultTemplateForFailure)
+	return firstString(*defaultTemplateForFailure, gc.settings.FailureTemplate)
 }
 
-func (gc globalConfig) timeout() (to time.Duration) {
-	val := readIntWithDefaults(gc, "Timeout")
+// authorizationCacheTTL may be set to 0 to not cache membership at all.
+func (gc globalConfig) authorizationCacheTTL() time.Duration {
+	if val := gc.settings.AuthorizationCacheTTL; val != nil && *val >= 0 {
+		return time.Duration(*val) * time.Second
+	}
+
+	return defaultAuthorizationCacheTTL
+}
 
-	if val > 0 {
-		to = time.Duration(val) * time.Second
-	} else {
-		to = defaultTimeout
+func (gc globalConfig) timeout() time.Duration {
+	if val := gc.settings.Timeout; val != nil && *val > 0 {
+		return time.Duration(*val) * time.Second
 	}
 
-	return
+	return defaultTimeout
+}
+
+// configPollInterval is 0 when ConfigPollInterval is 0 or less so the config is only
+// reloaded on SIGHUP.
+func (gc globalConfig) configPollInterval() time.Duration {
+	val := firstInt(int(defaultConfigPollInterval/time.Second), gc.settings.ConfigPollInterval)
+	if val <= 0 {
+		return 0
+	}
+
+	return time.Duration(val) * time.Second
+}
+
+// timeoutGracePeriod may be set to 0 to kill timed out builds straight away.
+func (gc globalConfig) timeoutGracePeriod() time.Duration {
+	if val := gc.settings.TimeoutGracePeriod; val != nil && *val >= 0 {
+		return time.Duration(*val) * time.Second
+	}
+
+	return defaultTimeoutGracePeriod
 }
@@ -15,16 +15,31 @@ import (
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+// maxMessageReceives is how many times a message that keeps failing with a transient error
+// is received from the Grim queue before it is dropped.
+const maxMessageReceives = 5
+
 // Instance models the state of a configured Grim instance.
 type Instance struct {
 	configRoot *string
 	queue      *sqsQueue
+
+	provisioner provisioner
+	global      globalConfig
+	provisioned map[repo]provisionedRepo
+	unreadable  map[repo]string
+	keptTopics  []string
+	missing     map[repo]int // how many reloads in a row a provisioned repo's directory has been missing from
 }
 
 // SetConfigRoot sets the base path of the configuration directory and clears any previously read config values from memory.
 func (i *Instance) SetConfigRoot(path string) {
 	i.configRoot = &path
 	i.queue = nil
+	i.global = globalConfig{}
+	i.provisioned = nil
+	i.unreadable = nil
+	i.missing = nil
 }
 
 // PrepareGrimQueue creates or reuses the Amazon SQS queue named in the config.
@@ -33,14 +48,14 @@ func (i *Instance) PrepareGrimQueue(logger *log.Logger) error {
 
 	config, err := readGlobalConfig(configRoot)
 	if err != nil {
-		return fatalGrimErrorf("error while reading config: %v", err)
+		return fatalGrimErrorf("error while reading config: %v", err).withKind(ConfigError)
 	}
 
 	if config.grimServerIDWasTruncated() {
 		logger.Printf(buildTruncatedMessage(config.grimServerIDSource()))
 	}
 
-	queue, err := prepareSQSQueue(config.awsKey(), config.awsSecret(), config.awsRegion(), config.grimQueueName())
+	queue, err := prepareSQSQueue(config.awsConfig(), config.grimQueueName())
 	if err != nil {
 		return fatalGrimErrorf("error preparing queue: %v", err)
 	}
@@ -50,83 +65,125 @@ func (i *Instance) PrepareGrimQueue(logger *log.Logger) error {
 	return nil
 }
 
-// PrepareRepos discovers all repos that are configured then sets up SNS and GitHub.
-// It is an error to call this without calling PrepareGrimQueue first.
-func (i *Instance) PrepareRepos() error {
+// BuildNextInGrimQueue creates or reuses an SQS queue as a source of work.
+func (i *Instance) BuildNextInGrimQueue(logger *log.Logger) error {
 	if err := i.checkGrimQueue(); err != nil {
 		return err
 	}
 
 	configRoot := getEffectiveConfigRoot(i.configRoot)
 
-	config, err := readGlobalConfig(configRoot)
+	globalConfig, err := readGlobalConfig(configRoot)
 	if err != nil {
-		return fatalGrimErrorf("error while reading config: %v", err)
+		return grimErrorf("error while reading config: %v", err).withKind(ConfigError)
 	}
 
-	repos := getAllConfiguredRepos(configRoot)
+	message, err := getNextMessage(globalConfig.awsConfig(), i.queue.URL)
+	if Kind(err) == AuthError {
+		// grimd can't do anything without its queue
+		return fatalGrimErrorf("error retrieving message from Grim queue %q: %v", i.queue.URL, err)
+	} else if err != nil {
+		return grimErrorf("error retrieving message from Grim queue %q: %v", i.queue.URL, err)
+	} else if message == nil {
+		return nil
+	}
 
-	var topicARNs []string
-	for _, repo := range repos {
-		localConfig, err := readLocalConfig(configRoot, repo.owner, repo.name)
-		if err != nil {
-			return fatalGrimErrorf("Error with config for %s/%s. %v", repo.owner, repo.name, err)
+	err = buildFromMessage(configRoot, globalConfig, message.body, logger)
+	if redeliver(err, message) {
+		// the message is left on the queue to be delivered again once its visibility timeout is up
+		return err
+	}
+
+	if deleteErr := deleteNextMessage(globalConfig.awsConfig(), i.queue.URL, message); deleteErr != nil {
+		deleteErr = grimErrorf("error deleting message from Grim queue %q: %v", i.queue.URL, deleteErr)
+		if err == nil {
+			return deleteErr
 		}
+		logger.Print(deleteErr)
+	}
 
-		snsTopicARN, err := prepareSNSTopic(config.awsKey(), config.awsSecret(), config.awsRegion(), localConfig.snsTopicName())
+	return err
+}
+
+// redeliver is whether a message whose hook failed with err is left on the Grim queue to be
+// built again rather than deleted.
+func redeliver(err error, message *queueMessage) bool {
+	return IsTransient(err) && message.receiveCount < maxMessageReceives
+}
+
+// buildFromMessage builds the hook in a message from the Grim queue.
+func buildFromMessage(configRoot string, globalConfig globalConfig, message string, logger *log.Logger) error {
+	if message != "" {
+		hook, err := extractHookEvent(message)
 		if err != nil {
-			return fatalGrimErrorf("error creating SNS Topic %s for %s/%s topic: %v", localConfig.snsTopicName, repo.owner, repo.name, err)
+			return grimErrorf("error extracting hook from message: %v", err)
 		}
 
-		err = prepareSubscription(config.awsKey(), config.awsSecret(), config.awsRegion(), snsTopicARN, i.queue.ARN)
-		if err != nil {
-			return fatalGrimErrorf("error subscribing Grim queue %q to SNS topic %q: %v", i.queue.ARN, snsTopicARN, err)
+		if skipReason := shouldSkip(hook); skipReason != nil {
+			logger.Printf("hook skipped %v: %s\n", *skipReason, hook.Describe())
+			return nil
 		}
 
-		err = prepareAmazonSNSService(localConfig.gitHubToken(), repo.owner, repo.name, snsTopicARN, config.awsKey(), config.awsSecret(), config.awsRegion())
+		localConfig, err := readLocalConfig(configRoot, hook.Owner, hook.Repo)
 		if err != nil {
-			return fatalGrimErrorf("error creating configuring GitHub AmazonSNS service: %v", err)
+			return grimErrorf("error while reading config: %v", err).withKind(ConfigError)
 		}
 
-		topicARNs = append(topicARNs, snsTopicARN)
-	}
-
-	err = setPolicy(config.awsKey(), config.awsSecret(), config.awsRegion(), i.queue.ARN, i.queue.URL, topicARNs)
-	if err != nil {
-		return fatalGrimErrorf("error setting policy for Grim queue %q with topics %v: %v", i.queue.ARN, topicARNs, err)
-	}
+		if hook.EventName == "issue_comment" {
+			pull, err := getPullRequest(localConfig.gitHubToken(), hook.Owner, hook.Repo, hook.PrNumber)
+			if err != nil {
+				return grimErrorf("error getting pull request: %v", err)
+			}
+			*hook = pullRequestHookForComment(*hook, pull)
+		}
 
-	return nil
-}
+		if hook.EventName == "pull_request" && localConfig.hasPathFilters() {
+			files, err := listPullRequestFiles(localConfig.gitHubToken(), hook.Owner, hook.Repo, hook.PrNumber)
+			if err != nil {
+				notifyWithReason(localConfig, *hook, "", "couldn't list the files of the pull request for the path filters", GrimError, logger)
+				return grimErrorf("error listing pull request files: %v", err)
+			}
+			hook.ChangedFiles = files
+		}
 
-// BuildNextInGrimQueue creates or reuses an SQS queue as a source of work.
-func (i *Instance) BuildNextInGrimQueue(logger *log.Logger) error {
-	if err := i.checkGrimQueue(); err != nil {
-		return err
-	}
+		if skipReason := localConfig.hookSkipReason(*hook); skipReason != nil {
+			logger.Printf("hook skipped %v: %s\n", *skipReason, hook.Describe())
+			return nil
+		}
 
-	configRoot := getEffectiveConfigRoot(i.configRoot)
+		approved := func() (bool, error) {
+			return forkApproved(localConfig.gitHubToken(), *hook, localConfig.approvalLabel())
+		}
 
-	globalConfig, err := readGlobalConfig(configRoot)
-	if err != nil {
-		return grimErrorf("error while reading config: %v", err)
-	}
+		skipReason, awaitingApproval, err := forkSkipReason(localConfig, *hook, approved)
+		if err != nil {
+			return grimErrorf("error checking the fork policy: %v", err)
+		} else if skipReason != nil {
+			logger.Printf("hook skipped %v: %s\n", *skipReason, hook.Describe())
+			if awaitingApproval {
+				return notify(localConfig, *hook, "", "", GrimAwaitingApproval, logger)
+			}
+			return nil
+		}
 
-	message, err := getNextMessage(globalConfig.awsKey(), globalConfig.awsSecret(), globalConfig.awsRegion(), i.queue.URL)
-	if err != nil {
-		return grimErrorf("error retrieving message from Grim queue %q: %v", i.queue.URL, err)
-	}
+		if hook.EventName == "release" {
+			sha, err := getCommitSha(localConfig.gitHubToken(), hook.Owner, hook.Repo, hook.Tag)
+			if err != nil {
+				return grimErrorf("error getting the commit sha of tag %q: %v", hook.Tag, err)
+			}
+			hook.StatusRef = sha
+		}
 
-	if message != "" {
-		hook, err := extractHookEvent(message)
+		unauthorized, err := authorize(localConfig, *hook, logger)
 		if err != nil {
-			return grimErrorf("error extracting hook from message: %v", err)
+			// failing to look up the user isn't a denial, and keeps its kind so that grimd backs off
+			notifyWithReason(localConfig, *hook, "", fmt.Sprintf("couldn't check the permissions of %v", hook.UserName), GrimError, logger)
+			return grimErrorf("error checking the permissions of %v for %s: %v", hook.UserName, hook.Describe(), err)
+		} else if unauthorized != nil {
+			notifyWithReason(localConfig, *hook, "", *unauthorized, GrimUnauthorized, logger)
+			return grimErrorf("not building %s: %v", hook.Describe(), *unauthorized)
 		}
 
-		if skipReason := shouldSkip(hook); skipReason != nil {
-			logger.Printf("hook skipped %v: %s\n", skipReason, hook.Describe())
-			return nil
-		}
 		logger.Printf("hook built: %s\n", hook.Describe())
 
 		if hook.EventName == "pull_request" {
@@ -139,27 +196,29 @@ func (i *Instance) BuildNextInGrimQueue(logger *log.Logger) error {
 			hook.Ref = sha
 		}
 
-		localConfig, err := readLocalConfig(configRoot, hook.Owner, hook.Repo)
-		if err != nil {
-			return grimErrorf("error while reading config: %v", err)
-		}
-
-		if localConfig.usernameCanBuild(hook.UserName) {
-			return buildForHook(configRoot, localConfig, *hook, logger)
-		}
-		return grimErrorf("username %q is not permitted to build", hook.UserName)
+		return buildForHook(configRoot, localConfig, *hook, logger)
 	}
 
 	return nil
 }
 
+// authorize checks the user who triggered a hook against the repo's build permissions.
+func authorize(config localConfig, hook hookEvent, logger *log.Logger) (*string, error) {
+	checker, err := newGitHubMembership(config.gitHubToken())
+	if err != nil {
+		return nil, err
+	}
+
+	return authorizationSkipReason(config, hook.UserName, cachedMembership{checker, authorizationCache, config.authorizationCacheTTL()}, logger)
+}
+
 // BuildRef builds a git ref immediately.
 func (i *Instance) BuildRef(owner, repo, ref string, logger *log.Logger) error {
 	configRoot := getEffectiveConfigRoot(i.configRoot)
 
 	config, err := readLocalConfig(configRoot, owner, repo)
 	if err != nil {
-		return fatalGrimErrorf("error while reading config: %v", err)
+		return fatalGrimErrorf("error while reading config: %v", err).withKind(ConfigError)
 	}
 
 	return buildForHook(configRoot, config, hookEvent{
@@ -169,15 +228,17 @@ func (i *Instance) BuildRef(owner, repo, ref string, logger *log.Logger) error {
 	}, logger)
 }
 
-func buildOnHook(configRoot string, resultPath string, config localConfig, hook hookEvent, basename string) (*executeResult, string, error) {
-	return build(config.gitHubToken(), configRoot, config.workspaceRoot(), resultPath, config.pathToCloneIn(), hook.Owner, hook.Repo, hook.Ref, hook.env(), basename, config.timeout())
+func buildOnHook(configRoot string, resultPath string, config localConfig, hook hookEvent, basename string, running *runningBuild) (*executeResult, string, error) {
+	env := append(hook.env(), hook.MatrixEnv...)
+
+	return build(configRoot, resultPath, config, hook, env, basename, running)
 }
 
 func buildForHook(configRoot string, config localConfig, hook hookEvent, logger *log.Logger) error {
 	return onHookBuild(configRoot, config, hook, logger, buildOnHook)
 }
 
-type hookAction func(string, string, localConfig, hookEvent, string) (*executeResult, string, error)
+type hookAction func(string, string, localConfig, hookEvent, string, *runningBuild) (*executeResult, string, error)
 
 func writeHookEvent(resultPath string, hook hookEvent) error {
 	hookFile := filepath.Join(resultPath, "hook.json")
@@ -191,6 +252,11 @@ func writeHookEvent(resultPath string, hook hookEvent) error {
 }
 
 func onHookBuild(configRoot string, config localConfig, hook hookEvent, logger *log.Logger, action hookAction) error {
+	cells, err := config.matrix()
+	if err != nil {
+		return grimErrorf("error in build matrix for %v/%v: %v", hook.Owner, hook.Repo, err).withKind(ConfigError)
+	}
+
 	basename := getTimeStamp()
 	resultPath, err := makeTree(config.resultRoot(), hook.Owner, hook.Repo, basename)
 	if err != nil {
@@ -200,16 +266,77 @@ func onHookBuild(configRoot string, config localConfig, hook hookEvent, logger *
 	// TODO: do something with this err
 	writeHookEvent(resultPath, hook)
 
+	running := runningBuilds.start(hook.Owner, hook.Repo, basename)
+	defer runningBuilds.finish(running)
+
+	if len(cells) == 0 {
+		return onCellBuild(configRoot, resultPath, config, hook, basename, running, logger, action)
+	}
+
+	var firstErr error
+	for _, cell := range cells {
+		cellHook := hook
+		cellHook.MatrixCell = cell.name
+		cellHook.MatrixEnv = cell.env
+
+		cellPath, err := makeTree(resultPath, cell.name)
+		if err != nil {
+			return fatalGrimErrorf("error creating result path for matrix cell %v: %v", cell.name, err)
+		}
+
+		writeHookEvent(cellPath, cellHook)
+
+		err = onCellBuild(configRoot, cellPath, config, cellHook, filepath.Join(basename, cell.name), running, logger, action)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func onCellBuild(configRoot, resultPath string, config localConfig, hook hookEvent, basename string, running *runningBuild, logger *log.Logger, action hookAction) error {
 	notify(config, hook, "", resultPath, GrimPending, logger)
 
-	result, ws, err := action(configRoot, resultPath, config, hook, basename)
+	result, ws, err := action(configRoot, resultPath, config, hook, basename, running)
 	if err != nil {
+		if Kind(err) == ConfigError {
+			// the repo's own config is broken, which anyone who can push can do, so it is
+			// reported on the commit rather than stopping grimd
+			sendNotification(config, hook, buildContext(hook, ws, resultPath, err.Error()), GrimError, logger)
+			return grimErrorf("error during %v: %v", hook.Describe(), err)
+		}
+
 		notify(config, hook, ws, resultPath, GrimError, logger)
-		return fatalGrimErrorf("error during %v: %v", hook.Describe(), err)
+		if IsTransient(err) {
+			// retrying has already been tried, so grimd backs off rather than exiting
+			return grimErrorf("error during %v: %v", hook.Describe(), err)
+		}
+		buildErr := fatalGrimErrorf("error during %v: %v", hook.Describe(), err)
+		if buildErr.kind == UnknownError {
+			buildErr.kind = BuildError
+		}
+		return buildErr
+	}
+
+	config = config.withInRepoConfig(result.RepoConfig)
+
+	if result.Cancelled {
+		return notifyWithReason(config, hook, ws, fmt.Sprintf("cancelled by %v", result.CancelledBy), GrimCancelled, logger)
+	}
+
+	if result.SkipReason != "" {
+		return sendNotification(config, hook, buildContext(hook, ws, resultPath, skippedReason(result.SkipReason)), GrimSkipped, logger)
 	}
 
-	gn := GrimFailure
-	if result.ExitCode == 0 {
+	var gn grimNotification = GrimFailure
+	if result.ExitCode != 0 && result.TimedOut {
+		gn = GrimTimeout
+	} else if result.ExitCode != 0 && result.LimitExceeded != "" {
+		gn = limitExceededNotification{result.LimitExceeded}
+	} else if result.ExitCode == 0 && hook.Tag != "" {
+		gn = GrimTagSuccess
+	} else if result.ExitCode == 0 {
 		gn = GrimSuccess
 	}
 
@@ -239,16 +366,31 @@ func shouldSkip(hook *hookEvent) *string {
 	case hook.Deleted:
 		message = getStringPtr("because it was on a deleted branch")
 	case hook.EventName == "push":
+	case hook.EventName == "tag":
+	case hook.EventName == "release":
+		if hook.Action != "published" {
+			message = getStringPtr(fmt.Sprintf("because the release action: %q was not 'published'", hook.Action))
+		}
 	case hook.EventName == "pull_request":
 		switch {
 		case hook.Action == "opened":
 		case hook.Action == "reopened":
 		case hook.Action == "synchronize":
+		case hook.Action == "labeled":
 		default:
-			message = getStringPtr(fmt.Sprintf("because the action: %q was not 'opened', 'reopened', or 'synchronize'", hook.Action))
+			message = getStringPtr(fmt.Sprintf("because the action: %q was not 'opened', 'reopened', 'synchronize' or 'labeled'", hook.Action))
+		}
+	case hook.EventName == "issue_comment":
+		switch {
+		case hook.Action != "created":
+			message = getStringPtr(fmt.Sprintf("because the comment action: %q was not 'created'", hook.Action))
+		case hook.PrNumber == 0:
+			message = getStringPtr("because the comment was not on a pull request")
+		case !isOkToTest(hook.Comment):
+			message = getStringPtr(fmt.Sprintf("because the comment was not %q", okToTestCommand))
 		}
 	default:
-		message = getStringPtr(fmt.Sprintf("because the eventName: %q was not 'push' or 'pull_request'", hook.EventName))
+		message = getStringPtr(fmt.Sprintf("because the eventName: %q was not 'push', 'tag', 'release', 'pull_request' or 'issue_comment'", hook.EventName))
 	}
 
 	return message
@@ -67,14 +67,14 @@ func TestTimeOutConfig(t *testing.T) {
 		t.Errorf("Failed to use non default timeout time")
 	}
 
-	err = doWaitAction(localConfig{global: globalConfig{"ResultRoot": tempDir}}, testOwner, testRepo, 2)
+	err = doWaitAction(localConfig{global: testGlobalConfig(configMap{"ResultRoot": tempDir})}, testOwner, testRepo, 2)
 	if err != nil {
 		t.Errorf("Failed to not timeout: %v", err)
 	}
 }
 
 func doWaitAction(config localConfig, owner, repo string, wait int) error {
-	return onHookBuild("not-used", config, hookEvent{Owner: owner, Repo: repo}, nil, func(r string, resultPath string, c localConfig, h hookEvent, s string) (*executeResult, string, error) {
+	return onHookBuild("not-used", config, hookEvent{Owner: owner, Repo: repo}, nil, func(r string, resultPath string, c localConfig, h hookEvent, s string, b *runningBuild) (*executeResult, string, error) {
 		time.Sleep(time.Duration(wait) * time.Second)
 		return &executeResult{}, "", nil
 	})
@@ -159,6 +159,51 @@ func TestOnActionError(t *testing.T) {
 	}
 }
 
+func TestBrokenRepoConfigIsNotFatal(t *testing.T) {
+	withTempDir(t, func(path string) {
+		if err := ioutil.WriteFile(filepath.Join(path, repoConfigFileName), []byte("timeout: [1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, configErr := readRepoConfig(path)
+		if configErr == nil {
+			t.Fatal("expected the broken repo config to fail to load")
+		}
+
+		config := localConfig{global: testGlobalConfig(configMap{"ResultRoot": path})}
+		err := onHookBuild("not-used", config, hookEvent{Owner: testOwner, Repo: testRepo}, nil, func(r string, resultPath string, c localConfig, h hookEvent, s string, b *runningBuild) (*executeResult, string, error) {
+			return grimBuild(&testBuilder{repoConfigErr: configErr}, resultPath, s)
+		})
+
+		if err == nil || IsFatal(err) || Kind(err) != ConfigError {
+			t.Errorf("expected a broken repo config to be a non-fatal config error but got %v (fatal %v, kind %v)", err, IsFatal(err), Kind(err))
+		}
+	})
+}
+
+func TestRedeliver(t *testing.T) {
+	transient := grimErrorf("throttled").withKind(TransientError)
+
+	cases := []struct {
+		err          error
+		receiveCount int
+		redeliver    bool
+	}{
+		{nil, 1, false},
+		{transient, 1, true},
+		{transient, maxMessageReceives - 1, true},
+		{transient, maxMessageReceives, false},
+		{grimErrorf("bad config").withKind(ConfigError), 1, false},
+		{fatalGrimErrorf("build failed").withKind(BuildError), 1, false},
+	}
+
+	for _, c := range cases {
+		if got := redeliver(c.err, &queueMessage{receiveCount: c.receiveCount}); got != c.redeliver {
+			t.Errorf("expected redeliver of %v after %v receives to be %v", c.err, c.receiveCount, c.redeliver)
+		}
+	}
+}
+
 func TestResultsDirectoryCreatedInOnHook(t *testing.T) {
 	tempDir, _ := ioutil.TempDir("", "results-dir-success")
 	defer os.RemoveAll(tempDir)
@@ -176,7 +221,7 @@ func TestHookGetsLogged(t *testing.T) {
 
 	hook := hookEvent{Owner: testOwner, Repo: testRepo, StatusRef: "fooooooooooooooooooo"}
 
-	err := onHookBuild("not-used", localConfig{global: globalConfig{"ResultRoot": tempDir}}, hook, nil, func(r string, resultPath string, c localConfig, h hookEvent, s string) (*executeResult, string, error) {
+	err := onHookBuild("not-used", localConfig{global: testGlobalConfig(configMap{"ResultRoot": tempDir})}, hook, nil, func(r string, resultPath string, c localConfig, h hookEvent, s string, b *runningBuild) (*executeResult, string, error) {
 		return &executeResult{ExitCode: 0}, "", nil
 	})
 
@@ -225,6 +270,15 @@ func TestShouldSkip(t *testing.T) {
 		{&hookEvent{EventName: "pull_request", Action: "synchronize"}, true},
 		{&hookEvent{EventName: "pull_request", Action: "matters"}, false},
 		{&hookEvent{EventName: "issue", Action: "opened"}, false},
+		{&hookEvent{EventName: "tag"}, true},
+		{&hookEvent{Deleted: true, EventName: "tag"}, false},
+		{&hookEvent{EventName: "release", Action: "published"}, true},
+		{&hookEvent{EventName: "release", Action: "created"}, false},
+		{&hookEvent{EventName: "pull_request", Action: "labeled"}, true},
+		{&hookEvent{EventName: "issue_comment", Action: "created", PrNumber: 7, Comment: "/grim ok-to-test"}, true},
+		{&hookEvent{EventName: "issue_comment", Action: "edited", PrNumber: 7, Comment: "/grim ok-to-test"}, false},
+		{&hookEvent{EventName: "issue_comment", Action: "created", Comment: "/grim ok-to-test"}, false},
+		{&hookEvent{EventName: "issue_comment", Action: "created", PrNumber: 7, Comment: "looks good"}, false},
 	}
 	for _, sT := range skipTests {
 		message := shouldSkip(sT.in)
@@ -239,7 +293,7 @@ func XOR(a, b bool) bool {
 }
 
 func doNothingAction(tempDir, owner, repo string, exitCode int, returnedErr error) error {
-	return onHookBuild("not-used", localConfig{global: globalConfig{"ResultRoot": tempDir}}, hookEvent{Owner: owner, Repo: repo}, nil, func(r string, resultPath string, c localConfig, h hookEvent, s string) (*executeResult, string, error) {
+	return onHookBuild("not-used", localConfig{global: testGlobalConfig(configMap{"ResultRoot": tempDir})}, hookEvent{Owner: owner, Repo: repo}, nil, func(r string, resultPath string, c localConfig, h hookEvent, s string, b *runningBuild) (*executeResult, string, error) {
 		return &executeResult{ExitCode: exitCode}, "", returnedErr
 	})
 }
@@ -0,0 +1,27 @@
+package main
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import "github.com/codegangsta/cli"
+
+func cancel(c *cli.Context) {
+	g := global(c)
+	logger := getLogger()
+
+	args := c.Args()
+	owner, repo, buildID := args.Get(0), args.Get(1), args.Get(2)
+	if owner == "" || repo == "" {
+		logger.Fatal("usage: grimd cancel <owner> <repo> [build-id]")
+	}
+
+	ids, err := g.CancelBuild(owner, repo, buildID)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	for _, id := range ids {
+		logger.Printf("cancelled build %v of %v/%v", id, owner, repo)
+	}
+}
@@ -7,6 +7,7 @@ package main
 import (
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/MediaMath/grim"
@@ -17,33 +18,70 @@ func grimd(c *cli.Context) {
 	g := global(c)
 	logger := getLogger()
 
+	// problems with the config are reported but only stop grimd if it can't start
+	g.ValidateConfig(logger)
+
 	if err := g.PrepareGrimQueue(logger); grim.IsFatal(err) {
 		logger.Fatal(err)
 	} else if err != nil {
 		logger.Print(err)
 	}
 
-	if err := g.PrepareRepos(); grim.IsFatal(err) {
+	if err := g.PrepareRepos(logger); grim.IsFatal(err) {
 		logger.Fatal(err)
 	} else if err != nil {
 		logger.Print(err)
 	}
 
+	if err := g.ServeCancellations(logger); err != nil {
+		logger.Print(err)
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, os.Kill)
 
-	throttle := time.Tick(time.Second) // don't spin faster than once per second
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+
+	throttle := time.Tick(time.Second)        // don't spin faster than once per second
+	poll := time.Tick(g.ConfigPollInterval()) // nil, and never ready, if only reloading on SIGHUP
+
+	var backoff time.Duration // how long to stop polling after transient errors
+	var resume time.Time
 
 	logger.Printf("starting up")
 	for {
 		select {
 		case <-throttle:
-			if err := g.BuildNextInGrimQueue(logger); err != nil {
-				if grim.IsFatal(err) {
-					logger.Fatal(err)
-				} else {
-					logger.Print(err)
+			if time.Now().Before(resume) {
+				continue
+			}
+
+			err := g.BuildNextInGrimQueue(logger)
+			switch {
+			case err == nil:
+				backoff = 0
+			case grim.IsFatal(err):
+				logger.Fatal(err)
+			case grim.IsTransient(err):
+				// a rate limit can ask for a longer wait than the backoff, eg. until the hour is up
+				backoff = nextBackoff(backoff)
+				resume = time.Now().Add(backoff)
+				if retryAt := grim.RetryAt(err); retryAt.After(resume) {
+					resume = retryAt
 				}
+				logger.Printf("%v; backing off for %v", err, time.Until(resume).Round(time.Second))
+			default:
+				logger.Print(err)
+			}
+		case <-poll:
+			if err := g.PollRepos(logger); err != nil {
+				logger.Print(err)
+			}
+		case <-reloadChan:
+			logger.Printf("reloading config")
+			if err := g.ReloadRepos(logger); err != nil {
+				logger.Print(err)
 			}
 		case <-sigChan:
 			logger.Printf("exiting")
@@ -51,3 +89,14 @@ func grimd(c *cli.Context) {
 		}
 	}
 }
+
+// nextBackoff doubles the time grimd stops polling for, up to a minute.
+func nextBackoff(backoff time.Duration) time.Duration {
+	if backoff == 0 {
+		return 2 * time.Second
+	} else if backoff >= 30*time.Second {
+		return time.Minute
+	}
+
+	return 2 * backoff
+}
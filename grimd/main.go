@@ -23,6 +23,58 @@ var (
 			Usage:  "immediately build a repo ref",
 			Action: build,
 		},
+		{
+			Name:   "cancel",
+			Usage:  "cancel a repo's running builds, or only the given build id",
+			Action: cancel,
+		},
+		{
+			Name:   "validate",
+			Usage:  "check the global config and that of every configured repo",
+			Action: validate,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "config-root, c",
+					Usage: "the root directory for grim's configuration, instead of the global one",
+				},
+				cli.BoolFlag{
+					Name:  "check-tokens",
+					Usage: "also ask GitHub and HipChat whether the tokens work",
+				},
+			},
+		},
+		{
+			Name:   "provision",
+			Usage:  "set up the queue and the SNS topics, subscriptions, queue policy and GitHub hooks of every repo",
+			Action: provision,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "dry-run, n",
+					Usage: "only report what differs from how it would be set up",
+				},
+			},
+		},
+		{
+			Name:   "teardown",
+			Usage:  "delete the SNS topics, subscriptions, queue policy and GitHub hooks of every repo, or only the given one",
+			Action: teardown,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "dry-run, n",
+					Usage: "only list what would be deleted",
+				},
+			},
+		},
+		{
+			Name:   "schema",
+			Usage:  "print the JSON Schema of the global or a repo's config.json",
+			Action: schema,
+		},
+		{
+			Name:   "seal-secrets",
+			Usage:  "encrypt a JSON object of secrets read from stdin for a repo",
+			Action: sealSecrets,
+		},
 	}
 	flags = []cli.Flag{
 		cli.StringFlag{
@@ -35,6 +87,9 @@ var (
 )
 
 func main() {
+	// builds with a sandbox or limits are started by re-executing grimd
+	grim.MaybeReexec()
+
 	log.SetOutput(os.Stdout)
 	log.SetPrefix(fmt.Sprintf("grimd-%v ", version))
 	log.SetFlags(log.Ldate | log.Ltime)
@@ -0,0 +1,16 @@
+package main
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import "github.com/codegangsta/cli"
+
+func provision(c *cli.Context) {
+	g := global(c)
+	logger := getLogger()
+
+	if err := g.Provision(c.Bool("dry-run"), logger); err != nil {
+		logger.Fatal(err)
+	}
+}
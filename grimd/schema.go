@@ -0,0 +1,36 @@
+package main
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"os"
+
+	"github.com/MediaMath/grim"
+	"github.com/codegangsta/cli"
+)
+
+func schema(c *cli.Context) {
+	logger := getLogger()
+
+	var (
+		bs  []byte
+		err error
+	)
+
+	switch c.Args().First() {
+	case "global":
+		bs, err = grim.GlobalConfigSchema()
+	case "repo":
+		bs, err = grim.RepoConfigSchema()
+	default:
+		logger.Fatal("usage: grimd schema global|repo")
+	}
+
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	os.Stdout.Write(bs)
+}
@@ -0,0 +1,27 @@
+package main
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"os"
+
+	"github.com/codegangsta/cli"
+)
+
+func sealSecrets(c *cli.Context) {
+	g := global(c)
+	logger := getLogger()
+
+	args := c.Args()
+	owner, repo := args.Get(0), args.Get(1)
+	if owner == "" || repo == "" {
+		logger.Fatal("usage: grimd seal-secrets <owner> <repo> < secrets.json")
+	}
+
+	logger.Printf("encrypting secrets for %v/%v", owner, repo)
+	if err := g.SealSecrets(owner, repo, os.Stdin); err != nil {
+		logger.Fatal(err)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import "github.com/codegangsta/cli"
+
+func teardown(c *cli.Context) {
+	g := global(c)
+	logger := getLogger()
+
+	args := c.Args()
+	owner, repo := args.Get(0), args.Get(1)
+	if (owner == "") != (repo == "") {
+		logger.Fatal("usage: grimd teardown [--dry-run] [<owner> <repo>]")
+	}
+
+	if err := g.Teardown(owner, repo, c.Bool("dry-run"), logger); err != nil {
+		logger.Fatal(err)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import "github.com/codegangsta/cli"
+
+func validate(c *cli.Context) {
+	g := global(c)
+	logger := getLogger()
+
+	if configRoot := c.String("config-root"); configRoot != "" {
+		g.SetConfigRoot(configRoot)
+	}
+
+	validateConfig := g.ValidateConfig
+	if c.Bool("check-tokens") {
+		validateConfig = g.ValidateConfigAndTokens
+	}
+
+	if err := validateConfig(logger); err != nil {
+		logger.Fatal(err)
+	}
+
+	logger.Print("config is valid")
+}
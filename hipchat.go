@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/andybons/hipchat"
@@ -62,6 +63,32 @@ func sendMessageToRoom2(token, roomID, from, message, color string) (err error)
 	return
 }
 
+// getHipChatRoom asks HipChat for the room, which fails if the token is wrong or can't see it.
+func getHipChatRoom(token, roomID string, version int) error {
+	var roomURL string
+	switch version {
+	case 1:
+		roomURL = fmt.Sprintf("https://api.hipchat.com/v1/rooms/show?room_id=%v&auth_token=%v", url.QueryEscape(roomID), url.QueryEscape(token))
+	case 2:
+		roomURL = fmt.Sprintf("https://api.hipchat.com/v2/room/%v?auth_token=%v", url.PathEscape(roomID), url.QueryEscape(token))
+	default:
+		return fmt.Errorf("invalid or unsupported hipchat version")
+	}
+
+	resp, err := http.Get(roomURL)
+	if err != nil {
+		// the url has the token in it
+		return fmt.Errorf("failed to reach HipChat")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HipChat answered with response code %v", resp.StatusCode)
+	}
+
+	return nil
+}
+
 func sanitizeHipchatMessage(message string) string {
 	r := strings.NewReplacer(
 		"\b", `\b`,
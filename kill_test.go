@@ -5,7 +5,10 @@ package grim
 // license that can be found in the LICENSE file.
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -13,9 +16,13 @@ import (
 
 func TestKill(t *testing.T) {
 	timeout := 100 * time.Millisecond
-	_, err := execute([]string{}, ".", "./test_data/tobekilled.sh", timeout)
-	if err != errTimeout {
-		t.Fatalf("expected timeout err but got: %v", err)
+	result, err := execute([]string{}, ".", "./test_data/tobekilled.sh", timeout)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if !result.TimedOut || result.ExitCode != exitCodeTimedOut {
+		t.Fatalf("expected the build to time out but got: %+v", result)
 	}
 
 	time.After(2 * timeout)
@@ -30,3 +37,55 @@ func TestKill(t *testing.T) {
 		t.Fatalf("no sleeps should be running: %v", output)
 	}
 }
+
+func TestGracefulTimeout(t *testing.T) {
+	withTempDir(t, func(path string) {
+		script := filepath.Join(path, "trap.sh")
+		marker := filepath.Join(path, "cleaned-up")
+		contents := fmt.Sprintf("#!/bin/sh\ntrap 'touch %v; exit 1' TERM\nwhile true; do sleep 0.1; done\n", marker)
+		if err := ioutil.WriteFile(script, []byte(contents), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		result, err := executeWithOutputChan(nil, nil, nil, nil, nil, path, script, 200*time.Millisecond, 10*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !result.TimedOut || result.ExitCode != exitCodeTimedOut {
+			t.Errorf("expected the build to time out but got: %+v", result)
+		}
+
+		if !fileExists(marker) {
+			t.Errorf("build was not given the chance to clean up")
+		}
+
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("grim waited out the grace period after the build exited: %v", elapsed)
+		}
+	})
+}
+
+func TestKillAfterGracePeriod(t *testing.T) {
+	withTempDir(t, func(path string) {
+		script := filepath.Join(path, "ignore.sh")
+		if err := ioutil.WriteFile(script, []byte("#!/bin/sh\ntrap '' TERM\nwhile true; do sleep 0.1; done\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		result, err := executeWithOutputChan(nil, nil, nil, nil, nil, path, script, 100*time.Millisecond, 300*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !result.TimedOut {
+			t.Errorf("expected the build to time out but got: %+v", result)
+		}
+
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("build that ignored SIGTERM was not killed: %v", elapsed)
+		}
+	})
+}
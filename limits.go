@@ -0,0 +1,101 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"fmt"
+	"sync"
+)
+
+// the limits a build can exceed, as recorded in its result
+const (
+	limitMemory    = "memory"
+	limitCPU       = "cpu"
+	limitProcesses = "processes"
+	limitOutput    = "output"
+)
+
+// exitCodeLimitExceeded is the exit code of a build that grim killed for writing too much output.
+const exitCodeLimitExceeded = -24
+
+// resourceLimits are the most a build's processes may use.  Zero means no limit.
+type resourceLimits struct {
+	MemoryMB   int
+	CPUSeconds int
+	Processes  int
+	OutputMB   int
+
+	// where a cgroup is made for each build when cgroup v2 is available
+	cgroupRoot string
+}
+
+func validateLimits(limits *resourceLimits) error {
+	if limits != nil && (limits.MemoryMB < 0 || limits.CPUSeconds < 0 || limits.Processes < 0 || limits.OutputMB < 0) {
+		return fmt.Errorf("Limits cannot be negative")
+	}
+
+	return nil
+}
+
+func (l *resourceLimits) outputLimiter() *outputLimiter {
+	if l == nil || l.OutputMB == 0 {
+		return nil
+	}
+
+	return &outputLimiter{max: int64(l.OutputMB) << 20, exceeded: make(chan struct{})}
+}
+
+// outputLimiter counts the output of a build and signals once it is over the limit.
+// A nil outputLimiter never is.
+type outputLimiter struct {
+	mu       sync.Mutex
+	written  int64
+	max      int64
+	exceeded chan struct{}
+	closed   bool
+}
+
+// add counts a line of output and returns whether it is within the limit.
+func (o *outputLimiter) add(line string) bool {
+	if o == nil {
+		return true
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.written += int64(len(line)) + 1
+	if o.written <= o.max {
+		return true
+	}
+
+	if !o.closed {
+		o.closed = true
+		close(o.exceeded)
+	}
+
+	return false
+}
+
+// exceededChan is closed when the limit is passed.  It's nil, and never ready, for a
+// nil outputLimiter.
+func (o *outputLimiter) exceededChan() <-chan struct{} {
+	if o == nil {
+		return nil
+	}
+
+	return o.exceeded
+}
+
+func (o *outputLimiter) hasExceeded() bool {
+	if o == nil {
+		return false
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.closed
+}
@@ -0,0 +1,244 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// a build gets a few seconds after SIGXCPU before it is killed
+const cpuLimitGrace = 5
+
+// how often a build cgroup's cpu usage is checked against its limit
+const cpuPollInterval = time.Second
+
+type rlimit struct {
+	Resource int
+	Cur      uint64
+	Max      uint64
+}
+
+// limitEnforcer applies resourceLimits to one command.  A nil limitEnforcer does nothing.
+type limitEnforcer struct {
+	rlimits    []rlimit
+	cgroup     *buildCgroup
+	cpuLimited bool
+}
+
+// enforcer gets ready to limit cmd, putting it in a cgroup of its own when cgroup v2 is
+// available.  Limits the cgroup can't enforce are left as rlimits for wrapRlimits or a
+// sandbox to set.  Memory can only be limited by a cgroup.  The cpu limit is for the
+// whole build in a cgroup and for each of its processes otherwise.
+func (l *resourceLimits) enforcer(cmd *exec.Cmd) (*limitEnforcer, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	e := &limitEnforcer{}
+
+	if l.cgroupRoot != "" && (l.MemoryMB > 0 || l.Processes > 0 || l.CPUSeconds > 0) {
+		cgroup, err := newBuildCgroup(l.cgroupRoot, l)
+		if err != nil {
+			return nil, err
+		}
+
+		e.cgroup = cgroup
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(cgroup.dir.Fd())
+	} else if l.MemoryMB > 0 {
+		// an rlimit could only cap address space, which go and java reserve far more of than they use
+		return nil, fmt.Errorf("the memory limit needs a CgroupRoot")
+	} else if l.Processes > 0 {
+		e.rlimits = append(e.rlimits, rlimit{unix.RLIMIT_NPROC, uint64(l.Processes), uint64(l.Processes)})
+	}
+
+	if l.CPUSeconds > 0 && e.cgroup != nil {
+		e.cgroup.watchCPU(time.Duration(l.CPUSeconds) * time.Second)
+	} else if l.CPUSeconds > 0 {
+		e.cpuLimited = true
+		e.rlimits = append(e.rlimits, rlimit{syscall.RLIMIT_CPU, uint64(l.CPUSeconds), uint64(l.CPUSeconds + cpuLimitGrace)})
+	}
+
+	return e, nil
+}
+
+func (e *limitEnforcer) rlimitsToSet() []rlimit {
+	if e == nil {
+		return nil
+	}
+
+	return e.rlimits
+}
+
+// exceeded is the limit that stopped the build, if it can be told.
+func (e *limitEnforcer) exceeded(state *os.ProcessState) string {
+	if e == nil {
+		return ""
+	}
+
+	if e.cgroup != nil {
+		if e.cgroup.eventCount("memory.events", "oom_kill") > 0 {
+			return limitMemory
+		}
+
+		if e.cgroup.eventCount("pids.events", "max") > 0 {
+			return limitProcesses
+		}
+
+		if e.cgroup.cpuExceeded() {
+			return limitCPU
+		}
+	}
+
+	// the build was killed by SIGXCPU or exited like a shell whose command was
+	if e.cpuLimited && state != nil {
+		status, _ := state.Sys().(syscall.WaitStatus)
+		if (status.Signaled() && status.Signal() == syscall.SIGXCPU) || state.ExitCode() == 128+int(syscall.SIGXCPU) {
+			return limitCPU
+		}
+	}
+
+	return ""
+}
+
+func (e *limitEnforcer) close() {
+	if e != nil && e.cgroup != nil {
+		e.cgroup.close()
+	}
+}
+
+// buildCgroup is a cgroup v2 group made for one build and removed after it.
+type buildCgroup struct {
+	path string
+	dir  *os.File
+
+	stopWatching chan struct{}
+	cpuKilled    chan struct{}
+}
+
+func newBuildCgroup(root string, l *resourceLimits) (*buildCgroup, error) {
+	if !fileExists(filepath.Join(root, "cgroup.controllers")) {
+		return nil, fmt.Errorf("CgroupRoot %v is not a cgroup v2 directory", root)
+	}
+
+	// best effort; the controllers may already be enabled or be managed by someone else
+	ioutil.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte("+memory +pids"), 0644)
+
+	path, err := ioutil.TempDir(root, "build-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating build cgroup: %v", err)
+	}
+
+	settings := map[string]int64{}
+	if l.MemoryMB > 0 {
+		settings["memory.max"] = int64(l.MemoryMB) << 20
+		settings["memory.swap.max"] = 0
+	}
+
+	if l.Processes > 0 {
+		settings["pids.max"] = int64(l.Processes)
+	}
+
+	for name, value := range settings {
+		err := ioutil.WriteFile(filepath.Join(path, name), []byte(strconv.FormatInt(value, 10)), 0644)
+		if err != nil && !(name == "memory.swap.max" && os.IsNotExist(err)) {
+			os.Remove(path)
+			return nil, fmt.Errorf("error setting %v of build cgroup: %v", name, err)
+		}
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	return &buildCgroup{path: path, dir: dir}, nil
+}
+
+// watchCPU kills everything in the cgroup once its processes have used more than limit of
+// cpu time between them.
+func (c *buildCgroup) watchCPU(limit time.Duration) {
+	c.stopWatching = make(chan struct{})
+	c.cpuKilled = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(cpuPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.stopWatching:
+				return
+			case <-ticker.C:
+			}
+
+			if time.Duration(c.eventCount("cpu.stat", "usage_usec"))*time.Microsecond > limit {
+				close(c.cpuKilled)
+				ioutil.WriteFile(filepath.Join(c.path, "cgroup.kill"), []byte("1"), 0644)
+				return
+			}
+		}
+	}()
+}
+
+// cpuExceeded is whether the build was killed for using more than its cpu limit.
+func (c *buildCgroup) cpuExceeded() bool {
+	select {
+	case <-c.cpuKilled:
+		return true
+	default:
+		return false
+	}
+}
+
+// eventCount reads a counter from one of the cgroup's events or stat files.
+func (c *buildCgroup) eventCount(file, event string) int64 {
+	f, err := os.Open(filepath.Join(c.path, file))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == event {
+			count, _ := strconv.ParseInt(fields[1], 10, 64)
+			return count
+		}
+	}
+
+	return 0
+}
+
+// close kills anything the build left behind in the cgroup and removes it.
+func (c *buildCgroup) close() {
+	if c.stopWatching != nil {
+		close(c.stopWatching)
+	}
+
+	ioutil.WriteFile(filepath.Join(c.path, "cgroup.kill"), []byte("1"), 0644)
+	c.dir.Close()
+
+	// the cgroup can't be removed until the processes in it are gone
+	for i := 0; i < 10; i++ {
+		if err := os.Remove(c.path); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,89 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCPULimit(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("Skipping cpu limit test in short mode.")
+	}
+
+	withTempDir(t, func(path string) {
+		script := filepath.Join(path, "spin.sh")
+		ioutil.WriteFile(script, []byte("#!/bin/sh\nwhile true; do :; done\n"), 0755)
+
+		result, err := executeWithOutputChan(nil, nil, nil, &resourceLimits{CPUSeconds: 1}, nil, path, script, testBuildtimeout, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.ExitCode == 0 || result.LimitExceeded != limitCPU {
+			t.Errorf("spinning build was not stopped by the cpu limit: %+v", result)
+		}
+	})
+}
+
+func TestCgroupLimits(t *testing.T) {
+	controllers, err := ioutil.ReadFile("/sys/fs/cgroup/cgroup.controllers")
+	if testing.Short() || err != nil || !strings.Contains(string(controllers), "pids") || os.Geteuid() != 0 {
+		t.Skipf("Skipping cgroup test without cgroup v2 and root.")
+	}
+
+	root, err := ioutil.TempDir("/sys/fs/cgroup", "grim-test-")
+	if err != nil {
+		t.Skipf("Skipping cgroup test: %v", err)
+	}
+	defer os.Remove(root)
+
+	withTempDir(t, func(path string) {
+		script := filepath.Join(path, "forks.sh")
+		ioutil.WriteFile(script, []byte("#!/bin/sh\nfor i in 1 2 3 4 5 6 7 8 9 10; do sleep 1 & done\nwait\n"), 0755)
+
+		result, err := executeWithOutputChan(nil, nil, nil, &resourceLimits{Processes: 3, cgroupRoot: root}, nil, path, script, testBuildtimeout, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.LimitExceeded != limitProcesses {
+			t.Errorf("forking build did not hit the process limit: %+v", result)
+		}
+	})
+}
+
+func TestCgroupCPULimitIsForTheWholeBuild(t *testing.T) {
+	withTempDir(t, func(path string) {
+		ioutil.WriteFile(filepath.Join(path, "cpu.stat"), []byte("usage_usec 500000\nuser_usec 400000\n"), 0644)
+
+		cgroup := &buildCgroup{path: path}
+		cgroup.watchCPU(time.Second)
+		defer close(cgroup.stopWatching)
+
+		time.Sleep(cpuPollInterval + cpuPollInterval/2)
+		if cgroup.cpuExceeded() {
+			t.Fatalf("a build under its cpu limit was killed")
+		}
+
+		// the processes of the build have used more than the limit between them
+		ioutil.WriteFile(filepath.Join(path, "cpu.stat"), []byte("usage_usec 1500000\nuser_usec 1400000\n"), 0644)
+
+		select {
+		case <-cgroup.cpuKilled:
+		case <-time.After(3 * cpuPollInterval):
+			t.Fatalf("a build over its cpu limit was not killed")
+		}
+
+		if kill, err := ioutil.ReadFile(filepath.Join(path, "cgroup.kill")); err != nil || string(kill) != "1" {
+			t.Errorf("expected the cgroup to be killed but got %q %v", kill, err)
+		}
+	})
+}
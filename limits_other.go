@@ -0,0 +1,41 @@
+//go:build !linux
+// +build !linux
+
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+type rlimit struct{}
+
+// limitEnforcer does nothing outside of linux, where only the output limit is supported.
+type limitEnforcer struct{}
+
+func (l *resourceLimits) enforcer(cmd *exec.Cmd) (*limitEnforcer, error) {
+	if l != nil && (l.MemoryMB > 0 || l.CPUSeconds > 0 || l.Processes > 0) {
+		return nil, fmt.Errorf("memory, cpu and process limits need linux")
+	}
+
+	return nil, nil
+}
+
+func (e *limitEnforcer) rlimitsToSet() []rlimit {
+	return nil
+}
+
+func wrapRlimits(cmd *exec.Cmd, rlimits []rlimit) error {
+	return nil
+}
+
+func (e *limitEnforcer) exceeded(state *os.ProcessState) string {
+	return ""
+}
+
+func (e *limitEnforcer) close() {}
@@ -0,0 +1,159 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLimits(t *testing.T) {
+	config := testLocalConfig(localConfig{
+		local:  configMap{"Limits": map[string]interface{}{"MemoryMB": float64(512), "OutputMB": float64(10)}},
+		global: testGlobalConfig(configMap{"CgroupRoot": "/sys/fs/cgroup/grim"}),
+	})
+
+	limits, err := config.limits()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limits.MemoryMB != 512 || limits.OutputMB != 10 || limits.CPUSeconds != 0 || limits.Processes != 0 {
+		t.Errorf("unexpected limits %+v", limits)
+	}
+
+	for _, val := range []string{`"lots"`, `{"MemoryMB": "512"}`, `{"Processes": -1}`} {
+		if err := testSettingError(t, "Limits", val, func(lc localConfig) error { _, err := lc.limits(); return err }); err == nil {
+			t.Errorf("expected %v to be invalid", val)
+		}
+	}
+}
+
+func TestLimitsConfig(t *testing.T) {
+	config := testLocalConfig(localConfig{
+		local:  configMap{"Limits": map[string]interface{}{"CPUSeconds": float64(60)}},
+		global: testGlobalConfig(configMap{"Limits": map[string]interface{}{"MemoryMB": float64(512)}, "CgroupRoot": "/sys/fs/cgroup/grim"}),
+	})
+
+	limits, err := config.limits()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limits.CPUSeconds != 60 || limits.MemoryMB != 0 || limits.cgroupRoot != "/sys/fs/cgroup/grim" {
+		t.Errorf("repo limits did not override the server's: %+v", limits)
+	}
+
+	config = testLocalConfig(localConfig{local: configMap{}, inRepo: configMap{"Limits": map[string]interface{}{}}})
+	if limits, _ := config.limits(); limits != nil {
+		t.Errorf("limits should not be read from the repo's own config")
+	}
+}
+
+func TestLimitsWithoutCgroup(t *testing.T) {
+	memory := testLocalConfig(localConfig{local: configMap{"Limits": map[string]interface{}{"MemoryMB": float64(512)}}})
+	if _, err := memory.limits(); err == nil {
+		t.Errorf("a memory limit without a cgroup should be refused")
+	}
+
+	processes := testLocalConfig(localConfig{local: configMap{"Limits": map[string]interface{}{"Processes": float64(64)}}})
+	if _, err := processes.limits(); err == nil {
+		t.Errorf("a process limit without a cgroup or sandbox should be refused")
+	}
+
+	processes = testLocalConfig(localConfig{local: configMap{"Limits": map[string]interface{}{"Processes": float64(64)}, "Sandbox": map[string]interface{}{}}})
+	if limits, err := processes.limits(); err != nil || limits.Processes != 64 {
+		t.Errorf("a process limit in a sandbox should be allowed: %+v %v", limits, err)
+	}
+}
+
+func TestCPULimitWithoutCgroupWarns(t *testing.T) {
+	perProcess := testLocalConfig(localConfig{local: configMap{"Limits": map[string]interface{}{"CPUSeconds": float64(60)}}})
+	if warnings := perProcess.warnings(); len(warnings) != 1 || !strings.Contains(warnings[0].Error(), "each process") {
+		t.Errorf("expected a warning about the per process cpu limit but got %v", warnings)
+	}
+
+	wholeBuild := testLocalConfig(localConfig{
+		local:  configMap{"Limits": map[string]interface{}{"CPUSeconds": float64(60)}},
+		global: testGlobalConfig(configMap{"CgroupRoot": "/sys/fs/cgroup/grim"}),
+	})
+	if warnings := wholeBuild.warnings(); len(warnings) != 0 {
+		t.Errorf("expected no warning with a cgroup but got %v", warnings)
+	}
+}
+
+func TestOutputLimiter(t *testing.T) {
+	limiter := &outputLimiter{max: 10, exceeded: make(chan struct{})}
+
+	if !limiter.add("12345") || limiter.hasExceeded() {
+		t.Errorf("output under the limit was refused")
+	}
+
+	if limiter.add("123456") || limiter.add("more") || !limiter.hasExceeded() {
+		t.Errorf("output over the limit was allowed")
+	}
+
+	select {
+	case <-limiter.exceededChan():
+	default:
+		t.Errorf("exceeding the limit was not signaled")
+	}
+
+	var none *outputLimiter
+	if !none.add(strings.Repeat("x", 1<<20)) || none.exceededChan() != nil {
+		t.Errorf("a nil limiter should not limit")
+	}
+}
+
+func TestOutputLimitKillsBuild(t *testing.T) {
+	withTempDir(t, func(path string) {
+		script := filepath.Join(path, "noisy.sh")
+		ioutil.WriteFile(script, []byte("#!/bin/sh\nwhile true; do echo 0123456789012345678901234567890123456789; done\n"), 0755)
+
+		outputChan := make(chan string)
+		go func() {
+			for range outputChan {
+			}
+		}()
+
+		result, err := executeWithOutputChan(outputChan, nil, nil, &resourceLimits{OutputMB: 1}, nil, path, script, testBuildtimeout, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.ExitCode != exitCodeLimitExceeded || result.LimitExceeded != limitOutput {
+			t.Errorf("noisy build was not stopped by the output limit: %+v", result)
+		}
+	})
+}
+
+func TestLimitExceededNotification(t *testing.T) {
+	config := testLocalConfig(localConfig{local: configMap{"LimitTemplate": "{{.Repo}} hit {{.Limit}}"}})
+	context := &grimNotificationContext{Repo: "grim"}
+
+	n := limitExceededNotification{limitMemory}
+	message, _, err := n.HipchatNotification(context, config)
+	if err != nil || message != "grim hit memory" {
+		t.Errorf("unexpected message %q %v", message, err)
+	}
+
+	if n.GithubRefStatus() != RSFailure {
+		t.Errorf("a build that exceeded a limit should fail")
+	}
+}
+
+func TestPipelineLimitExceeded(t *testing.T) {
+	steps := []stepResult{
+		{Name: "lint", ExitCode: 0},
+		{Name: "test", ExitCode: exitCodeLimitExceeded, LimitExceeded: limitOutput},
+	}
+
+	result := aggregateStepResults(steps, []*executeResult{{}, {}})
+	if result.LimitExceeded != limitOutput {
+		t.Errorf("the failing step's limit was not recorded: %+v", result)
+	}
+}
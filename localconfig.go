@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
@@ -16,40 +18,155 @@ import (
 var errNilLocalConfig = fmt.Errorf("local config was nil")
 
 func readLocalConfig(configRoot, owner, repo string) (lc localConfig, err error) {
-	var bs []byte
 	global, err := readGlobalConfig(configRoot)
 	if err == nil {
-		local := make(configMap)
+		lc, err = readLocalConfigWith(global, configRoot, owner, repo)
+	}
 
-		bs, err = ioutil.ReadFile(filepath.Join(configRoot, owner, repo, configFileName))
-		if err == nil {
-			err = json.Unmarshal(bs, &local)
-			if err == nil {
-				lc = localConfig{owner, repo, local, global}
-			}
-		}
+	return
+}
+
+// readLocalConfigWith reads a repo's config.json on top of a global config that has
+// already been read.  Like newLocalConfig it returns the config along with any
+// settingsErrors.
+func readLocalConfigWith(global globalConfig, configRoot, owner, repo string) (lc localConfig, err error) {
+	local := make(configMap)
+	var credentialEnv []string
+
+	bs, err := ioutil.ReadFile(filepath.Join(configRoot, owner, repo, configFileName))
+	if err == nil {
+		err = json.Unmarshal(bs, &local)
+	}
+	if err == nil {
+		credentialEnv, err = resolveCredentials(local, repoSettingsFields, filepath.Join(configRoot, owner, repo))
+	}
+	if err == nil {
+		lc, err = newLocalConfig(owner, repo, local, global)
+		lc.credentialEnv = credentialEnv
 	}
 
 	return
 }
 
 type localConfig struct {
-	owner, repo string
-	local       configMap
-	global      globalConfig
+	owner, repo    string
+	local          configMap
+	inRepo         configMap
+	global         globalConfig
+	localSettings  repoSettings
+	inRepoSettings repoSettings
+
+	// the environment variables the repo's credentials were read from
+	credentialEnv []string
+}
+
+// newLocalConfig decodes the settings of a repo's config.json.  The settings that have a
+// value of the wrong type are left unset and returned as settingsErrors.
+func newLocalConfig(owner, repo string, local configMap, global globalConfig) (localConfig, error) {
+	lc := localConfig{owner: owner, repo: repo, local: local, global: global}
+	_, errs := decodeSettings(local, &lc.localSettings)
+	return lc, settingsError(errs)
+}
+
+// withInRepoConfig layers the allowed settings from a repo's own .grim.yml or .grim.json
+// underneath the server side config.json for that repo.  readRepoConfig has already
+// rejected values of the wrong type.
+func (lc localConfig) withInRepoConfig(inRepo configMap) localConfig {
+	lc.inRepo = inRepo
+	lc.inRepoSettings = repoSettings{}
+	decodeSettings(inRepo, &lc.inRepoSettings)
+	return lc
 }
 
 func (lc localConfig) errors() (errs []error) {
+	if topicARN := lc.snsTopicARN(); topicARN != "" {
+		if err := checkTopicARN(topicARN, lc.global.awsRegion(), ""); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	snsTopicName := lc.snsTopicName()
 	if snsTopicName == "" {
 		errs = append(errs, fmt.Errorf("must have a sns topic name"))
 	} else if strings.Contains(snsTopicName, ".") {
 		errs = append(errs, fmt.Errorf("cannot have . in sns topic name [ %s ].  Default topic names can be set in the build config file using the SnsTopicName parameter", snsTopicName))
 	}
+
+	if _, err := lc.pipeline(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := lc.matrix(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := lc.buildScript(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := lc.env(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := lc.inheritEnv(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := lc.secrets(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := lc.forkPolicy(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := lc.sandbox(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := lc.limits(); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, team := range lc.allowedTeams() {
+		if parts := strings.SplitN(team, "/", 2); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			errs = append(errs, fmt.Errorf("AllowedTeams entry %q must be org/team", team))
+		}
+	}
+
+	_, patternErrs := lc.redactPatterns()
+	errs = append(errs, patternErrs...)
+
+	filters := []struct {
+		key      string
+		patterns []string
+	}{
+		{"Branches", lc.branches()},
+		{"ExcludeBranches", lc.excludeBranches()},
+		{"PullRequestBranches", lc.pullRequestBranches()},
+		{"ExcludePullRequestBranches", lc.excludePullRequestBranches()},
+		{"Tags", lc.tags()},
+		{"ExcludeTags", lc.excludeTags()},
+		{"Paths", lc.paths()},
+		{"ExcludePaths", lc.excludePaths()},
+	}
+
+	for _, filter := range filters {
+		for _, pattern := range filter.patterns {
+			if err := validateGlob(pattern); err != nil {
+				errs = append(errs, fmt.Errorf("%v: %v", filter.key, err))
+			}
+		}
+	}
 	return
 }
 
 func (lc localConfig) warnings() (errs []error) {
+	// an rlimit only stops a single process, so a build can go over it by running several
+	if limits, _ := lc.limits(); limits != nil && limits.CPUSeconds > 0 && limits.cgroupRoot == "" {
+		errs = append(errs, fmt.Errorf("Limits CPUSeconds without a CgroupRoot is the cpu time of each process of a build rather than of the whole build"))
+	}
+
 	return
 }
 
@@ -78,27 +195,75 @@ func (lc localConfig) awsSecret() string {
 }
 
 func (lc localConfig) gitHubToken() string {
-	return readStringWithDefaults(lc.local, "GitHubToken", lc.global.gitHubToken())
+	local, _ := lc.settings()
+	return firstString(lc.global.gitHubToken(), local.GitHubToken)
+}
+
+// redactPatterns are the extra RedactPatterns from both the global and repo config.json.
+func (lc localConfig) redactPatterns() (patterns []*regexp.Regexp, errs []error) {
+	local, _ := lc.settings()
+	for _, pattern := range append(lc.global.settings.RedactPatterns, local.RedactPatterns...) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid redact pattern %q: %v", pattern, err))
+			continue
+		}
+		patterns = append(patterns, re)
+	}
+
+	return
+}
+
+// redactor masks the credentials grim itself is configured with along with the
+// configured and well known token patterns.
+func (lc localConfig) redactor() *redactor {
+	patterns, _ := lc.redactPatterns()
+	return newRedactor(patterns, lc.gitHubToken(), lc.global.gitHubToken(), lc.hipChatToken(), lc.global.hipChatToken(), lc.awsKey(), lc.awsSecret())
+}
+
+func (lc localConfig) secrets() (map[string]secretSource, error) {
+	local, _ := lc.settings()
+	return local.Secrets, validateSecrets(local.Secrets)
+}
+
+func (lc localConfig) secretsKeyFile() string {
+	return lc.global.secretsKeyFile()
 }
 
 func (lc localConfig) pathToCloneIn() string {
-	return readStringWithDefaults(lc.local, "PathToCloneIn")
+	local, _ := lc.settings()
+	return firstString("", local.PathToCloneIn)
 }
 
 func (lc localConfig) snsTopicName() string {
-	return readStringWithDefaults(lc.local, "SNSTopicName", *defaultTopicName(lc.owner, lc.repo))
+	if topicARN := lc.snsTopicARN(); topicARN != "" {
+		return getResourceFromARN(topicARN)
+	}
+
+	local, _ := lc.settings()
+	return firstString(*defaultTopicName(lc.owner, lc.repo), local.SNSTopicName)
+}
+
+// snsTopicARN is the topic the repo uses if it is one grim didn't create, or "".
+func (lc localConfig) snsTopicARN() string {
+	local, _ := lc.settings()
+	return firstString("", local.SNSTopicARN)
 }
 
 func (lc localConfig) hipChatRoom() string {
-	return readStringWithDefaults(lc.local, "HipChatRoom", lc.global.hipChatRoom())
+	// not from the repo, or anyone who can push could send the server's token's messages anywhere
+	local, _ := lc.settings()
+	return firstString(lc.global.hipChatRoom(), local.HipChatRoom)
 }
 
 func (lc localConfig) hipChatToken() string {
-	return readStringWithDefaults(lc.local, "HipChatToken", lc.global.hipChatToken())
+	local, _ := lc.settings()
+	return firstString(lc.global.hipChatToken(), local.HipChatToken)
 }
 
 func (lc localConfig) hipChatVersion() int {
-	return readIntWithDefaults(lc.local, "HipChatVersion", lc.global.hipChatVersion())
+	local, _ := lc.settings()
+	return firstInt(lc.global.hipChatVersion(), local.HipChatVersion)
 }
 
 func (lc localConfig) grimServerID() string {
@@ -106,58 +271,328 @@ func (lc localConfig) grimServerID() string {
 }
 
 func (lc localConfig) pendingTemplate() string {
-	return readStringWithDefaults(lc.local, "PendingTemplate", lc.global.pendingTemplate())
+	local, _ := lc.settings()
+	return firstString(lc.global.pendingTemplate(), local.PendingTemplate)
 }
 
 func (lc localConfig) errorTemplate() string {
-	return readStringWithDefaults(lc.local, "ErrorTemplate", lc.global.errorTemplate())
+	local, inRepo := lc.settings()
+	return firstString(lc.global.errorTemplate(), local.ErrorTemplate, inRepo.ErrorTemplate)
 }
 
 func (lc localConfig) successTemplate() string {
-	return readStringWithDefaults(lc.local, "SuccessTemplate", lc.global.successTemplate())
+	local, inRepo := lc.settings()
+	return firstString(lc.global.successTemplate(), local.SuccessTemplate, inRepo.SuccessTemplate)
+}
+
+func (lc localConfig) tagTemplate() string {
+	local, inRepo := lc.settings()
+	return firstString(lc.global.tagTemplate(), local.TagTemplate, inRepo.TagTemplate)
+}
+
+func (lc localConfig) limitTemplate() string {
+	local, inRepo := lc.settings()
+	return firstString(lc.global.limitTemplate(), local.LimitTemplate, inRepo.LimitTemplate)
+}
+
+func (lc localConfig) timeoutTemplate() string {
+	local, inRepo := lc.settings()
+	return firstString(lc.global.timeoutTemplate(), local.TimeoutTemplate, inRepo.TimeoutTemplate)
+}
+
+func (lc localConfig) skippedTemplate() string {
+	local, inRepo := lc.settings()
+	return firstString(lc.global.skippedTemplate(), local.SkippedTemplate, inRepo.SkippedTemplate)
 }
 
 func (lc localConfig) failureTemplate() string {
-	return readStringWithDefaults(lc.local, "FailureTemplate", lc.global.failureTemplate())
+	local, inRepo := lc.settings()
+	return firstString(lc.global.failureTemplate(), local.FailureTemplate, inRepo.FailureTemplate)
 }
 
 func (lc localConfig) successColor() string {
-	return readStringWithDefaults(lc.local, "SuccessColor", lc.global.successColor())
+	local, inRepo := lc.settings()
+	return firstString(lc.global.successColor(), local.SuccessColor, inRepo.SuccessColor)
 }
 
 func (lc localConfig) errorColor() string {
-	return readStringWithDefaults(lc.local, "ErrorColor", lc.global.errorColor())
+	local, inRepo := lc.settings()
+	return firstString(lc.global.errorColor(), local.ErrorColor, inRepo.ErrorColor)
 }
 
 func (lc localConfig) failureColor() string {
-	return readStringWithDefaults(lc.local, "FailureColor", lc.global.failureColor())
+	local, inRepo := lc.settings()
+	return firstString(lc.global.failureColor(), local.FailureColor, inRepo.FailureColor)
+}
+
+func (lc localConfig) skippedColor() string {
+	local, inRepo := lc.settings()
+	return firstString(lc.global.skippedColor(), local.SkippedColor, inRepo.SkippedColor)
 }
 
 func (lc localConfig) pendingColor() string {
-	return readStringWithDefaults(lc.local, "PendingColor", lc.global.pendingColor())
+	local, _ := lc.settings()
+	return firstString(lc.global.pendingColor(), local.PendingColor)
 }
 
-func (lc localConfig) timeout() (to time.Duration) {
-	val := readIntWithDefaults(lc.local, "Timeout")
+func (lc localConfig) timeout() time.Duration {
+	local, inRepo := lc.settings()
+	if val := firstInt(0, local.Timeout, inRepo.Timeout); val > 0 {
+		return time.Duration(val) * time.Second
+	}
 
-	if val > 0 {
-		to = time.Duration(val) * time.Second
-	} else {
-		to = lc.global.timeout()
+	return lc.global.timeout()
+}
+
+// timeoutGracePeriod is how long a timed out build has to exit after SIGTERM before it is killed.
+func (lc localConfig) timeoutGracePeriod() time.Duration {
+	// only the server sets it, or a build could give itself as long as it liked
+	local, _ := lc.settings()
+	if val := firstInt(-1, local.TimeoutGracePeriod); val >= 0 {
+		return time.Duration(val) * time.Second
 	}
 
-	return
+	return lc.global.timeoutGracePeriod()
+}
+
+func (lc localConfig) pipeline() ([]pipelineStep, error) {
+	local, inRepo := lc.settings()
+
+	steps := local.Pipeline
+	if steps == nil {
+		steps = inRepo.Pipeline
+	}
+
+	return steps, validatePipeline(steps)
+}
+
+func (lc localConfig) buildScript() (string, error) {
+	local, inRepo := lc.settings()
+	return repoScript(firstString("", local.BuildScript, inRepo.BuildScript), "build script")
+}
+
+// onTimeoutScript is run after a build is stopped for running too long so that it can
+// clean up after it.
+func (lc localConfig) onTimeoutScript() (string, error) {
+	local, inRepo := lc.settings()
+	return repoScript(firstString("", local.OnTimeout, inRepo.OnTimeout), "on timeout script")
+}
+
+func repoScript(script, description string) (string, error) {
+	if script == "" {
+		return "", nil
+	}
+
+	cleaned := filepath.Clean(script)
+	if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%v %q must be a path inside the repo", description, script)
+	}
+
+	return cleaned, nil
+}
+
+func (lc localConfig) env() ([]string, error) {
+	local, inRepo := lc.settings()
+
+	vars := make(map[string]string)
+	for _, envMap := range []map[string]interface{}{inRepo.Env, local.Env} {
+		for name, value := range envMap {
+			if !validEnvName.MatchString(name) {
+				return nil, fmt.Errorf("env variable %q is not a valid environment variable name", name)
+			}
+
+			switch value.(type) {
+			case string, float64, bool:
+				vars[name] = fmt.Sprintf("%v", value)
+			default:
+				return nil, fmt.Errorf("env variable %q must be a string, number or boolean", name)
+			}
+		}
+	}
+
+	var env []string
+	for name, value := range vars {
+		env = append(env, fmt.Sprintf("%v=%v", name, value))
+	}
+	sort.Strings(env)
+
+	return env, nil
+}
+
+func (lc localConfig) inheritEnv() (inheritEnvPolicy, error) {
+	local, _ := lc.settings()
+
+	val := local.InheritEnv
+	if val == nil {
+		val = lc.global.settings.InheritEnv
+	}
+
+	policy, err := parseInheritEnv(val)
+	policy.withheld = append(append([]string{}, lc.global.credentialEnv...), lc.credentialEnv...)
+	return policy, err
+}
+
+// forkPolicy is how pull requests from forks are built.  It can't be set in the repo's
+// own config since a fork could change it.
+func (lc localConfig) forkPolicy() (string, error) {
+	local, _ := lc.settings()
+	policy := firstString(forkPolicyWithoutSecrets, local.ForkPolicy, lc.global.settings.ForkPolicy)
+	return policy, validateForkPolicy(policy)
+}
+
+// sandbox is how builds are isolated, if at all.  Like the fork policy it can't be set
+// in the repo's own config.
+func (lc localConfig) sandbox() (*sandboxConfig, error) {
+	local, _ := lc.settings()
+
+	val := local.Sandbox
+	if val == nil {
+		val = lc.global.settings.Sandbox
+	}
+
+	if err := validateSandbox(val); err != nil || val == nil {
+		return nil, err
+	}
+
+	// each build hides its own paths, so it gets a copy
+	sandbox := *val
+	return &sandbox, nil
+}
+
+// limits are the resources a build may use.  They can't be set in the repo's own config.
+func (lc localConfig) limits() (*resourceLimits, error) {
+	local, _ := lc.settings()
+
+	val := local.Limits
+	if val == nil {
+		val = lc.global.settings.Limits
+	}
+
+	if err := validateLimits(val); err != nil || val == nil {
+		return nil, err
+	}
+
+	// the settings are shared by every build, so the cgroup root is set on a copy
+	limits := &resourceLimits{}
+	*limits = *val
+
+	limits.cgroupRoot = lc.global.cgroupRoot()
+	if limits.cgroupRoot != "" {
+		return limits, nil
+	}
+
+	if limits.MemoryMB > 0 {
+		return nil, fmt.Errorf("Limits MemoryMB needs a CgroupRoot")
+	}
+
+	// RLIMIT_NPROC counts every process of the build's user and is ignored for root
+	if sandbox, _ := lc.sandbox(); limits.Processes > 0 && sandbox == nil {
+		return nil, fmt.Errorf("Limits Processes needs a CgroupRoot or a Sandbox to run builds as their own user")
+	}
+
+	return limits, nil
+}
+
+func (lc localConfig) approvalLabel() string {
+	local, _ := lc.settings()
+	return firstString(defaultApprovalLabel, local.ApprovalLabel, lc.global.settings.ApprovalLabel)
+}
+
+// The build filters in the server's config.json take precedence over the repo's own config.
+func (lc localConfig) branches() []string {
+	local, inRepo := lc.settings()
+	return firstList(local.Branches, inRepo.Branches)
+}
+
+func (lc localConfig) excludeBranches() []string {
+	local, inRepo := lc.settings()
+	return firstList(local.ExcludeBranches, inRepo.ExcludeBranches)
+}
+
+func (lc localConfig) pullRequestBranches() []string {
+	local, inRepo := lc.settings()
+	return firstList(local.PullRequestBranches, inRepo.PullRequestBranches)
+}
+
+func (lc localConfig) excludePullRequestBranches() []string {
+	local, inRepo := lc.settings()
+	return firstList(local.ExcludePullRequestBranches, inRepo.ExcludePullRequestBranches)
+}
+
+func (lc localConfig) tags() []string {
+	local, inRepo := lc.settings()
+	return firstList(local.Tags, inRepo.Tags)
+}
+
+func (lc localConfig) excludeTags() []string {
+	local, inRepo := lc.settings()
+	return firstList(local.ExcludeTags, inRepo.ExcludeTags)
+}
+
+func (lc localConfig) paths() []string {
+	local, inRepo := lc.settings()
+	return firstList(local.Paths, inRepo.Paths)
+}
+
+func (lc localConfig) excludePaths() []string {
+	local, inRepo := lc.settings()
+	return firstList(local.ExcludePaths, inRepo.ExcludePaths)
+}
+
+func (lc localConfig) hasPathFilters() bool {
+	return len(lc.paths()) > 0 || len(lc.excludePaths()) > 0
+}
+
+// hookSkipReason returns why a build shouldn't happen if the hook is excluded by the
+// configured branch, tag or path filters.
+func (lc localConfig) hookSkipReason(hook hookEvent) *string {
+	var skipReason *string
+
+	switch hook.EventName {
+	case "push":
+		skipReason = refFilterSkipReason("branch", hook.Target, lc.branches(), lc.excludeBranches())
+	case "tag", "release":
+		return refFilterSkipReason("tag", hook.Tag, lc.tags(), lc.excludeTags())
+	case "pull_request":
+		skipReason = refFilterSkipReason("pull request target branch", hook.Target, lc.pullRequestBranches(), lc.excludePullRequestBranches())
+	default:
+		return nil
+	}
+
+	if skipReason != nil {
+		return skipReason
+	}
+
+	return pathFilterSkipReason(hook.ChangedFiles, lc.paths(), lc.excludePaths())
+}
+
+func (lc localConfig) matrix() ([]matrixCell, error) {
+	local, _ := lc.settings()
+	return expandMatrix(lc.grimServerID(), local.Matrix)
 }
 
 func (lc localConfig) usernameWhitelist() []string {
-	val, _ := lc.local["UsernameWhitelist"]
-	iSlice, _ := val.([]interface{})
-	var wl []string
-	for _, entry := range iSlice {
-		entryStr, _ := entry.(string)
-		wl = append(wl, entryStr)
-	}
-	return wl
+	local, _ := lc.settings()
+	return local.UsernameWhitelist
+}
+
+func (lc localConfig) allowedTeams() []string {
+	local, _ := lc.settings()
+	return firstList(local.AllowedTeams, lc.global.settings.AllowedTeams)
+}
+
+func (lc localConfig) allowedOrgs() []string {
+	local, _ := lc.settings()
+	return firstList(local.AllowedOrgs, lc.global.settings.AllowedOrgs)
+}
+
+func (lc localConfig) allowCollaborators() bool {
+	local, _ := lc.settings()
+	return firstBool(false, local.AllowCollaborators, lc.global.settings.AllowCollaborators)
+}
+
+func (lc localConfig) authorizationCacheTTL() time.Duration {
+	return lc.global.authorizationCacheTTL()
 }
 
 func (lc localConfig) usernameCanBuild(username string) (allowed bool) {
@@ -0,0 +1,106 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var validEnvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// a matrix is built one cell after another, so it is kept to a size that can be
+const maxMatrixCells = 64
+
+// GitHub rejects commit statuses whose context is longer than this many characters
+const maxStatusContext = 255
+
+type matrixCell struct {
+	name string
+	env  []string
+}
+
+// expandMatrix turns a map of environment variable names to their possible values into
+// the cartesian product of those values.  Cells are ordered by axis name then value order.
+// Each cell's status context, which starts with the server id, has to be short enough for
+// GitHub.
+func expandMatrix(serverID string, matrix map[string][]interface{}) ([]matrixCell, error) {
+	var names []string
+	axes := make(map[string][]string)
+	count := 1
+	for name, rawValues := range matrix {
+		if !validEnvName.MatchString(name) {
+			return nil, fmt.Errorf("matrix axis %q is not a valid environment variable name", name)
+		}
+
+		values, err := matrixAxisValues(name, rawValues)
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+		axes[name] = values
+
+		// checked as each axis is added so that the count can't overflow
+		if count *= len(values); count > maxMatrixCells {
+			return nil, fmt.Errorf("matrix has more than %v cells", maxMatrixCells)
+		}
+	}
+	sort.Strings(names)
+
+	cells := []matrixCell{{}}
+	for _, name := range names {
+		var expanded []matrixCell
+		for _, cell := range cells {
+			for _, value := range axes[name] {
+				env := append(append([]string{}, cell.env...), fmt.Sprintf("%v=%v", name, value))
+				expanded = append(expanded, matrixCell{strings.Join(env, ","), env})
+			}
+		}
+		cells = expanded
+	}
+
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	for _, cell := range cells {
+		if context := statusContext(serverID, hookEvent{MatrixCell: cell.name}); len(context) > maxStatusContext {
+			return nil, fmt.Errorf("matrix cell %q makes the status context %v characters long, which is over GitHub's limit of %v", cell.name, len(context), maxStatusContext)
+		}
+	}
+
+	return cells, nil
+}
+
+func matrixAxisValues(name string, list []interface{}) ([]string, error) {
+	if len(list) == 0 {
+		return nil, fmt.Errorf("matrix axis %q must be a non-empty list of strings", name)
+	}
+
+	seen := make(map[string]bool)
+	var values []string
+	for _, rawValue := range list {
+		value, ok := rawValue.(string)
+		if !ok {
+			return nil, fmt.Errorf("matrix axis %q has a value that is not a string: %v", name, rawValue)
+		}
+
+		if strings.ContainsAny(value, "/,") {
+			return nil, fmt.Errorf("matrix axis %q value %q cannot contain '/' or ','", name, value)
+		}
+
+		if seen[value] {
+			return nil, fmt.Errorf("matrix axis %q has the value %q more than once", name, value)
+		}
+		seen[value] = true
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
@@ -0,0 +1,177 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandMatrix(t *testing.T) {
+	var local configMap
+	err := json.Unmarshal([]byte(`{"Matrix": {"GO_VERSION": ["1.7", "1.8"], "FEATURES": ["", "experimental"]}}`), &local)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cells, err := testLocalConfig(localConfig{local: local}).matrix()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []matrixCell{
+		{"FEATURES=,GO_VERSION=1.7", []string{"FEATURES=", "GO_VERSION=1.7"}},
+		{"FEATURES=,GO_VERSION=1.8", []string{"FEATURES=", "GO_VERSION=1.8"}},
+		{"FEATURES=experimental,GO_VERSION=1.7", []string{"FEATURES=experimental", "GO_VERSION=1.7"}},
+		{"FEATURES=experimental,GO_VERSION=1.8", []string{"FEATURES=experimental", "GO_VERSION=1.8"}},
+	}
+
+	if !reflect.DeepEqual(cells, expected) {
+		t.Errorf("matrix did not expand correctly:\n%v\n%v", cells, expected)
+	}
+}
+
+func TestNoMatrix(t *testing.T) {
+	for _, val := range []map[string][]interface{}{nil, {}} {
+		cells, err := expandMatrix("grim", val)
+		if err != nil || cells != nil {
+			t.Errorf("expected no cells for %v but got %v %v", val, cells, err)
+		}
+	}
+}
+
+func TestInvalidMatrix(t *testing.T) {
+	invalid := []string{
+		`["GO_VERSION"]`,
+		`{"GO VERSION": ["1.7"]}`,
+		`{"GO_VERSION": []}`,
+		`{"GO_VERSION": "1.7"}`,
+		`{"GO_VERSION": [1.7]}`,
+		`{"GO_VERSION": ["1.7", "1.7"]}`,
+		`{"GO_VERSION": ["go/1.7"]}`,
+	}
+
+	for _, js := range invalid {
+		if err := testSettingError(t, "Matrix", js, func(lc localConfig) error { _, err := lc.matrix(); return err }); err == nil {
+			t.Errorf("expected %v to be an invalid matrix", js)
+		}
+	}
+}
+
+func TestMatrixLimits(t *testing.T) {
+	var tooManyCells configMap
+	json.Unmarshal([]byte(`{"Matrix": {"A": ["1", "2", "3", "4"], "B": ["1", "2", "3", "4"], "C": ["1", "2", "3", "4", "5"]}}`), &tooManyCells)
+	config := testLocalConfig(localConfig{local: tooManyCells})
+	if _, err := config.matrix(); err == nil || !strings.Contains(err.Error(), "more than") {
+		t.Errorf("expected a matrix of 80 cells to be refused but got %v", err)
+	}
+
+	err := onHookBuild("not-used", config, hookEvent{Owner: testOwner, Repo: testRepo}, nil, nil)
+	if err == nil || IsFatal(err) || Kind(err) != ConfigError {
+		t.Errorf("expected a matrix that is too big to be a config error but got %v", err)
+	}
+
+	longValue := strings.Repeat("x", maxStatusContext)
+	if _, err := expandMatrix("grim", map[string][]interface{}{"A": {longValue}}); err == nil || !strings.Contains(err.Error(), "status context") {
+		t.Errorf("expected a cell too long for the status context to be refused but got %v", err)
+	}
+
+	// the server id is part of the context
+	value := strings.Repeat("x", maxStatusContext-len("grim/A="))
+	if _, err := expandMatrix("grim", map[string][]interface{}{"A": {value}}); err != nil {
+		t.Errorf("expected a context of exactly %v characters to be allowed but got %v", maxStatusContext, err)
+	}
+	if _, err := expandMatrix("grim-longer", map[string][]interface{}{"A": {value}}); err == nil {
+		t.Errorf("expected a longer server id to make the context too long")
+	}
+}
+
+func TestMatrixBuildsEachCell(t *testing.T) {
+	tempDir, _ := ioutil.TempDir("", "matrix-builds-each-cell")
+	defer os.RemoveAll(tempDir)
+
+	config := testLocalConfig(localConfig{
+		local:  configMap{"Matrix": map[string]interface{}{"GO_VERSION": []interface{}{"1.7", "1.8"}}},
+		global: testGlobalConfig(configMap{"ResultRoot": tempDir}),
+	})
+
+	var built []hookEvent
+	var basenames []string
+	err := onHookBuild("not-used", config, hookEvent{Owner: testOwner, Repo: testRepo}, nil, func(r string, resultPath string, c localConfig, h hookEvent, s string, b *runningBuild) (*executeResult, string, error) {
+		built = append(built, h)
+		basenames = append(basenames, s)
+		if filepath.Base(resultPath) != h.MatrixCell {
+			t.Errorf("cell %v was given result path %v", h.MatrixCell, resultPath)
+		}
+		return &executeResult{ExitCode: 0}, "", nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(built) != 2 || built[0].MatrixCell != "GO_VERSION=1.7" || built[1].MatrixCell != "GO_VERSION=1.8" {
+		t.Fatalf("expected a build for each cell but got %v", built)
+	}
+
+	if !reflect.DeepEqual(built[1].MatrixEnv, []string{"GO_VERSION=1.8"}) {
+		t.Errorf("cell environment was not set: %v", built[1].MatrixEnv)
+	}
+
+	if filepath.Base(basenames[0]) != "GO_VERSION=1.7" || filepath.Dir(basenames[0]) != filepath.Dir(basenames[1]) {
+		t.Errorf("cells should share a basename but have separate workspaces: %v", basenames)
+	}
+
+	results, err := resultsDirectoryExists(tempDir, testOwner, testRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hook := range built {
+		if !fileExists(filepath.Join(results, hook.MatrixCell, "hook.json")) {
+			t.Errorf("cell %v did not get its own result directory", hook.MatrixCell)
+		}
+	}
+}
+
+func TestMatrixContinuesAfterCellError(t *testing.T) {
+	tempDir, _ := ioutil.TempDir("", "matrix-cell-error")
+	defer os.RemoveAll(tempDir)
+
+	config := testLocalConfig(localConfig{
+		local:  configMap{"Matrix": map[string]interface{}{"FLAG": []interface{}{"a", "b"}}},
+		global: testGlobalConfig(configMap{"ResultRoot": tempDir}),
+	})
+
+	builds := 0
+	err := onHookBuild("not-used", config, hookEvent{Owner: testOwner, Repo: testRepo}, nil, func(r string, resultPath string, c localConfig, h hookEvent, s string, b *runningBuild) (*executeResult, string, error) {
+		builds++
+		return nil, "", fmt.Errorf("cell %v broke", h.MatrixCell)
+	})
+
+	if err == nil {
+		t.Errorf("expected the cell error to be returned")
+	}
+
+	if builds != 2 {
+		t.Errorf("expected every cell to be built but got %v builds", builds)
+	}
+}
+
+func TestStatusContext(t *testing.T) {
+	if ctx := statusContext("grim-server", hookEvent{}); ctx != "grim-server" {
+		t.Errorf("unexpected context %v", ctx)
+	}
+
+	if ctx := statusContext("grim-server", hookEvent{MatrixCell: "GO_VERSION=1.8"}); ctx != "grim-server/GO_VERSION=1.8" {
+		t.Errorf("unexpected context %v", ctx)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"text/template"
 	"time"
 
@@ -54,6 +55,64 @@ var GrimSuccess = &standardGrimNotification{
 	func(c localConfig) string { return c.successTemplate() },
 }
 
+//GrimTagSuccess is the notification used when builds of tags and releases succeed.
+var GrimTagSuccess = &standardGrimNotification{
+	RSSuccess,
+	func(c localConfig) string { return c.successColor() },
+	func(c localConfig) string { return c.tagTemplate() },
+}
+
+//GrimSkipped is the notification used when a repo's own config excludes a build that was already started.
+//GitHub has no neutral status so it is a success whose description says why it was skipped.
+var GrimSkipped = &standardGrimNotification{
+	RSSuccess,
+	func(c localConfig) string { return c.skippedColor() },
+	func(c localConfig) string { return c.skippedTemplate() },
+}
+
+//GrimAwaitingApproval is the notification used when a pull request from a fork is waiting on a maintainer's approval.
+var GrimAwaitingApproval = &standardGrimNotification{
+	RSPending,
+	func(c localConfig) string { return c.pendingColor() },
+	func(c localConfig) string { return *defaultTemplateForAwaitingApproval },
+}
+
+//GrimUnauthorized is the notification used when the user who triggered a build is not permitted to build.
+var GrimUnauthorized = &standardGrimNotification{
+	RSError,
+	func(c localConfig) string { return c.errorColor() },
+	func(c localConfig) string { return *defaultTemplateForUnauthorized },
+}
+
+//GrimTimeout is the notification used when a build is stopped for running longer than its timeout.
+var GrimTimeout = &standardGrimNotification{
+	RSError,
+	func(c localConfig) string { return c.errorColor() },
+	func(c localConfig) string { return c.timeoutTemplate() },
+}
+
+//GrimCancelled is the notification used when a build is cancelled while it runs.
+var GrimCancelled = &standardGrimNotification{
+	RSError,
+	func(c localConfig) string { return c.errorColor() },
+	func(c localConfig) string { return *defaultTemplateForCancelled },
+}
+
+// limitExceededNotification is used when a build fails because it used too much of a resource.
+type limitExceededNotification struct {
+	limit string
+}
+
+func (n limitExceededNotification) GithubRefStatus() refStatusState {
+	return RSFailure
+}
+
+func (n limitExceededNotification) HipchatNotification(context *grimNotificationContext, config localConfig) (string, string, error) {
+	context.Limit = n.limit
+	message, err := context.render(config.limitTemplate())
+	return message, config.failureColor(), err
+}
+
 func (s *standardGrimNotification) GithubRefStatus() refStatusState {
 	return s.githubState
 }
@@ -64,13 +123,17 @@ func (s *standardGrimNotification) HipchatNotification(context *grimNotification
 }
 
 type grimNotificationContext struct {
-	Owner     string
-	Repo      string
-	EventName string
-	Target    string
-	UserName  string
-	Workspace string
-	LogDir    string
+	Owner      string
+	Repo       string
+	EventName  string
+	Target     string
+	UserName   string
+	Workspace  string
+	LogDir     string
+	MatrixCell string
+	Tag        string
+	Limit      string
+	Reason     string
 }
 
 func (c *grimNotificationContext) render(templateString string) (string, error) {
@@ -87,20 +150,43 @@ func (c *grimNotificationContext) render(templateString string) (string, error)
 	return doc.String(), nil
 }
 
-func buildContext(hook hookEvent, ws, logDir string) *grimNotificationContext {
-	return &grimNotificationContext{hook.Owner, hook.Repo, hook.EventName, hook.Target, hook.UserName, ws, logDir}
+func buildContext(hook hookEvent, ws, logDir, reason string) *grimNotificationContext {
+	return &grimNotificationContext{hook.Owner, hook.Repo, hook.EventName, hook.Target, hook.UserName, ws, logDir, hook.MatrixCell, hook.Tag, "", reason}
 }
 
 func notify(config localConfig, hook hookEvent, ws, logDir string, notification grimNotification, logger *log.Logger) error {
-	if hook.EventName != "push" && hook.EventName != "pull_request" {
+	return sendNotification(config, hook, buildContext(hook, ws, logDir, ""), notification, logger)
+}
+
+// skippedReason is the description of the status of a build that was skipped, eg.
+// "skipped: the branch "wip" did not match any of ["master"]".
+func skippedReason(reason string) string {
+	return "skipped: " + strings.TrimPrefix(reason, "because ")
+}
+
+// notifyWithReason is notify for notifications that say why a build didn't run or finish.
+// The reason is shown in the commit status instead of the log directory.
+func notifyWithReason(config localConfig, hook hookEvent, ws, reason string, notification grimNotification, logger *log.Logger) error {
+	return sendNotification(config, hook, buildContext(hook, ws, "", reason), notification, logger)
+}
+
+func sendNotification(config localConfig, hook hookEvent, context *grimNotificationContext, notification grimNotification, logger *log.Logger) error {
+	switch hook.EventName {
+	case "push", "pull_request", "tag", "release":
+	default:
 		return nil
 	}
 
-	repoStatus := createGithubRepoStatus(config.grimServerID(), notification.GithubRefStatus(), logDir)
+	description := context.LogDir
+	if context.Reason != "" {
+		description = context.Reason
+	}
+
+	repoStatus := createGithubRepoStatus(statusContext(config.grimServerID(), hook), notification.GithubRefStatus(), description)
 	ghErr := setRefStatus(config.gitHubToken(), hook.Owner, hook.Repo, hook.StatusRef, repoStatus)
 
-	context := buildContext(hook, ws, logDir)
 	message, color, err := notification.HipchatNotification(context, config)
+	message = config.redactor().redact(message)
 	logger.Print(message)
 
 	if config.hipChatToken() != "" && config.hipChatRoom() != "" {
@@ -129,13 +215,28 @@ func notify(config localConfig, hook hookEvent, ws, logDir string, notification
 	return ghErr
 }
 
-func createGithubRepoStatus(serverID string, state refStatusState, logDir string) *github.RepoStatus {
+// each cell of a build matrix gets its own status so that they don't overwrite each other
+func statusContext(serverID string, hook hookEvent) string {
+	if hook.MatrixCell == "" {
+		return serverID
+	}
+
+	return fmt.Sprintf("%v/%v", serverID, hook.MatrixCell)
+}
+
+// GitHub rejects commit statuses whose description is longer than this many characters
+const maxStatusDescription = 140
+
+func createGithubRepoStatus(serverID string, state refStatusState, text string) *github.RepoStatus {
 	stateStr := string(state)
-	description := fmt.Sprintf("%v - %v", logDir, time.Now().Format(time.RFC822))
+	suffix := fmt.Sprintf(" - %v", time.Now().Format(time.RFC822))
 
-	if len(description) >= 1024 {
-		description = fmt.Sprintf("...%v", description[10:])
+	// the time is kept and the text is shortened to fit
+	runes := []rune(text)
+	if room := maxStatusDescription - len([]rune(suffix)); len(runes) > room {
+		runes = append(runes[:room-len("...")], []rune("...")...)
 	}
+	description := string(runes) + suffix
 
 	return &github.RepoStatus{
 		State:       &stateStr,
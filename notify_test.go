@@ -19,13 +19,16 @@ var testContext = &grimNotificationContext{
 	UserName:  "mainly",
 	Workspace: "boogey/nights",
 	LogDir:    "once/again/where/it/rains",
+	Tag:       "v1.2",
 }
 
-var testConfig = localConfig{local: configMap{
+var testConfig = testLocalConfig(localConfig{local: configMap{
 	"PendingTemplate": "pending {{.Owner}}",
 	"ErrorTemplate":   "error {{.Repo}}",
 	"FailureTemplate": "failure {{.Target}}",
-	"SuccessTemplate": "success {{.UserName}}"}}
+	"SuccessTemplate": "success {{.UserName}}",
+	"TagTemplate":     "tag {{.Tag}}",
+	"TimeoutTemplate": "timeout {{.EventName}}"}})
 
 var testHook = hookEvent{
 	Owner:     "MediaMath",
@@ -53,11 +56,11 @@ func TestLoggingHipChatErrorCreatingMessage(t *testing.T) {
 	var buf bytes.Buffer
 	logger := log.New(&buf, "", log.Lshortfile)
 
-	testConfigWithHC := localConfig{local: configMap{
+	testConfigWithHC := testLocalConfig(localConfig{local: configMap{
 		"PendingTemplate": "pending {{.NOPE}}",
 		"HipChatToken":    "NOT_EMPTY",
 		"HipChatRoom":     "NON_EMPTY",
-	}}
+	}})
 
 	notify(testConfigWithHC, testHook, "", "", GrimPending, logger)
 	message := fmt.Sprintf("%v", &buf)
@@ -71,11 +74,11 @@ func TestLoggingHipChatErrorSendingMessage(t *testing.T) {
 	var buf bytes.Buffer
 	logger := log.New(&buf, "", log.Lshortfile)
 
-	testConfigWithHC := localConfig{local: configMap{
+	testConfigWithHC := testLocalConfig(localConfig{local: configMap{
 		"PendingTemplate": "pending {{.Owner}}",
 		"HipChatToken":    "NOT_EMPTY",
 		"HipChatRoom":     "NON_EMPTY",
-	}}
+	}})
 
 	notify(testConfigWithHC, testHook, "", "", GrimPending, logger)
 	message := fmt.Sprintf("%v", &buf)
@@ -93,11 +96,11 @@ func TestLogDirForBasename(t *testing.T) {
 	var buf bytes.Buffer
 	logger := log.New(&buf, "", log.Lshortfile)
 
-	testConfigWithHC := localConfig{local: configMap{
+	testConfigWithHC := testLocalConfig(localConfig{local: configMap{
 		"ErrorTemplate": "error {{.LogDir}}",
 		"HipChatToken":  "NOT_EMPTY",
 		"HipChatRoom":   "NON_EMPTY",
-	}}
+	}})
 
 	notify(testConfigWithHC, testHook, "", "temp/MediaMath/grim/123123", GrimError, logger)
 	message := fmt.Sprintf("%v", &buf)
@@ -131,6 +134,18 @@ func TestSuccess(t *testing.T) {
 	}
 }
 
+func TestTagSuccess(t *testing.T) {
+	if err := compareNotification(GrimTagSuccess, RSSuccess, "green", "tag v1.2"); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	if err := compareNotification(GrimTimeout, RSError, "gray", "timeout falls"); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
 func compareNotification(n *standardGrimNotification, state refStatusState, color string, message string) error {
 	if n.GithubRefStatus() != state {
 		return fmt.Errorf("Github: %v", n)
@@ -165,3 +180,60 @@ func TestContextRender(t *testing.T) {
 		t.Errorf("Didn't match %v", errStr)
 	}
 }
+
+func TestSkippedNotification(t *testing.T) {
+	if GrimSkipped.GithubRefStatus() != RSSuccess {
+		t.Errorf("a skipped build should not look like a broken one")
+	}
+
+	if reason := skippedReason(`because the branch "wip" did not match any of ["master"]`); reason != `skipped: the branch "wip" did not match any of ["master"]` {
+		t.Errorf("expected the status to say why the build was skipped but got %q", reason)
+	}
+
+	config := testLocalConfig(localConfig{local: configMap{"SkippedTemplate": "skipped {{.Repo}}", "SkippedColor": "purple"}})
+	message, color, err := GrimSkipped.HipchatNotification(&grimNotificationContext{Repo: "grim"}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if message != "skipped grim" || color != "purple" {
+		t.Errorf("expected the configured template and color but got %q %q", message, color)
+	}
+}
+
+func TestUnauthorizedNotificationShowsReason(t *testing.T) {
+	context := &grimNotificationContext{Owner: "MediaMath", Repo: "grim", EventName: "push", Target: "master", Reason: "bob is not a collaborator"}
+	message, _, err := GrimUnauthorized.HipchatNotification(context, localConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if message != "Not building MediaMath/grim push to master: bob is not a collaborator" {
+		t.Errorf("unexpected message %q", message)
+	}
+}
+
+func TestCancelledNotificationShowsReason(t *testing.T) {
+	context := &grimNotificationContext{Owner: "MediaMath", Repo: "grim", EventName: "push", Target: "master", UserName: "bob", LogDir: "once/again", Reason: "cancelled by alice"}
+	message, _, err := GrimCancelled.HipchatNotification(context, localConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if message != "Build of MediaMath/grim initiated by a push to master by bob was cancelled by alice" {
+		t.Errorf("unexpected message %q", message)
+	}
+}
+
+func TestRepoStatusDescriptionFitsGitHubLimit(t *testing.T) {
+	repoStatus := createGithubRepoStatus("grim", RSError, strings.Repeat("é", 200))
+	description := []rune(*repoStatus.Description)
+
+	if len(description) != maxStatusDescription {
+		t.Errorf("expected the description to be cut to %v characters but it has %v", maxStatusDescription, len(description))
+	}
+
+	if !strings.Contains(string(description), "... - ") {
+		t.Errorf("expected the text to be shortened before the time: %q", string(description))
+	}
+}
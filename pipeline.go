@@ -0,0 +1,164 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+)
+
+var validStepName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+type pipelineStep struct {
+	Name              string `json:"name"`
+	Command           string `json:"command"`
+	Timeout           int    `json:"timeout"`
+	ContinueOnFailure bool   `json:"continue_on_failure"`
+}
+
+type stepResult struct {
+	Name              string
+	StartTime         time.Time
+	EndTime           time.Time
+	SysTime           time.Duration
+	UserTime          time.Duration
+	ExitCode          int
+	ContinueOnFailure bool
+	Skipped           bool
+	LimitExceeded     string `json:",omitempty"`
+	TimedOut          bool   `json:",omitempty"`
+	Cancelled         bool   `json:",omitempty"`
+}
+
+func (step pipelineStep) timeout(fallback time.Duration) time.Duration {
+	if step.Timeout > 0 {
+		return time.Duration(step.Timeout) * time.Second
+	}
+
+	return fallback
+}
+
+func validatePipeline(steps []pipelineStep) error {
+	seen := make(map[string]bool)
+
+	for i, step := range steps {
+		if !validStepName.MatchString(step.Name) {
+			return fmt.Errorf("pipeline step %d has an invalid name %q; names may only contain letters, digits, '.', '_' and '-'", i+1, step.Name)
+		}
+
+		if seen[step.Name] {
+			return fmt.Errorf("pipeline step name %q is used more than once", step.Name)
+		}
+		seen[step.Name] = true
+
+		if step.Command == "" {
+			return fmt.Errorf("pipeline step %q has no command", step.Name)
+		}
+
+		if step.Timeout < 0 {
+			return fmt.Errorf("pipeline step %q has a negative timeout", step.Name)
+		}
+	}
+
+	return nil
+}
+
+func runPipeline(builder grimBuilder, workspacePath, resultPath string, steps []pipelineStep, statusLogger *log.Logger) (*executeResult, error) {
+	var (
+		stepResults []stepResult
+		results     []*executeResult
+		failed      bool
+	)
+
+	for _, step := range steps {
+		if failed {
+			statusLogger.Printf("step %v skipped\n", step.Name)
+			stepResults = append(stepResults, stepResult{Name: step.Name, ContinueOnFailure: step.ContinueOnFailure, Skipped: true})
+			continue
+		}
+
+		stepPath, err := makeTree(resultPath, "steps", step.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create result directory for step %v: %v", step.Name, err)
+		}
+
+		outputChan := make(chan string)
+		go writeOutput(stepPath, outputChan, builder.Redactor())
+
+		statusLogger.Printf("step %v started ...\n", step.Name)
+		result, err := builder.RunPipelineStep(workspacePath, step, outputChan)
+		if err != nil {
+			statusLogger.Printf("step %v error %v\n", step.Name, err)
+			return nil, fmt.Errorf("error during step %v: %v", step.Name, err)
+		}
+
+		result.InitialEnv = builder.Redactor().redactEnv(result.InitialEnv)
+		if err := writeResult(stepPath, result); err != nil {
+			return nil, fmt.Errorf("error while storing result of step %v: %v", step.Name, err)
+		}
+
+		if result.TimedOut {
+			statusLogger.Printf("step %v timed out\n", step.Name)
+		}
+
+		if result.ExitCode == 0 {
+			statusLogger.Printf("step %v success\n", step.Name)
+		} else if result.Cancelled {
+			statusLogger.Printf("step %v cancelled\n", step.Name)
+			failed = true
+		} else if step.ContinueOnFailure {
+			statusLogger.Printf("step %v failed %v, continuing\n", step.Name, result.ExitCode)
+		} else {
+			statusLogger.Printf("step %v failed %v\n", step.Name, result.ExitCode)
+			failed = true
+		}
+
+		stepResults = append(stepResults, stepResult{
+			Name:              step.Name,
+			StartTime:         result.StartTime,
+			EndTime:           result.EndTime,
+			SysTime:           result.SysTime,
+			UserTime:          result.UserTime,
+			ExitCode:          result.ExitCode,
+			ContinueOnFailure: step.ContinueOnFailure,
+			LimitExceeded:     result.LimitExceeded,
+			TimedOut:          result.TimedOut,
+			Cancelled:         result.Cancelled,
+		})
+		results = append(results, result)
+	}
+
+	return aggregateStepResults(stepResults, results), nil
+}
+
+// the overall exit code is that of the first failed step that was not allowed to fail or was cancelled
+func aggregateStepResults(stepResults []stepResult, results []*executeResult) *executeResult {
+	aggregate := &executeResult{Steps: stepResults}
+
+	for i, result := range results {
+		if i == 0 {
+			aggregate.StartTime = result.StartTime
+			aggregate.InitialEnv = result.InitialEnv
+		}
+
+		aggregate.EndTime = result.EndTime
+		aggregate.SysTime += result.SysTime
+		aggregate.UserTime += result.UserTime
+	}
+
+	for _, step := range stepResults {
+		if !step.Skipped && (!step.ContinueOnFailure || step.Cancelled) && step.ExitCode != 0 {
+			aggregate.ExitCode = step.ExitCode
+			aggregate.LimitExceeded = step.LimitExceeded
+			aggregate.TimedOut = step.TimedOut
+			aggregate.Cancelled = step.Cancelled
+			break
+		}
+	}
+
+	return aggregate
+}
@@ -0,0 +1,247 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipelineFromLocalConfig(t *testing.T) {
+	var local configMap
+	err := json.Unmarshal([]byte(`{"Pipeline": [
+		{"name": "lint", "command": "make lint", "continue_on_failure": true},
+		{"name": "test", "command": "make test", "timeout": 30}
+	]}`), &local)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps, err := testLocalConfig(localConfig{local: local}).pipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(steps) != 2 {
+		t.Fatalf("expected 2 steps but got %v", steps)
+	}
+
+	if steps[0].Name != "lint" || !steps[0].ContinueOnFailure || steps[0].timeout(time.Minute) != time.Minute {
+		t.Errorf("lint step was not parsed correctly: %+v", steps[0])
+	}
+
+	if steps[1].Command != "make test" || steps[1].timeout(time.Minute) != 30*time.Second {
+		t.Errorf("test step was not parsed correctly: %+v", steps[1])
+	}
+}
+
+func TestNoPipelineInLocalConfig(t *testing.T) {
+	steps, err := testLocalConfig(localConfig{local: configMap{}}).pipeline()
+	if err != nil || steps != nil {
+		t.Errorf("expected no pipeline but got %v %v", steps, err)
+	}
+}
+
+func TestInvalidPipelines(t *testing.T) {
+	invalid := [][]pipelineStep{
+		{{Name: "", Command: "true"}},
+		{{Name: "a/b", Command: "true"}},
+		{{Name: "a", Command: "true"}, {Name: "a", Command: "true"}},
+		{{Name: "a"}},
+		{{Name: "a", Command: "true", Timeout: -1}},
+	}
+
+	for _, steps := range invalid {
+		if err := validatePipeline(steps); err == nil {
+			t.Errorf("expected %+v to be invalid", steps)
+		}
+	}
+
+	if err := testSettingError(t, "Pipeline", `"not a list"`, func(lc localConfig) error { _, err := lc.pipeline(); return err }); err == nil {
+		t.Errorf("expected a string pipeline to be invalid")
+	}
+}
+
+func TestRepoPipeline(t *testing.T) {
+	yml := `
+pipeline:
+  - name: build
+    command: make
+  - name: test
+    command: make test
+    timeout: 10
+    continue_on_failure: true
+`
+	withTempDir(t, func(path string) {
+		ioutil.WriteFile(filepath.Join(path, ".grim.yml"), []byte(yml), 0644)
+
+		inRepo, _, err := readRepoConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		steps, err := testLocalConfig(localConfig{local: configMap{}}).withInRepoConfig(inRepo).pipeline()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(steps) != 2 || steps[1].Name != "test" || steps[1].Timeout != 10 || !steps[1].ContinueOnFailure {
+			t.Errorf("pipeline was not parsed correctly: %+v", steps)
+		}
+
+		server := configMap{"Pipeline": []interface{}{map[string]interface{}{"name": "server", "command": "true"}}}
+		steps, err = testLocalConfig(localConfig{local: server}).withInRepoConfig(inRepo).pipeline()
+		if err != nil || len(steps) != 1 || steps[0].Name != "server" {
+			t.Errorf("server pipeline should take precedence: %+v %v", steps, err)
+		}
+	})
+}
+
+func TestPipelineStopsOnFailure(t *testing.T) {
+	resultPath, _ := ioutil.TempDir("", "pipeline-stops-on-failure")
+	defer os.RemoveAll(resultPath)
+
+	tb := &testBuilder{
+		pipeline: []pipelineStep{
+			{Name: "first", Command: "true"},
+			{Name: "second", Command: "false"},
+			{Name: "third", Command: "true"},
+		},
+		stepResults: map[string]*executeResult{
+			"first":  {ExitCode: 0},
+			"second": {ExitCode: 2},
+			"third":  {ExitCode: 0},
+		},
+	}
+
+	result, _, err := grimBuild(tb, resultPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.ExitCode != 2 {
+		t.Errorf("expected the failing step's exit code but got %v", result.ExitCode)
+	}
+
+	if len(result.Steps) != 3 || !result.Steps[2].Skipped || result.Steps[1].Skipped {
+		t.Errorf("steps were not recorded correctly: %+v", result.Steps)
+	}
+
+	if fileExists(filepath.Join(resultPath, "steps", "third")) {
+		t.Errorf("skipped step should not have a result directory")
+	}
+
+	for _, name := range []string{"first", "second"} {
+		if !fileExists(filepath.Join(resultPath, "steps", name, "result.json")) {
+			t.Errorf("step %v should have a result.json", name)
+		}
+	}
+
+	buildFile, _ := ioutil.ReadFile(filepath.Join(resultPath, "build.txt"))
+	if !strings.Contains(string(buildFile), "step third skipped") {
+		t.Errorf("Failed to log skipped step")
+	}
+}
+
+func TestPipelineContinueOnFailure(t *testing.T) {
+	resultPath, _ := ioutil.TempDir("", "pipeline-continue-on-failure")
+	defer os.RemoveAll(resultPath)
+
+	tb := &testBuilder{
+		pipeline: []pipelineStep{
+			{Name: "lint", Command: "false", ContinueOnFailure: true},
+			{Name: "test", Command: "true"},
+		},
+		stepResults: map[string]*executeResult{
+			"lint": {ExitCode: 1, SysTime: time.Second},
+			"test": {ExitCode: 0, SysTime: time.Second},
+		},
+	}
+
+	result, _, err := grimBuild(tb, resultPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.ExitCode != 0 {
+		t.Errorf("a step allowed to fail should not fail the build: %v", result.ExitCode)
+	}
+
+	if result.SysTime != 2*time.Second {
+		t.Errorf("step times were not aggregated: %v", result.SysTime)
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(resultPath, "result.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored executeResult
+	if err := json.Unmarshal(bs, &stored); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stored.Steps) != 2 || stored.Steps[0].ExitCode != 1 || stored.Steps[0].Name != "lint" {
+		t.Errorf("steps were not stored in result.json: %+v", stored.Steps)
+	}
+}
+
+func TestPipelineStepTimeout(t *testing.T) {
+	resultPath, _ := ioutil.TempDir("", "pipeline-step-timeout")
+	defer os.RemoveAll(resultPath)
+
+	tb := &testBuilder{
+		timeoutHookPath: "cleanup.sh",
+		pipeline: []pipelineStep{
+			{Name: "test", Command: "sleep 100", Timeout: 1},
+			{Name: "deploy", Command: "true"},
+		},
+		stepResults: map[string]*executeResult{
+			"test": {ExitCode: exitCodeTimedOut, TimedOut: true},
+		},
+	}
+
+	result, _, err := grimBuild(tb, resultPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.TimedOut || result.ExitCode != exitCodeTimedOut {
+		t.Errorf("the step timing out was not recorded: %+v", result)
+	}
+
+	if !result.Steps[0].TimedOut || !result.Steps[1].Skipped {
+		t.Errorf("unexpected steps: %+v", result.Steps)
+	}
+
+	if !tb.ranTimeoutHook {
+		t.Errorf("on timeout script was not run")
+	}
+}
+
+func TestRunPipelineStep(t *testing.T) {
+	withTempDir(t, func(path string) {
+		ws := &workspaceBuilder{timeout: testBuildtimeout}
+		outputChan := make(chan string)
+		go func() {
+			for range outputChan {
+			}
+		}()
+
+		result, err := ws.RunPipelineStep(path, pipelineStep{Name: "exit", Command: "exit 3"}, outputChan)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.ExitCode != 3 {
+			t.Errorf("expected exit code 3 but got %v", result.ExitCode)
+		}
+	})
+}
@@ -0,0 +1,349 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// repoPlan is how a repo's SNS topic, subscription, queue policy entry and GitHub hook
+// differ from what provisioning would leave them as.
+type repoPlan struct {
+	topicARN   string // "" if the topic doesn't exist
+	subscribed bool
+	inPolicy   bool
+	hookDrift  []string
+}
+
+func planRepo(p provisioner, config localConfig, queue *sqsQueue, policyTopics []string) (plan repoPlan, err error) {
+	if err = checkConfiguredTopic(config, queue); err != nil {
+		return
+	}
+
+	plan.topicARN, err = p.findTopic(config)
+	if err != nil {
+		return
+	} else if plan.topicARN == "" && config.snsTopicARN() != "" {
+		// grim only creates the topics it names
+		err = fmt.Errorf("SNS topic %v of %v/%v doesn't exist", config.snsTopicARN(), config.owner, config.repo)
+		return
+	}
+
+	if plan.topicARN != "" && queue != nil {
+		var subscribers []string
+		subscribers, err = p.subscribers(config.global, plan.topicARN)
+		if err != nil {
+			return
+		}
+
+		plan.subscribed = containsString(subscribers, queue.ARN)
+		plan.inPolicy = containsString(policyTopics, plan.topicARN)
+	}
+
+	plan.hookDrift, err = p.hookDrift(config, plan.topicARN)
+
+	return
+}
+
+// checkConfiguredTopic is an error if the repo's topic is given by an ARN that grim's queue
+// can't be subscribed to.
+func checkConfiguredTopic(config localConfig, queue *sqsQueue) error {
+	topicARN := config.snsTopicARN()
+	if topicARN == "" {
+		return nil
+	}
+
+	queueARN := ""
+	if queue != nil {
+		queueARN = queue.ARN
+	}
+
+	if err := checkTopicARN(topicARN, config.global.awsRegion(), queueARN); err != nil {
+		return fmt.Errorf("error with SNS topic of %v/%v: %v", config.owner, config.repo, err)
+	}
+
+	return nil
+}
+
+// changes describes what provisioning the repo would do, or is empty if it is up to date.
+func (plan repoPlan) changes(config localConfig) []string {
+	var changes []string
+
+	if plan.topicARN == "" {
+		changes = append(changes, fmt.Sprintf("create SNS topic %v", config.snsTopicName()))
+	}
+
+	if !plan.subscribed {
+		changes = append(changes, "subscribe the queue to the topic")
+	}
+
+	if !plan.inPolicy {
+		changes = append(changes, "add the topic to the queue policy")
+	}
+
+	if len(plan.hookDrift) == 1 && plan.hookDrift[0] == "missing" {
+		changes = append(changes, "create the AmazonSNS hook")
+	} else if len(plan.hookDrift) > 0 {
+		changes = append(changes, fmt.Sprintf("update the AmazonSNS hook's %v", strings.Join(plan.hookDrift, ", ")))
+	}
+
+	return changes
+}
+
+// apply makes the changes to the repo's topic, subscription and hook, returning the topic's
+// ARN.  The queue policy is set once all repos are provisioned.
+func (plan repoPlan) apply(p provisioner, config localConfig, queue *sqsQueue) (string, error) {
+	topicARN := plan.topicARN
+	if topicARN == "" {
+		var err error
+		if topicARN, err = p.createTopic(config); err != nil {
+			return "", err
+		}
+	}
+
+	if !plan.subscribed {
+		if err := p.subscribe(config.global, topicARN, queue); err != nil {
+			return "", err
+		}
+	}
+
+	// a new topic means the hook has to be pointed at it
+	if len(plan.hookDrift) > 0 || plan.topicARN == "" {
+		if err := p.setHook(config, topicARN); err != nil {
+			return "", err
+		}
+	}
+
+	return topicARN, nil
+}
+
+// Provision sets up the queue and every configured repo like grimd does when it starts,
+// logging what it changed.  If dryRun is set it only reports what it would change.
+func (i *Instance) Provision(dryRun bool, logger *log.Logger) error {
+	if dryRun {
+		return i.planRepos(logger)
+	}
+
+	if err := i.PrepareGrimQueue(logger); err != nil {
+		return err
+	}
+
+	return i.PrepareRepos(logger)
+}
+
+// planRepos logs how each configured repo differs from what PrepareRepos would leave it as.
+func (i *Instance) planRepos(logger *log.Logger) error {
+	configRoot := getEffectiveConfigRoot(i.configRoot)
+
+	global, err := readGlobalConfig(configRoot)
+	if err != nil {
+		return fatalGrimErrorf("error while reading config: %v", err).withKind(ConfigError)
+	}
+
+	p := i.getProvisioner()
+
+	queue, err := p.findQueue(global)
+	if err != nil {
+		return grimErrorf("%v", err)
+	}
+
+	var policyTopics []string
+	if queue == nil {
+		logger.Printf("would create queue %v", global.grimQueueName())
+	} else if policyTopics, err = p.policyTopics(global, queue); err != nil {
+		return grimErrorf("%v", err)
+	}
+
+	repos := getAllConfiguredRepos(configRoot)
+	upToDate, failed := 0, 0
+	var topics []string
+
+	for _, r := range repos {
+		config, err := readLocalConfig(configRoot, r.owner, r.name)
+		if err != nil {
+			logger.Printf("Error with config for %s/%s. %v", r.owner, r.name, err)
+			failed++
+			continue
+		}
+
+		plan, err := planRepo(p, config, queue, policyTopics)
+		if err != nil {
+			logger.Print(err)
+			failed++
+			continue
+		}
+
+		if plan.topicARN != "" && !containsString(topics, plan.topicARN) {
+			topics = append(topics, plan.topicARN)
+		}
+
+		if changes := plan.changes(config); len(changes) > 0 {
+			logger.Printf("%v/%v: would %v", r.owner, r.name, strings.Join(changes, ", "))
+		} else {
+			logger.Printf("%v/%v: up to date", r.owner, r.name)
+			upToDate++
+		}
+	}
+
+	for _, topicARN := range policyTopics {
+		if !containsString(topics, topicARN) {
+			logger.Printf("would remove %v from the queue policy", topicARN)
+		}
+	}
+
+	logger.Printf("%v repos: %v up to date, %v would change, %v failed", len(repos), upToDate, len(repos)-upToDate-failed, failed)
+
+	if failed > 0 {
+		return grimErrorf("%v of %v repos could not be checked", failed, len(repos))
+	}
+
+	return nil
+}
+
+// PrepareRepos discovers all repos that are configured then sets up SNS and GitHub where
+// they differ from what they should be.  A repo that can't be set up is logged and tried
+// again by ReloadRepos.  It is an error to call this without calling PrepareGrimQueue
+// first.
+func (i *Instance) PrepareRepos(logger *log.Logger) error {
+	if err := i.checkGrimQueue(); err != nil {
+		return err
+	}
+
+	configRoot := getEffectiveConfigRoot(i.configRoot)
+
+	config, err := readGlobalConfig(configRoot)
+	if err != nil {
+		return fatalGrimErrorf("error while reading config: %v", err).withKind(ConfigError)
+	}
+
+	p := i.getProvisioner()
+
+	policyTopics, err := p.policyTopics(config, i.queue)
+	if err != nil {
+		// the policy is set again below
+		logger.Print(err)
+	}
+
+	repos := getAllConfiguredRepos(configRoot)
+	upToDate, failed := 0, 0
+
+	provisioned := make(map[repo]provisionedRepo)
+	unreadable := make(map[repo]string)
+	var readable, unprovisioned []localConfig
+	for _, r := range repos {
+		localConfig, err := readLocalConfig(configRoot, r.owner, r.name)
+		if err != nil {
+			logger.Printf("Error with config for %s/%s. %v", r.owner, r.name, err)
+			unreadable[r] = err.Error()
+			failed++
+			continue
+		}
+		readable = append(readable, localConfig)
+
+		plan, err := planRepo(p, localConfig, i.queue, policyTopics)
+		if err != nil {
+			logger.Printf("error provisioning %v/%v: %v", r.owner, r.name, err)
+			unprovisioned = append(unprovisioned, localConfig)
+			failed++
+			continue
+		}
+
+		changes := plan.changes(localConfig)
+		if len(changes) == 0 {
+			provisioned[r] = provisionedRepo{localConfig, plan.topicARN}
+			upToDate++
+			continue
+		}
+
+		snsTopicARN, err := plan.apply(p, localConfig, i.queue)
+		if err != nil {
+			logger.Printf("error provisioning %v/%v: %v", r.owner, r.name, err)
+			unprovisioned = append(unprovisioned, localConfig)
+			failed++
+			continue
+		}
+
+		logger.Printf("provisioned %v/%v: %v", r.owner, r.name, strings.Join(changes, ", "))
+		provisioned[r] = provisionedRepo{localConfig, snsTopicARN}
+	}
+
+	kept := keptPolicyTopics(policyTopics, readable, unprovisioned, len(unreadable) > 0)
+	if topics := mergeTopics(topicARNs(provisioned), kept); !reflect.DeepEqual(topics, policyTopics) {
+		if err := p.setPolicy(config, i.queue, topics); err != nil {
+			return fatalGrimErrorf("%v", err)
+		}
+	}
+
+	i.global = config
+	i.provisioned = provisioned
+	i.unreadable = unreadable
+	i.keptTopics = kept
+
+	logger.Printf("%v repos: %v up to date, %v changed, %v failed", len(repos), upToDate, len(repos)-upToDate-failed, failed)
+
+	if failed > 0 {
+		return grimErrorf("%v of %v repos failed to provision", failed, len(repos))
+	}
+
+	return nil
+}
+
+// keptPolicyTopics are the topics in the queue policy of repos that couldn't be provisioned,
+// so that a failure doesn't stop their messages being delivered.  The topics of repos whose
+// config can't be read aren't known, so while there are any every policy topic that no
+// readable repo uses is kept.
+func keptPolicyTopics(policyTopics []string, readable, unprovisioned []localConfig, anyUnreadable bool) []string {
+	var kept []string
+	for _, topicARN := range policyTopics {
+		used := false
+		for _, config := range unprovisioned {
+			if usesTopic(config, topicARN) {
+				kept = append(kept, topicARN)
+				used = true
+				break
+			}
+		}
+
+		if used || !anyUnreadable {
+			continue
+		}
+
+		for _, config := range readable {
+			used = used || usesTopic(config, topicARN)
+		}
+
+		if !used {
+			kept = append(kept, topicARN)
+		}
+	}
+
+	return kept
+}
+
+// usesTopic is whether the topic is the repo's, by its ARN or by its name.
+func usesTopic(config localConfig, topicARN string) bool {
+	if configured := config.snsTopicARN(); configured != "" {
+		return configured == topicARN
+	}
+
+	return getResourceFromARN(topicARN) == config.snsTopicName()
+}
+
+// mergeTopics are the distinct topics of both lists, sorted so they can be compared.
+func mergeTopics(a, b []string) []string {
+	var topics []string
+	for _, topicARN := range append(append([]string{}, a...), b...) {
+		if !containsString(topics, topicARN) {
+			topics = append(topics, topicARN)
+		}
+	}
+	sort.Strings(topics)
+
+	return topics
+}
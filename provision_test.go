@@ -0,0 +1,214 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func withDriftedRepos(t *testing.T, f func(path string, p *testProvisioner)) {
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, configFileName, `{}`)
+		for _, name := range []string{"drifted", "failing", "newtopic", "unsubscribed", "uptodate"} {
+			writeConfig(t, path, "MediaMath/"+name+"/config.json", `{}`)
+		}
+
+		p := &testProvisioner{
+			fail:          map[string]bool{"failing": true},
+			missingTopics: map[string]bool{"grim-MediaMath-failing-repo-topic": true, "grim-MediaMath-newtopic-repo-topic": true},
+			subscriptions: map[string][]string{"arn:grim-MediaMath-unsubscribed-repo-topic": nil},
+			hookDrifts:    map[string][]string{"drifted": {"sns_topic"}, "newtopic": {"missing"}},
+			currentPolicy: []string{
+				"arn:grim-MediaMath-drifted-repo-topic",
+				"arn:grim-MediaMath-unsubscribed-repo-topic",
+				"arn:grim-MediaMath-uptodate-repo-topic",
+				"arn:stale",
+			},
+		}
+
+		f(path, p)
+	})
+}
+
+func TestPrepareReposAppliesOnlyDifferences(t *testing.T) {
+	withDriftedRepos(t, func(path string, p *testProvisioner) {
+		i := testInstance(path, p)
+
+		var buf bytes.Buffer
+		err := i.PrepareRepos(log.New(&buf, "", 0))
+		if err == nil || IsFatal(err) {
+			t.Errorf("expected a failed repo to be a non-fatal error but got %v", err)
+		}
+
+		expectedCalls := []string{
+			"set hook MediaMath/drifted",
+			"create topic grim-MediaMath-failing-repo-topic",
+			"create topic grim-MediaMath-newtopic-repo-topic",
+			"subscribe arn:grim-MediaMath-newtopic-repo-topic",
+			"set hook MediaMath/newtopic",
+			"subscribe arn:grim-MediaMath-unsubscribed-repo-topic",
+			"set policy",
+		}
+
+		if !reflect.DeepEqual(p.calls, expectedCalls) {
+			t.Errorf("expected %v but got %v", expectedCalls, p.calls)
+		}
+
+		expectedPolicy := []string{
+			"arn:grim-MediaMath-drifted-repo-topic",
+			"arn:grim-MediaMath-newtopic-repo-topic",
+			"arn:grim-MediaMath-unsubscribed-repo-topic",
+			"arn:grim-MediaMath-uptodate-repo-topic",
+		}
+
+		if !reflect.DeepEqual(p.policy, expectedPolicy) {
+			t.Errorf("expected policy %v but got %v", expectedPolicy, p.policy)
+		}
+
+		if len(i.provisioned) != 4 {
+			t.Errorf("expected the repos that didn't fail to be provisioned: %v", i.provisioned)
+		}
+
+		for _, expected := range []string{
+			"error provisioning MediaMath/failing: creating topic failed",
+			"provisioned MediaMath/unsubscribed: subscribe the queue to the topic",
+			"5 repos: 1 up to date, 3 changed, 1 failed",
+		} {
+			if !strings.Contains(buf.String(), expected) {
+				t.Errorf("expected %q to be logged: %v", expected, buf.String())
+			}
+		}
+	})
+}
+
+func TestPrepareReposUpToDate(t *testing.T) {
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, configFileName, `{}`)
+		writeConfig(t, path, "MediaMath/uptodate/config.json", `{}`)
+
+		p := &testProvisioner{currentPolicy: []string{"arn:grim-MediaMath-uptodate-repo-topic"}}
+		i := testInstance(path, p)
+
+		if err := i.PrepareRepos(log.New(&bytes.Buffer{}, "", 0)); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(p.calls) != 0 {
+			t.Errorf("an up to date repo was changed: %v", p.calls)
+		}
+	})
+}
+
+func TestProvisionDryRun(t *testing.T) {
+	withDriftedRepos(t, func(path string, p *testProvisioner) {
+		i := testInstance(path, p)
+
+		var buf bytes.Buffer
+		if err := i.Provision(true, log.New(&buf, "", 0)); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(p.calls) != 0 {
+			t.Errorf("a dry run changed %v", p.calls)
+		}
+
+		for _, expected := range []string{
+			"MediaMath/drifted: would update the AmazonSNS hook's sns_topic",
+			"MediaMath/newtopic: would create SNS topic grim-MediaMath-newtopic-repo-topic, subscribe the queue to the topic, add the topic to the queue policy, create the AmazonSNS hook",
+			"MediaMath/unsubscribed: would subscribe the queue to the topic",
+			"MediaMath/uptodate: up to date",
+			"would remove arn:stale from the queue policy",
+			"5 repos: 1 up to date, 4 would change, 0 failed",
+		} {
+			if !strings.Contains(buf.String(), expected) {
+				t.Errorf("expected %q to be logged: %v", expected, buf.String())
+			}
+		}
+	})
+}
+
+func TestPrepareReposChecksTopicARNs(t *testing.T) {
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, configFileName, `{"AWSRegion": "us-east-1"}`)
+		writeConfig(t, path, "MediaMath/existing/config.json", `{"SNSTopicARN": "arn:aws:sns:us-east-1:123456789012:existing"}`)
+		writeConfig(t, path, "MediaMath/missing/config.json", `{"SNSTopicARN": "arn:aws:sns:us-east-1:123456789012:missing"}`)
+		writeConfig(t, path, "MediaMath/other-account/config.json", `{"SNSTopicARN": "arn:aws:sns:us-east-1:210987654321:other"}`)
+
+		p := &testProvisioner{
+			missingTopics: map[string]bool{"missing": true},
+			hookDrifts:    map[string][]string{"existing": {"missing"}},
+		}
+		i := testInstance(path, p)
+		i.queue.ARN = "arn:aws:sqs:us-east-1:123456789012:grim-queue"
+
+		var buf bytes.Buffer
+		if err := i.PrepareRepos(log.New(&buf, "", 0)); err == nil {
+			t.Errorf("expected the repos with bad topics to fail")
+		}
+
+		expectedCalls := []string{
+			"subscribe arn:aws:sns:us-east-1:123456789012:existing",
+			"set hook MediaMath/existing",
+			"set policy",
+		}
+
+		if !reflect.DeepEqual(p.calls, expectedCalls) {
+			t.Errorf("expected %v but got %v", expectedCalls, p.calls)
+		}
+
+		for _, expected := range []string{
+			"SNS topic arn:aws:sns:us-east-1:123456789012:missing of MediaMath/missing doesn't exist",
+			"arn:aws:sns:us-east-1:210987654321:other is in account 210987654321, not the queue's account 123456789012",
+			"3 repos: 0 up to date, 1 changed, 2 failed",
+		} {
+			if !strings.Contains(buf.String(), expected) {
+				t.Errorf("expected %q to be logged: %v", expected, buf.String())
+			}
+		}
+	})
+}
+
+func TestPrepareReposKeepsPolicyTopicsOfFailedRepos(t *testing.T) {
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, configFileName, `{}`)
+		writeConfig(t, path, "MediaMath/failing/config.json", `{}`)
+		writeConfig(t, path, "MediaMath/uptodate/config.json", `{}`)
+
+		failingTopic := "arn:aws:sns:us-east-1:123456789012:grim-MediaMath-failing-repo-topic"
+		p := &testProvisioner{
+			fail:          map[string]bool{"failing": true},
+			missingTopics: map[string]bool{"grim-MediaMath-failing-repo-topic": true},
+			currentPolicy: []string{failingTopic, "arn:grim-MediaMath-uptodate-repo-topic", "arn:stale"},
+		}
+
+		testInstance(path, p).PrepareRepos(log.New(&bytes.Buffer{}, "", 0))
+
+		if expected := []string{failingTopic, "arn:grim-MediaMath-uptodate-repo-topic"}; !reflect.DeepEqual(p.policy, expected) {
+			t.Errorf("expected policy %v but got %v", expected, p.policy)
+		}
+
+		// the topic of a repo whose config can't be read isn't known, so no topic is dropped
+		writeConfig(t, path, "MediaMath/unreadable/config.json", badContents)
+		p.policy = nil
+
+		i := testInstance(path, p)
+		i.PrepareRepos(log.New(&bytes.Buffer{}, "", 0))
+
+		if p.policy != nil {
+			t.Errorf("the policy should have been left as it was but got %v", p.policy)
+		}
+
+		p.calls = nil
+		i.ReloadRepos(log.New(&bytes.Buffer{}, "", 0))
+
+		if expected := []string{"provision MediaMath/failing"}; !reflect.DeepEqual(p.calls, expected) {
+			t.Errorf("reloading while repos fail should keep their topics but got %v", p.calls)
+		}
+	})
+}
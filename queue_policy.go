@@ -0,0 +1,180 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+const (
+	policyVersion = "2012-10-17"
+	policyID      = "grim-policy"
+
+	// legacyPolicySid is the statement grim's policies had before they were merged, which
+	// let anything that could name a topic do anything to the queue.
+	legacyPolicySid = "1"
+)
+
+// queuePolicy is an SQS queue's policy document.  Statements are kept as they were read so
+// that those grim doesn't own are written back unchanged.
+type queuePolicy struct {
+	Version   string                   `json:"Version"`
+	ID        string                   `json:"Id,omitempty"`
+	Statement []map[string]interface{} `json:"Statement"`
+}
+
+// policySid is the Sid of the statement a grim server owns in the queue's policy, so that
+// servers sharing a queue each keep their own.  It uses the whole server id rather than the
+// one truncated for commit statuses, which servers with long ids could share.
+func policySid(config globalConfig) string {
+	return "grim-" + config.rawGrimServerID()
+}
+
+// oldPolicySids are the Sids the server's statement had when it used the truncated server
+// id, which are removed when the statement is set.
+func oldPolicySids(config globalConfig) []string {
+	if config.grimServerIDWasTruncated() {
+		return []string{"grim-" + config.grimServerID()}
+	}
+
+	return nil
+}
+
+// policyStatement lets SNS send the topics' messages to the queue and nothing else.
+func policyStatement(sid, queueARN string, topicARNs []string) map[string]interface{} {
+	return map[string]interface{}{
+		"Sid":       sid,
+		"Effect":    "Allow",
+		"Principal": map[string]interface{}{"Service": "sns.amazonaws.com"},
+		"Action":    "sqs:SendMessage",
+		"Resource":  queueARN,
+		"Condition": map[string]interface{}{
+			"ArnEquals": map[string]interface{}{"aws:SourceArn": topicARNs},
+		},
+	}
+}
+
+func parsePolicy(policy string) (*queuePolicy, error) {
+	doc := &queuePolicy{}
+	if policy == "" {
+		return doc, nil
+	}
+
+	var raw struct {
+		Version   string
+		ID        string `json:"Id"`
+		Statement json.RawMessage
+	}
+
+	if err := json.Unmarshal([]byte(policy), &raw); err != nil {
+		return nil, fmt.Errorf("error while reading policy of SQS queue: %v", err)
+	}
+
+	doc.Version = raw.Version
+	doc.ID = raw.ID
+
+	// a policy with one statement may have it on its own rather than in a list
+	var single map[string]interface{}
+	if err := json.Unmarshal(raw.Statement, &doc.Statement); err != nil {
+		if err := json.Unmarshal(raw.Statement, &single); err != nil {
+			return nil, fmt.Errorf("error while reading statements of SQS queue policy: %v", err)
+		}
+		doc.Statement = []map[string]interface{}{single}
+	}
+
+	return doc, nil
+}
+
+// mergePolicy replaces the statement with the sid in the existing policy with one for the
+// topics, or removes it if there are none.  The statements with the old sids and that of
+// grim's old, unmerged policy are removed too.  The result is "" if the policy has no
+// statements left.
+func mergePolicy(existing, sid string, oldSids []string, queueARN string, topicARNs []string) (string, error) {
+	doc, err := parsePolicy(existing)
+	if err != nil {
+		return "", err
+	}
+
+	replaced := false
+	var statements []map[string]interface{}
+	for _, statement := range doc.Statement {
+		statementSid, _ := statement["Sid"].(string)
+
+		if doc.ID == policyID && statementSid == legacyPolicySid {
+			continue
+		}
+
+		if statementSid != sid && containsString(oldSids, statementSid) {
+			continue
+		}
+
+		if statementSid == sid {
+			if len(topicARNs) > 0 && !replaced {
+				statements = append(statements, policyStatement(sid, queueARN, topicARNs))
+				replaced = true
+			}
+			continue
+		}
+
+		statements = append(statements, statement)
+	}
+
+	if len(topicARNs) > 0 && !replaced {
+		statements = append(statements, policyStatement(sid, queueARN, topicARNs))
+	}
+
+	if len(statements) == 0 {
+		return "", nil
+	}
+
+	doc.Statement = statements
+	if doc.Version == "" {
+		doc.Version = policyVersion
+	}
+	if doc.ID == "" {
+		doc.ID = policyID
+	}
+
+	bs, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bs), nil
+}
+
+// policyTopicARNs are the topics the statement with the sid lets send to the queue.
+func policyTopicARNs(policy, sid string) ([]string, error) {
+	doc, err := parsePolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	var arns []string
+	for _, statement := range doc.Statement {
+		if statementSid, _ := statement["Sid"].(string); statementSid != sid {
+			continue
+		}
+
+		condition, _ := statement["Condition"].(map[string]interface{})
+		arnEquals, _ := condition["ArnEquals"].(map[string]interface{})
+
+		switch sourceARNs := arnEquals["aws:SourceArn"].(type) {
+		case string:
+			arns = append(arns, sourceARNs)
+		case []interface{}:
+			for _, arn := range sourceARNs {
+				if s, ok := arn.(string); ok && !containsString(arns, s) {
+					arns = append(arns, s)
+				}
+			}
+		}
+	}
+	sort.Strings(arns)
+
+	return arns, nil
+}
@@ -0,0 +1,182 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const otherStatement = `{"Sid": "other", "Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::123456789012:root"}, "Action": "sqs:ReceiveMessage", "Resource": "arn:queue"}`
+
+func TestMergePolicyKeepsOtherStatements(t *testing.T) {
+	existing := `{"Version": "2012-10-17", "Statement": [` + otherStatement + `, {"Sid": "grim-b", "Condition": {"ArnEquals": {"aws:SourceArn": ["arn:b"]}}}]}`
+
+	policy, err := mergePolicy(existing, "grim-a", nil, "arn:queue", []string{"arn:a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := parsePolicy(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sids []string
+	for _, statement := range doc.Statement {
+		sids = append(sids, statement["Sid"].(string))
+	}
+
+	if !reflect.DeepEqual(sids, []string{"other", "grim-b", "grim-a"}) {
+		t.Errorf("expected the other statements to be kept but got %v", sids)
+	}
+
+	if action := doc.Statement[0]["Action"]; action != "sqs:ReceiveMessage" {
+		t.Errorf("another statement was changed: %v", doc.Statement[0])
+	}
+
+	if arns, _ := policyTopicARNs(policy, "grim-b"); !reflect.DeepEqual(arns, []string{"arn:b"}) {
+		t.Errorf("another server's topics were changed: %v", arns)
+	}
+}
+
+func TestMergePolicyStatement(t *testing.T) {
+	policy, err := mergePolicy("", "grim-a", nil, "arn:queue", []string{"arn:b", "arn:a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Statement []struct {
+			Sid       string
+			Effect    string
+			Principal map[string]string
+			Action    string
+			Resource  string
+		}
+	}
+
+	if err := json.Unmarshal([]byte(policy), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doc.Statement) != 1 {
+		t.Fatalf("expected one statement but got %v", policy)
+	}
+
+	statement := doc.Statement[0]
+	if statement.Sid != "grim-a" || statement.Effect != "Allow" || statement.Principal["Service"] != "sns.amazonaws.com" || statement.Action != "sqs:SendMessage" || statement.Resource != "arn:queue" {
+		t.Errorf("statement grants more than sending from SNS: %v", policy)
+	}
+
+	if arns, _ := policyTopicARNs(policy, "grim-a"); !reflect.DeepEqual(arns, []string{"arn:a", "arn:b"}) {
+		t.Errorf("expected both topics but got %v", arns)
+	}
+}
+
+func TestMergePolicyReplacesAndRemoves(t *testing.T) {
+	policy, err := mergePolicy("", "grim-a", nil, "arn:queue", []string{"arn:a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err = mergePolicy(policy, "grim-a", nil, "arn:queue", []string{"arn:c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if arns, _ := policyTopicARNs(policy, "grim-a"); !reflect.DeepEqual(arns, []string{"arn:c"}) {
+		t.Errorf("expected the statement to be replaced but got %v", arns)
+	}
+
+	if policy, err = mergePolicy(policy, "grim-a", nil, "arn:queue", nil); err != nil || policy != "" {
+		t.Errorf("expected the policy to be removed but got %q %v", policy, err)
+	}
+
+	withOther := `{"Statement": ` + otherStatement + `}`
+	if policy, err = mergePolicy(withOther, "grim-a", nil, "arn:queue", nil); err != nil {
+		t.Fatal(err)
+	} else if doc, _ := parsePolicy(policy); len(doc.Statement) != 1 || doc.Statement[0]["Sid"] != "other" {
+		t.Errorf("expected only the other statement to be left but got %v", policy)
+	}
+}
+
+func TestMergePolicyRemovesLegacyStatement(t *testing.T) {
+	legacy := `{"Version": "2008-10-17", "Id": "grim-policy", "Statement": [{"Sid": "1", "Effect": "Allow", "Principal": {"AWS": "*"}, "Action": "SQS:*", "Resource": "arn:queue", "Condition": {"ArnEquals": {"aws:SourceArn": ["arn:a"]}}}]}`
+
+	policy, err := mergePolicy(legacy, "grim-a", nil, "arn:queue", []string{"arn:a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := parsePolicy(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doc.Statement) != 1 || doc.Statement[0]["Sid"] != "grim-a" {
+		t.Errorf("expected the legacy statement to be replaced but got %v", policy)
+	}
+
+	if arns, _ := policyTopicARNs(legacy, "grim-a"); arns != nil {
+		t.Errorf("the legacy statement isn't grim's current one: %v", arns)
+	}
+}
+
+func TestMergePolicyRemovesTruncatedSidStatement(t *testing.T) {
+	config := testGlobalConfig(configMap{"GrimServerID": "grim-production-east"})
+	truncated := `{"Version": "2012-10-17", "Id": "grim-policy", "Statement": [{"Sid": "grim-grim-production", "Effect": "Allow", "Principal": {"Service": "sns.amazonaws.com"}, "Action": "sqs:SendMessage", "Resource": "arn:queue", "Condition": {"ArnEquals": {"aws:SourceArn": ["arn:a"]}}}, {"Sid": "other"}]}`
+
+	if !reflect.DeepEqual(oldPolicySids(config), []string{"grim-grim-production"}) {
+		t.Fatalf("expected the truncated sid to be old but got %v", oldPolicySids(config))
+	}
+
+	policy, err := mergePolicy(truncated, policySid(config), oldPolicySids(config), "arn:queue", []string{"arn:a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := parsePolicy(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doc.Statement) != 2 || doc.Statement[0]["Sid"] != "other" || doc.Statement[1]["Sid"] != "grim-grim-production-east" {
+		t.Errorf("expected the truncated sid statement to be replaced but got %v", policy)
+	}
+
+	if sids := oldPolicySids(testGlobalConfig(configMap{"GrimServerID": "grim-short"})); sids != nil {
+		t.Errorf("a server id that isn't truncated has no old sids: %v", sids)
+	}
+}
+
+func TestPolicySidUsesWholeServerID(t *testing.T) {
+	first := testGlobalConfig(configMap{"GrimServerID": "grim-production-east"})
+	second := testGlobalConfig(configMap{"GrimServerID": "grim-production-west"})
+
+	if first.grimServerID() != second.grimServerID() {
+		t.Fatalf("expected the server ids to be truncated to the same id")
+	}
+
+	if policySid(first) == policySid(second) {
+		t.Errorf("servers with different ids share the policy statement %q", policySid(first))
+	}
+}
+
+func TestPolicyTopicARNs(t *testing.T) {
+	policy := `{"Statement": {"Sid": "grim-a", "Condition": {"ArnEquals": {"aws:SourceArn": "arn:a"}}}}`
+	if arns, err := policyTopicARNs(policy, "grim-a"); err != nil || !reflect.DeepEqual(arns, []string{"arn:a"}) {
+		t.Errorf("expected a single topic but got %v %v", arns, err)
+	}
+
+	if arns, err := policyTopicARNs("", "grim-a"); err != nil || arns != nil {
+		t.Errorf("expected no topics without a policy but got %v %v", arns, err)
+	}
+
+	if _, err := policyTopicARNs("{", "grim-a"); err == nil {
+		t.Errorf("expected an error for a policy that isn't JSON")
+	}
+}
@@ -0,0 +1,86 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// grim re-executes itself through /proc/self/exe to do the things that have to happen
+// in a build's own process before it starts, since go can't run code between fork and
+// exec.  It works even when grim's binary is hidden by a sandbox.
+const selfExe = "/proc/self/exe"
+
+// rlimitExecArg is the first argument grim re-executes itself with to set rlimits on
+// itself before exec'ing a build.
+const rlimitExecArg = "__grim_rlimit_exec"
+
+// reexecFailed is the exit code of a re-executed grim that could not start the build.
+const reexecFailed = 125
+
+// MaybeReexec finishes starting a build's process when grim has re-executed the program
+// to set up the build's sandbox or rlimits, and doesn't return if it has.  A program that
+// runs builds with a Sandbox or Limits must call it before anything else in main.
+func MaybeReexec() {
+	if len(os.Args) < 3 {
+		return
+	}
+
+	switch os.Args[1] {
+	case sandboxInitArg:
+		os.Exit(sandboxInit(os.Args[2], os.Args[3:]))
+	case rlimitExecArg:
+		os.Exit(rlimitExec(os.Args[2], os.Args[3:]))
+	}
+}
+
+// wrapRlimits changes cmd to start grim which sets the rlimits then becomes the command.
+func wrapRlimits(cmd *exec.Cmd, rlimits []rlimit) error {
+	if len(rlimits) == 0 {
+		return nil
+	}
+
+	bs, err := json.Marshal(rlimits)
+	if err != nil {
+		return err
+	}
+
+	cmd.Args = append([]string{selfExe, rlimitExecArg, string(bs), cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = selfExe
+
+	return nil
+}
+
+// rlimitExec only returns if it fails to exec the command.
+func rlimitExec(rlimitsJSON string, command []string) int {
+	var rlimits []rlimit
+	err := json.Unmarshal([]byte(rlimitsJSON), &rlimits)
+	if err == nil && len(command) == 0 {
+		err = fmt.Errorf("no command to run")
+	}
+
+	var path string
+	if err == nil {
+		path, err = exec.LookPath(command[0])
+	}
+
+	for _, limit := range rlimits {
+		if err != nil {
+			break
+		}
+		err = syscall.Setrlimit(limit.Resource, &syscall.Rlimit{Cur: limit.Cur, Max: limit.Max})
+	}
+
+	if err == nil {
+		err = syscall.Exec(path, command, os.Environ())
+	}
+
+	fmt.Fprintf(os.Stderr, "grim: %v\n", err)
+	return reexecFailed
+}
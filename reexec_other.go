@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// MaybeReexec does nothing where grim doesn't re-execute itself to start builds.
+func MaybeReexec() {}
@@ -0,0 +1,473 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// provisioner sets up and tears down what delivers a repo's GitHub events to grim's queue.
+type provisioner interface {
+	// provision creates the repo's SNS topic, subscribes the queue to it and points the
+	// repo's AmazonSNS hook at it, returning the topic's ARN.
+	provision(config localConfig, queue *sqsQueue) (string, error)
+	removeHook(config localConfig) error
+	unsubscribe(config globalConfig, topicARN string, queue *sqsQueue) error
+	// setPolicy lets the topics send to the queue, removing grim's statement from its
+	// policy if there are none.
+	setPolicy(config globalConfig, queue *sqsQueue, topicARNs []string) error
+
+	// findQueue is grim's queue, or nil if it doesn't exist.
+	findQueue(config globalConfig) (*sqsQueue, error)
+	// findTopic is the ARN of the repo's SNS topic, or "" if it doesn't exist.
+	findTopic(config localConfig) (string, error)
+	hasHook(config localConfig) (bool, error)
+	// subscribers are the endpoints subscribed to the topic, grim's and any others.
+	subscribers(config globalConfig, topicARN string) ([]string, error)
+	deleteTopic(config globalConfig, topicARN string) error
+
+	// createTopic, subscribe and setHook are the steps of provision.
+	createTopic(config localConfig) (string, error)
+	subscribe(config globalConfig, topicARN string, queue *sqsQueue) error
+	setHook(config localConfig, topicARN string) error
+	// hookDrift names what differs between the repo's AmazonSNS hook and the one that
+	// would send to the topic, or is nil if nothing does.
+	hookDrift(config localConfig, topicARN string) ([]string, error)
+	// policyTopics are the topics the queue's policy lets send to it.
+	policyTopics(config globalConfig, queue *sqsQueue) ([]string, error)
+}
+
+type awsProvisioner struct{}
+
+func (p awsProvisioner) provision(config localConfig, queue *sqsQueue) (string, error) {
+	if err := checkConfiguredTopic(config, queue); err != nil {
+		return "", err
+	}
+
+	topicARN, err := p.createTopic(config)
+	if err != nil {
+		return "", err
+	}
+
+	if err := p.subscribe(config.global, topicARN, queue); err != nil {
+		return "", err
+	}
+
+	if err := p.setHook(config, topicARN); err != nil {
+		return "", err
+	}
+
+	return topicARN, nil
+}
+
+func (p awsProvisioner) createTopic(config localConfig) (string, error) {
+	if topicARN := config.snsTopicARN(); topicARN != "" {
+		// topics given by their ARN are used as they are
+		if existing, err := p.findTopic(config); err != nil || existing != "" {
+			return existing, err
+		}
+
+		return "", fmt.Errorf("SNS topic %v of %v/%v doesn't exist", topicARN, config.owner, config.repo)
+	}
+
+	topicARN, err := prepareSNSTopic(config.global.awsConfig(), config.snsTopicName())
+	if err != nil {
+		return "", fmt.Errorf("error creating SNS Topic %s for %s/%s topic: %v", config.snsTopicName(), config.owner, config.repo, err)
+	}
+
+	return topicARN, nil
+}
+
+func (awsProvisioner) subscribe(config globalConfig, topicARN string, queue *sqsQueue) error {
+	if err := prepareSubscription(config.awsConfig(), topicARN, queue.ARN); err != nil {
+		return fmt.Errorf("error subscribing Grim queue %q to SNS topic %q: %v", queue.ARN, topicARN, err)
+	}
+
+	return nil
+}
+
+func (awsProvisioner) setHook(config localConfig, topicARN string) error {
+	global := config.global
+
+	awsKey, awsSecret, err := getHookCredentials(global.awsConfig())
+	if err != nil {
+		return fmt.Errorf("error getting credentials for GitHub AmazonSNS service: %v", err)
+	}
+
+	err = prepareAmazonSNSService(config.gitHubToken(), config.owner, config.repo, topicARN, awsKey, awsSecret, global.awsRegion())
+	if err != nil {
+		return fmt.Errorf("error creating configuring GitHub AmazonSNS service: %v", err)
+	}
+
+	return nil
+}
+
+func (awsProvisioner) hookDrift(config localConfig, topicARN string) ([]string, error) {
+	global := config.global
+
+	hook, err := getAmazonSNSService(config.gitHubToken(), config.owner, config.repo)
+	if err != nil {
+		return nil, fmt.Errorf("error finding GitHub AmazonSNS service of %v/%v: %v", config.owner, config.repo, err)
+	}
+
+	awsKey, awsSecret, err := getHookCredentials(global.awsConfig())
+	if err != nil {
+		return nil, fmt.Errorf("error getting credentials for GitHub AmazonSNS service: %v", err)
+	}
+
+	return amazonSNSHookDrift(hook, githubAmazonSNSHookStruct(topicARN, awsKey, awsSecret, global.awsRegion())), nil
+}
+
+func (awsProvisioner) policyTopics(config globalConfig, queue *sqsQueue) ([]string, error) {
+	policy, err := getQueuePolicy(config.awsConfig(), queue.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error getting policy of Grim queue %q: %v", queue.ARN, err)
+	}
+
+	return policyTopicARNs(policy, policySid(config))
+}
+
+func (awsProvisioner) removeHook(config localConfig) error {
+	if err := removeAmazonSNSService(config.gitHubToken(), config.owner, config.repo); err != nil {
+		return fmt.Errorf("error removing GitHub AmazonSNS service from %s/%s: %v", config.owner, config.repo, err)
+	}
+
+	return nil
+}
+
+func (awsProvisioner) unsubscribe(config globalConfig, topicARN string, queue *sqsQueue) error {
+	if err := removeSubscription(config.awsConfig(), topicARN, queue.ARN); err != nil {
+		return fmt.Errorf("error unsubscribing Grim queue %q from SNS topic %q: %v", queue.ARN, topicARN, err)
+	}
+
+	return nil
+}
+
+func (awsProvisioner) setPolicy(config globalConfig, queue *sqsQueue, topicARNs []string) error {
+	if err := setPolicy(config.awsConfig(), policySid(config), oldPolicySids(config), queue.ARN, queue.URL, topicARNs); err != nil {
+		return fmt.Errorf("error setting policy for Grim queue %q with topics %v: %v", queue.ARN, topicARNs, err)
+	}
+
+	return nil
+}
+
+func (awsProvisioner) findQueue(config globalConfig) (*sqsQueue, error) {
+	queue, err := lookupSQSQueue(config.awsConfig(), config.grimQueueName())
+	if err != nil {
+		return nil, fmt.Errorf("error finding Grim queue %q: %v", config.grimQueueName(), err)
+	}
+
+	return queue, nil
+}
+
+func (awsProvisioner) findTopic(config localConfig) (string, error) {
+	if topicARN := config.snsTopicARN(); topicARN != "" {
+		exists, err := snsTopicExists(config.global.awsConfig(), topicARN)
+		if err != nil {
+			return "", fmt.Errorf("error finding SNS topic %v for %v/%v: %v", topicARN, config.owner, config.repo, err)
+		} else if !exists {
+			return "", nil
+		}
+
+		return topicARN, nil
+	}
+
+	topicARN, err := lookupSNSTopic(config.global.awsConfig(), config.snsTopicName())
+	if err != nil {
+		return "", fmt.Errorf("error finding SNS topic %v for %v/%v: %v", config.snsTopicName(), config.owner, config.repo, err)
+	}
+
+	return topicARN, nil
+}
+
+func (awsProvisioner) hasHook(config localConfig) (bool, error) {
+	ok, err := hasAmazonSNSService(config.gitHubToken(), config.owner, config.repo)
+	if err != nil {
+		return false, fmt.Errorf("error finding GitHub AmazonSNS service of %v/%v: %v", config.owner, config.repo, err)
+	}
+
+	return ok, nil
+}
+
+func (awsProvisioner) subscribers(config globalConfig, topicARN string) ([]string, error) {
+	endpoints, err := getSubscriberEndpoints(config.awsConfig(), topicARN)
+	if err != nil {
+		return nil, fmt.Errorf("error listing subscriptions to SNS topic %q: %v", topicARN, err)
+	}
+
+	return endpoints, nil
+}
+
+func (awsProvisioner) deleteTopic(config globalConfig, topicARN string) error {
+	if err := deleteSNSTopic(config.awsConfig(), topicARN); err != nil {
+		return fmt.Errorf("error deleting SNS topic %q: %v", topicARN, err)
+	}
+
+	return nil
+}
+
+// provisionedRepo is the config a repo was provisioned with, which is kept so it can be
+// told apart from the current one and used to tear the repo down.
+type provisionedRepo struct {
+	config   localConfig
+	topicARN string
+}
+
+func (i *Instance) getProvisioner() provisioner {
+	if i.provisioner == nil {
+		return awsProvisioner{}
+	}
+
+	return i.provisioner
+}
+
+// ConfigPollInterval is how often grimd should call ReloadRepos, or 0 if it should only
+// reload when asked to.
+func (i *Instance) ConfigPollInterval() time.Duration {
+	config, err := readGlobalConfig(getEffectiveConfigRoot(i.configRoot))
+	if err != nil {
+		return defaultConfigPollInterval
+	}
+
+	return config.configPollInterval()
+}
+
+// ReloadRepos rereads the configuration root, provisions repos that have been added,
+// removes the hooks of those that have gone and logs what changed along with the problems
+// of the configs that changed.  A repo whose config can't be read is left as it was, so
+// that a half written config.json doesn't unhook it.  It is meant for when grimd is asked
+// to reload, eg. on SIGHUP; PollRepos is for rereading the configuration root regularly.
+// It is an error to call this without calling PrepareRepos first.
+func (i *Instance) ReloadRepos(logger *log.Logger) error {
+	return i.reloadRepos(logger, 1)
+}
+
+// PollRepos is ReloadRepos for when nobody asked for a reload, so a repo is only removed
+// once its directory has been missing from two polls in a row.  A directory that is being
+// replaced, eg. by a deploy of the configuration root, doesn't unhook its repo.
+func (i *Instance) PollRepos(logger *log.Logger) error {
+	return i.reloadRepos(logger, 2)
+}
+
+// reloadRepos removes the repos whose directory has been missing from missingReloads
+// reloads in a row.
+func (i *Instance) reloadRepos(logger *log.Logger, missingReloads int) error {
+	if err := i.checkGrimQueue(); err != nil {
+		return err
+	}
+
+	configRoot := getEffectiveConfigRoot(i.configRoot)
+
+	global, err := readGlobalConfig(configRoot)
+	if err != nil {
+		return grimErrorf("error while reading config: %v", err).withKind(ConfigError)
+	}
+
+	if changes := configChanges(i.global.raw, global.raw); len(changes) > 0 {
+		logger.Printf("reloaded global config: %v", strings.Join(changes, ", "))
+
+		problems := &configProblems{}
+		validateGlobal(problems, configRoot, global)
+		problems.log(logger)
+	}
+
+	var readable []repo
+	unreadable := make(map[repo]string)
+	current := make(map[repo]localConfig)
+	for _, r := range getAllConfiguredRepos(configRoot) {
+		config, err := readLocalConfig(configRoot, r.owner, r.name)
+		if err != nil {
+			if i.unreadable[r] != err.Error() {
+				logger.Printf("not reloading %v/%v: %v", r.owner, r.name, err)
+			}
+			unreadable[r] = err.Error()
+			continue
+		}
+
+		readable = append(readable, r)
+		current[r] = config
+	}
+
+	p := i.getProvisioner()
+	provisioned := make(map[repo]provisionedRepo)
+	var readableConfigs, unprovisioned []localConfig
+
+	for _, r := range readable {
+		config := current[r]
+		readableConfigs = append(readableConfigs, config)
+
+		previous, ok := i.provisioned[r]
+		changes := configChanges(previous.config.local, config.local)
+		if !ok {
+			logger.Printf("added repo %v/%v", r.owner, r.name)
+		} else if len(changes) > 0 {
+			logger.Printf("reloaded %v/%v config: %v", r.owner, r.name, strings.Join(changes, ", "))
+		}
+
+		if !ok || len(changes) > 0 {
+			problems := &configProblems{}
+			validateRepo(problems, configRoot, r, config, nil)
+			problems.log(logger)
+		}
+
+		if ok && provisioningKey(previous.config) == provisioningKey(config) {
+			provisioned[r] = provisionedRepo{config, previous.topicARN}
+			continue
+		}
+
+		topicARN, err := p.provision(config, i.queue)
+		if err != nil {
+			// it is tried again on the next reload
+			logger.Printf("error provisioning %v/%v: %v", r.owner, r.name, err)
+			if ok {
+				provisioned[r] = previous
+			} else {
+				unprovisioned = append(unprovisioned, config)
+			}
+			continue
+		}
+
+		provisioned[r] = provisionedRepo{config, topicARN}
+	}
+
+	missing := make(map[repo]int)
+	for _, r := range sortedRepos(i.provisioned) {
+		previous := i.provisioned[r]
+		if _, ok := provisioned[r]; ok {
+			continue
+		}
+
+		if _, ok := unreadable[r]; ok {
+			provisioned[r] = previous
+			continue
+		}
+
+		if missing[r] = i.missing[r] + 1; missing[r] < missingReloads {
+			logger.Printf("repo %v/%v is missing; it is removed if it is still missing on the next poll", r.owner, r.name)
+			provisioned[r] = previous
+			continue
+		}
+
+		logger.Printf("removed repo %v/%v", r.owner, r.name)
+		if err := p.removeHook(previous.config); err != nil {
+			logger.Print(err)
+		}
+	}
+
+	// repos that have never been provisioned keep the topics PrepareRepos found in the policy
+	anyUnreadable := false
+	for r := range unreadable {
+		if _, ok := provisioned[r]; !ok {
+			anyUnreadable = true
+		}
+	}
+	kept := keptPolicyTopics(i.keptTopics, readableConfigs, unprovisioned, anyUnreadable)
+
+	oldTopics := mergeTopics(topicARNs(i.provisioned), i.keptTopics)
+	newTopics := mergeTopics(topicARNs(provisioned), kept)
+
+	if !reflect.DeepEqual(oldTopics, newTopics) {
+		if err := p.setPolicy(global, i.queue, newTopics); err != nil {
+			logger.Print(err)
+		}
+
+		for _, topicARN := range oldTopics {
+			if !containsString(newTopics, topicARN) {
+				if err := p.unsubscribe(global, topicARN, i.queue); err != nil {
+					logger.Print(err)
+				}
+			}
+		}
+	}
+
+	i.global = global
+	i.provisioned = provisioned
+	i.unreadable = unreadable
+	i.keptTopics = kept
+	i.missing = missing
+
+	return nil
+}
+
+// provisioningKey is everything a repo's SNS topic, subscription and hook depend on.
+func provisioningKey(config localConfig) string {
+	return fmt.Sprintf("%q %q %q %#v", config.snsTopicName(), config.snsTopicARN(), config.gitHubToken(), config.global.awsConfig())
+}
+
+// configChanges names the keys that were added, removed or changed without their values,
+// which may be secret.
+func configChanges(old, new configMap) []string {
+	var changes []string
+
+	for _, key := range sortedKeys(new) {
+		if oldVal, ok := old[key]; !ok {
+			changes = append(changes, "added "+key)
+		} else if !reflect.DeepEqual(oldVal, new[key]) {
+			changes = append(changes, "changed "+key)
+		}
+	}
+
+	for _, key := range sortedKeys(old) {
+		if _, ok := new[key]; !ok {
+			changes = append(changes, "removed "+key)
+		}
+	}
+
+	return changes
+}
+
+func sortedKeys(config configMap) []string {
+	var keys []string
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func sortedRepos(provisioned map[repo]provisionedRepo) []repo {
+	var repos []repo
+	for r := range provisioned {
+		repos = append(repos, r)
+	}
+
+	sort.Slice(repos, func(a, b int) bool {
+		if repos[a].owner != repos[b].owner {
+			return repos[a].owner < repos[b].owner
+		}
+		return repos[a].name < repos[b].name
+	})
+
+	return repos
+}
+
+// topicARNs are the distinct topics of the provisioned repos, sorted so they can be compared.
+func topicARNs(provisioned map[repo]provisionedRepo) []string {
+	var arns []string
+	for _, p := range provisioned {
+		if !containsString(arns, p.topicARN) {
+			arns = append(arns, p.topicARN)
+		}
+	}
+	sort.Strings(arns)
+
+	return arns
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,316 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testProvisioner struct {
+	calls  []string
+	policy []string
+	fail   map[string]bool
+
+	// what the lookups find, by default every topic, hook and queue subscribed to its topic
+	missingTopics map[string]bool
+	missingHooks  map[string]bool
+	subscriptions map[string][]string
+	hookDrifts    map[string][]string
+	currentPolicy []string
+}
+
+func (p *testProvisioner) provision(config localConfig, queue *sqsQueue) (string, error) {
+	p.calls = append(p.calls, fmt.Sprintf("provision %v/%v", config.owner, config.repo))
+	if p.fail[config.repo] {
+		return "", fmt.Errorf("provisioning failed")
+	}
+
+	return "arn:" + config.snsTopicName(), nil
+}
+
+func (p *testProvisioner) removeHook(config localConfig) error {
+	p.calls = append(p.calls, fmt.Sprintf("remove hook %v/%v", config.owner, config.repo))
+	return nil
+}
+
+func (p *testProvisioner) unsubscribe(config globalConfig, topicARN string, queue *sqsQueue) error {
+	p.calls = append(p.calls, "unsubscribe "+topicARN)
+	return nil
+}
+
+func (p *testProvisioner) setPolicy(config globalConfig, queue *sqsQueue, topicARNs []string) error {
+	p.calls = append(p.calls, "set policy")
+	p.policy = topicARNs
+	return nil
+}
+
+func (p *testProvisioner) findQueue(config globalConfig) (*sqsQueue, error) {
+	return &sqsQueue{URL: "https://queue", ARN: "arn:queue"}, nil
+}
+
+func (p *testProvisioner) findTopic(config localConfig) (string, error) {
+	if p.missingTopics[config.snsTopicName()] {
+		return "", nil
+	} else if topicARN := config.snsTopicARN(); topicARN != "" {
+		return topicARN, nil
+	}
+
+	return "arn:" + config.snsTopicName(), nil
+}
+
+func (p *testProvisioner) hasHook(config localConfig) (bool, error) {
+	return !p.missingHooks[config.repo], nil
+}
+
+func (p *testProvisioner) subscribers(config globalConfig, topicARN string) ([]string, error) {
+	if subscribers, ok := p.subscriptions[topicARN]; ok {
+		return subscribers, nil
+	}
+
+	return []string{"arn:queue"}, nil
+}
+
+func (p *testProvisioner) deleteTopic(config globalConfig, topicARN string) error {
+	p.calls = append(p.calls, "delete topic "+topicARN)
+	return nil
+}
+
+func (p *testProvisioner) createTopic(config localConfig) (string, error) {
+	p.calls = append(p.calls, "create topic "+config.snsTopicName())
+	if p.fail[config.repo] {
+		return "", fmt.Errorf("creating topic failed")
+	}
+
+	return "arn:" + config.snsTopicName(), nil
+}
+
+func (p *testProvisioner) subscribe(config globalConfig, topicARN string, queue *sqsQueue) error {
+	p.calls = append(p.calls, "subscribe "+topicARN)
+	return nil
+}
+
+func (p *testProvisioner) setHook(config localConfig, topicARN string) error {
+	p.calls = append(p.calls, fmt.Sprintf("set hook %v/%v", config.owner, config.repo))
+	return nil
+}
+
+func (p *testProvisioner) hookDrift(config localConfig, topicARN string) ([]string, error) {
+	return p.hookDrifts[config.repo], nil
+}
+
+func (p *testProvisioner) policyTopics(config globalConfig, queue *sqsQueue) ([]string, error) {
+	return p.currentPolicy, nil
+}
+
+func TestReloadRepos(t *testing.T) {
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, configFileName, `{"GitHubToken": "token"}`)
+		writeConfig(t, path, "MediaMath/kept/config.json", `{}`)
+		writeConfig(t, path, "MediaMath/edited/config.json", `{}`)
+		writeConfig(t, path, "MediaMath/moved/config.json", `{"SNSTopicName": "old-topic"}`)
+		writeConfig(t, path, "MediaMath/removed/config.json", `{}`)
+		writeConfig(t, path, "MediaMath/broken/config.json", `{}`)
+
+		p := &testProvisioner{}
+		i := testInstance(path, p)
+
+		if err := i.PrepareRepos(log.New(&bytes.Buffer{}, "", 0)); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(p.calls, []string{"set policy"}) || len(p.policy) != 5 {
+			t.Fatalf("expected every repo to be provisioned: %v %v", p.calls, p.policy)
+		}
+
+		writeConfig(t, path, configFileName, `{"GitHubToken": "token", "Timeout": 600}`)
+		writeConfig(t, path, "MediaMath/edited/config.json", `{"HipChatRoom": "builds"}`)
+		writeConfig(t, path, "MediaMath/moved/config.json", `{"SNSTopicName": "new-topic"}`)
+		writeConfig(t, path, "MediaMath/broken/config.json", `{"Timeout": `)
+		writeConfig(t, path, "MediaMath/added/config.json", `{}`)
+		os.RemoveAll(filepath.Join(path, "MediaMath", "removed"))
+
+		p.calls = nil
+		var buf bytes.Buffer
+		if err := i.ReloadRepos(log.New(&buf, "", 0)); err != nil {
+			t.Fatal(err)
+		}
+
+		expectedCalls := []string{
+			"provision MediaMath/added",
+			"provision MediaMath/moved",
+			"remove hook MediaMath/removed",
+			"set policy",
+			"unsubscribe arn:grim-MediaMath-removed-repo-topic",
+			"unsubscribe arn:old-topic",
+		}
+
+		if !reflect.DeepEqual(p.calls, expectedCalls) {
+			t.Errorf("expected %v but got %v", expectedCalls, p.calls)
+		}
+
+		if containsString(p.policy, "arn:old-topic") || !containsString(p.policy, "arn:new-topic") || !containsString(p.policy, "arn:grim-MediaMath-broken-repo-topic") {
+			t.Errorf("queue policy is not for the reloaded topics: %v", p.policy)
+		}
+
+		logged := buf.String()
+		for _, expected := range []string{
+			"reloaded global config: added Timeout",
+			"not reloading MediaMath/broken",
+			"added repo MediaMath/added",
+			"reloaded MediaMath/edited config: added HipChatRoom",
+			"reloaded MediaMath/moved config: changed SNSTopicName",
+			"removed repo MediaMath/removed",
+		} {
+			if !strings.Contains(logged, expected) {
+				t.Errorf("expected %q to be logged: %v", expected, logged)
+			}
+		}
+
+		if strings.Contains(logged, "MediaMath/kept") {
+			t.Errorf("an unchanged repo was logged: %v", logged)
+		}
+
+		p.calls = nil
+		buf.Reset()
+		if err := i.ReloadRepos(log.New(&buf, "", 0)); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(p.calls) != 0 || buf.Len() != 0 {
+			t.Errorf("nothing changed but got %v: %v", p.calls, buf.String())
+		}
+	})
+}
+
+func TestReloadRetriesFailedRepos(t *testing.T) {
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, configFileName, `{}`)
+
+		p := &testProvisioner{fail: map[string]bool{"flaky": true}}
+		i := testInstance(path, p)
+
+		if err := i.PrepareRepos(log.New(&bytes.Buffer{}, "", 0)); err != nil {
+			t.Fatal(err)
+		}
+
+		writeConfig(t, path, "MediaMath/flaky/config.json", `{}`)
+
+		var buf bytes.Buffer
+		i.ReloadRepos(log.New(&buf, "", 0))
+		if !strings.Contains(buf.String(), "error provisioning MediaMath/flaky: provisioning failed") {
+			t.Errorf("failure was not logged: %v", buf.String())
+		}
+
+		p.fail = nil
+		p.calls = nil
+		i.ReloadRepos(log.New(&buf, "", 0))
+
+		if !reflect.DeepEqual(p.calls, []string{"provision MediaMath/flaky", "set policy"}) {
+			t.Errorf("failed repo was not provisioned again: %v", p.calls)
+		}
+	})
+}
+
+func TestPollRemovesReposMissingTwice(t *testing.T) {
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, configFileName, `{}`)
+		writeConfig(t, path, "MediaMath/replaced/config.json", `{}`)
+		writeConfig(t, path, "MediaMath/removed/config.json", `{}`)
+
+		p := &testProvisioner{}
+		i := testInstance(path, p)
+
+		if err := i.PrepareRepos(log.New(&bytes.Buffer{}, "", 0)); err != nil {
+			t.Fatal(err)
+		}
+
+		os.RemoveAll(filepath.Join(path, "MediaMath", "replaced"))
+		os.RemoveAll(filepath.Join(path, "MediaMath", "removed"))
+
+		p.calls = nil
+		var buf bytes.Buffer
+		i.PollRepos(log.New(&buf, "", 0))
+
+		if len(p.calls) != 0 || !strings.Contains(buf.String(), "repo MediaMath/removed is missing") {
+			t.Errorf("a repo missing from one poll was removed: %v %v", p.calls, buf.String())
+		}
+
+		writeConfig(t, path, "MediaMath/replaced/config.json", `{}`)
+		i.PollRepos(log.New(&buf, "", 0))
+
+		expectedCalls := []string{"remove hook MediaMath/removed", "set policy", "unsubscribe arn:grim-MediaMath-removed-repo-topic"}
+		if !reflect.DeepEqual(p.calls, expectedCalls) {
+			t.Errorf("expected %v but got %v", expectedCalls, p.calls)
+		}
+	})
+}
+
+func TestReloadValidatesChangedRepos(t *testing.T) {
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, configFileName, `{}`)
+		writeConfig(t, path, "MediaMath/unchanged/config.json", `{"ErrorColor": "orange"}`)
+
+		i := testInstance(path, &testProvisioner{})
+		if err := i.PrepareRepos(log.New(&bytes.Buffer{}, "", 0)); err != nil {
+			t.Fatal(err)
+		}
+
+		writeConfig(t, path, "MediaMath/added/config.json", `{"FailureColor": "orange"}`)
+
+		var buf bytes.Buffer
+		i.ReloadRepos(log.New(&buf, "", 0))
+
+		if logged := buf.String(); !strings.Contains(logged, `error: `+filepath.Join(path, "MediaMath/added/config.json")+`: FailureColor "orange"`) {
+			t.Errorf("the added repo's problems weren't logged: %v", logged)
+		} else if strings.Contains(logged, "ErrorColor") {
+			t.Errorf("an unchanged repo was validated again: %v", logged)
+		}
+	})
+}
+
+func TestReloadWithoutPreparing(t *testing.T) {
+	var i Instance
+	if err := i.ReloadRepos(log.New(&bytes.Buffer{}, "", 0)); !IsFatal(err) {
+		t.Errorf("expected a fatal error but got %v", err)
+	}
+}
+
+func TestConfigPollInterval(t *testing.T) {
+	cases := map[string]time.Duration{
+		`{}`:                         defaultConfigPollInterval,
+		`{"ConfigPollInterval": 5}`:  5 * time.Second,
+		`{"ConfigPollInterval": -1}`: 0,
+	}
+
+	for config, expected := range cases {
+		withTempDir(t, func(path string) {
+			writeConfig(t, path, configFileName, config)
+
+			var i Instance
+			i.SetConfigRoot(path)
+
+			if actual := i.ConfigPollInterval(); actual != expected {
+				t.Errorf("%v: expected %v but got %v", config, expected, actual)
+			}
+		})
+	}
+}
+
+func testInstance(configRoot string, p provisioner) *Instance {
+	i := &Instance{}
+	i.SetConfigRoot(configRoot)
+	i.queue = &sqsQueue{URL: "https://queue", ARN: "arn:queue"}
+	i.provisioner = p
+
+	return i
+}
@@ -0,0 +1,178 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// repoConfigKeys is the allowlist of keys that a repo may set in its own .grim.yml or
+// .grim.json, mapped to the config.json key they stand in for.  Anything else, in
+// particular tokens and AWS settings, can only be set on the grim server.
+var repoConfigKeys = map[string]string{
+	"timeout":                       "Timeout",
+	"on_timeout":                    "OnTimeout",
+	"build_script":                  "BuildScript",
+	"env":                           "Env",
+	"branches":                      "Branches",
+	"exclude_branches":              "ExcludeBranches",
+	"pull_request_branches":         "PullRequestBranches",
+	"exclude_pull_request_branches": "ExcludePullRequestBranches",
+	"tags":                          "Tags",
+	"exclude_tags":                  "ExcludeTags",
+	"paths":                         "Paths",
+	"exclude_paths":                 "ExcludePaths",
+	"pipeline":                      "Pipeline",
+	"success_template":              "SuccessTemplate",
+	"tag_template":                  "TagTemplate",
+	"failure_template":              "FailureTemplate",
+	"error_template":                "ErrorTemplate",
+	"limit_template":                "LimitTemplate",
+	"timeout_template":              "TimeoutTemplate",
+	"skipped_template":              "SkippedTemplate",
+	"success_color":                 "SuccessColor",
+	"failure_color":                 "FailureColor",
+	"error_color":                   "ErrorColor",
+	"skipped_color":                 "SkippedColor",
+}
+
+// readRepoConfig reads the optional in-repo config from the root of a cloned repo.  It
+// returns the allowed settings keyed the same way as config.json and the names of any
+// keys that were ignored.
+func readRepoConfig(clonedRepo string) (configMap, []string, error) {
+	ymlPath := filepath.Join(clonedRepo, repoConfigFileName)
+	jsonPath := filepath.Join(clonedRepo, repoJSONConfigFileName)
+
+	var (
+		raw map[string]interface{}
+		err error
+	)
+
+	switch {
+	case fileExists(ymlPath) && fileExists(jsonPath):
+		return nil, nil, fmt.Errorf("only one of %v and %v may be present", repoConfigFileName, repoJSONConfigFileName)
+	case fileExists(ymlPath):
+		raw, err = readRepoConfigYAML(ymlPath)
+	case fileExists(jsonPath):
+		raw, err = readRepoConfigJSON(jsonPath)
+	default:
+		return nil, nil, nil
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config, ignored := filterRepoConfig(raw)
+	if _, errs := decodeSettings(config, new(repoSettings)); len(errs) > 0 {
+		return nil, nil, fmt.Errorf("error reading the in-repo config: %v", settingsError(errs))
+	}
+
+	return config, ignored, nil
+}
+
+func readRepoConfigJSON(path string) (map[string]interface{}, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal(bs, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing %v: %v", path, err)
+	}
+
+	return raw, nil
+}
+
+func readRepoConfigYAML(path string) (map[string]interface{}, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed interface{}
+	if err := yaml.Unmarshal(bs, &parsed); err != nil {
+		return nil, fmt.Errorf("error parsing %v: %v", path, err)
+	}
+
+	if parsed == nil {
+		return nil, nil
+	}
+
+	normalized, err := normalizeYAML(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %v: %v", path, err)
+	}
+
+	raw, ok := normalized.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("error parsing %v: expected a mapping at the top level", path)
+	}
+
+	return raw, nil
+}
+
+// normalizeYAML converts what the yaml decoder produces into what encoding/json would
+// have produced for the same document so that the config readers can treat both alike.
+func normalizeYAML(val interface{}) (interface{}, error) {
+	switch v := val.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{})
+		for key, value := range v {
+			keyStr, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("keys must be strings: %v", key)
+			}
+
+			normalized, err := normalizeYAML(value)
+			if err != nil {
+				return nil, err
+			}
+			m[keyStr] = normalized
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			normalized, err := normalizeYAML(value)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = normalized
+		}
+		return l, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	}
+
+	return val, nil
+}
+
+func filterRepoConfig(raw map[string]interface{}) (configMap, []string) {
+	config := make(configMap)
+	var ignored []string
+
+	for key, value := range raw {
+		if configKey, ok := repoConfigKeys[key]; ok {
+			config[configKey] = value
+		} else {
+			ignored = append(ignored, key)
+		}
+	}
+	sort.Strings(ignored)
+
+	return config, ignored
+}
@@ -0,0 +1,202 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testRepoYAML = `
+timeout: 90
+build_script: scripts/ci.sh
+env:
+  FOO: bar
+  COUNT: 3
+branches: [master, "release/*"]
+success_template: "yay {{.Repo}}"
+github_token: stolen
+AWSKey: stolen
+timeout_grace_period: 3600
+hipchat_room: elsewhere
+`
+
+func withRepoConfigFile(t *testing.T, name, contents string, f func(string)) {
+	withTempDir(t, func(path string) {
+		if err := ioutil.WriteFile(filepath.Join(path, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f(path)
+	})
+}
+
+func TestReadRepoConfigYAML(t *testing.T) {
+	withRepoConfigFile(t, ".grim.yml", testRepoYAML, func(path string) {
+		inRepo, ignored, err := readRepoConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(ignored, []string{"AWSKey", "github_token", "hipchat_room", "timeout_grace_period"}) {
+			t.Errorf("expected server only keys to be ignored but got %v", ignored)
+		}
+
+		if _, ok := inRepo["GitHubToken"]; ok {
+			t.Errorf("github token should not be settable from the repo")
+		}
+
+		config := testLocalConfig(localConfig{local: configMap{}, global: globalConfig{}}).withInRepoConfig(inRepo)
+
+		if config.timeout() != 90*time.Second {
+			t.Errorf("timeout was not read from repo config: %v", config.timeout())
+		}
+
+		if config.timeoutGracePeriod() != defaultTimeoutGracePeriod {
+			t.Errorf("grace period should not be settable from the repo: %v", config.timeoutGracePeriod())
+		}
+
+		if script, err := config.buildScript(); err != nil || script != "scripts/ci.sh" {
+			t.Errorf("build script was not read from repo config: %v %v", script, err)
+		}
+
+		if env, err := config.env(); err != nil || !reflect.DeepEqual(env, []string{"COUNT=3", "FOO=bar"}) {
+			t.Errorf("env was not read from repo config: %v %v", env, err)
+		}
+
+		if config.successTemplate() != "yay {{.Repo}}" {
+			t.Errorf("success template was not read from repo config: %v", config.successTemplate())
+		}
+
+		if config.hookSkipReason(hookEvent{EventName: "push", Target: "release/1.2"}) != nil || config.hookSkipReason(hookEvent{EventName: "push", Target: "feature"}) == nil {
+			t.Errorf("branches were not read from repo config: %v", config.branches())
+		}
+	})
+}
+
+func TestReadRepoConfigJSON(t *testing.T) {
+	withRepoConfigFile(t, ".grim.json", `{"timeout": 30, "GitHubToken": "stolen"}`, func(path string) {
+		inRepo, ignored, err := readRepoConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(ignored) != 1 || ignored[0] != "GitHubToken" {
+			t.Errorf("expected GitHubToken to be ignored but got %v", ignored)
+		}
+
+		if to := (localConfig{}).withInRepoConfig(inRepo).timeout(); to != 30*time.Second {
+			t.Errorf("timeout was not read from repo config: %v", to)
+		}
+	})
+}
+
+func TestReadRepoConfigBothFiles(t *testing.T) {
+	withRepoConfigFile(t, ".grim.yml", "timeout: 1", func(path string) {
+		ioutil.WriteFile(filepath.Join(path, ".grim.json"), []byte("{}"), 0644)
+
+		if _, _, err := readRepoConfig(path); err == nil {
+			t.Errorf("expected an error when both repo config files exist")
+		}
+	})
+}
+
+func TestReadRepoConfigMissing(t *testing.T) {
+	withTempDir(t, func(path string) {
+		inRepo, ignored, err := readRepoConfig(path)
+		if inRepo != nil || ignored != nil || err != nil {
+			t.Errorf("expected nothing for a repo without config but got %v %v %v", inRepo, ignored, err)
+		}
+	})
+}
+
+func TestServerConfigOverridesRepoConfig(t *testing.T) {
+	inRepo := configMap{
+		"Timeout":         float64(90),
+		"SuccessTemplate": "repo",
+		"Env":             map[string]interface{}{"FOO": "repo", "BAR": "repo"},
+	}
+
+	config := testLocalConfig(localConfig{
+		local: configMap{
+			"Timeout":         float64(10),
+			"SuccessTemplate": "server",
+			"Env":             map[string]interface{}{"FOO": "server"},
+		},
+		global: testGlobalConfig(configMap{"FailureTemplate": "global"}),
+	}).withInRepoConfig(inRepo)
+
+	if config.timeout() != 10*time.Second || config.successTemplate() != "server" {
+		t.Errorf("server config should take precedence over repo config")
+	}
+
+	if env, _ := config.env(); !reflect.DeepEqual(env, []string{"BAR=repo", "FOO=server"}) {
+		t.Errorf("env was not merged correctly: %v", env)
+	}
+
+	if config.failureTemplate() != "global" {
+		t.Errorf("global config should still back stop repo config")
+	}
+}
+
+func TestInvalidBuildScripts(t *testing.T) {
+	for _, script := range []string{"../outside.sh", "/etc/passwd", "a/../../outside.sh"} {
+		if _, err := (testLocalConfig(localConfig{local: configMap{"BuildScript": script}})).buildScript(); err == nil {
+			t.Errorf("expected %v to be rejected", script)
+		}
+	}
+}
+
+func TestNormalizeYAML(t *testing.T) {
+	normalized, err := normalizeYAML(map[interface{}]interface{}{"a": []interface{}{1, map[interface{}]interface{}{"b": true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{"a": []interface{}{float64(1), map[string]interface{}{"b": true}}}
+	if !reflect.DeepEqual(normalized, expected) {
+		t.Errorf("%v != %v", normalized, expected)
+	}
+
+	if _, err := normalizeYAML(map[interface{}]interface{}{1: "a"}); err == nil {
+		t.Errorf("expected non string keys to be rejected")
+	}
+}
+
+func TestBuildSkippedByRepoConfig(t *testing.T) {
+	resultPath, _ := ioutil.TempDir("", "build-skipped-by-repo-config")
+	defer os.RemoveAll(resultPath)
+
+	tb := &testBuilder{
+		buildScriptPath: "!@#",
+		buildResult:     &executeResult{ExitCode: 0},
+		repoConfig:      configMap{"Branches": []interface{}{"master"}},
+		skipReason:      getStringPtr("because of reasons"),
+	}
+
+	result, _, err := grimBuild(tb, resultPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.SkipReason != "because of reasons" || result.RepoConfig == nil {
+		t.Errorf("skipped result was not returned: %+v", result)
+	}
+
+	buildFile, _ := ioutil.ReadFile(filepath.Join(resultPath, "build.txt"))
+	buildText := string(buildFile)
+
+	if !strings.Contains(buildText, "build skipped because of reasons") {
+		t.Errorf("Failed to log skipped build")
+	}
+
+	if strings.Contains(buildText, "build started") {
+		t.Errorf("skipped build should not have started")
+	}
+}
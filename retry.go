@@ -0,0 +1,211 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/google/go-github/github"
+)
+
+// retryPolicy is how often and how patiently a GitHub or AWS call is retried when it fails
+// with a transient error.
+type retryPolicy struct {
+	attempts  int
+	baseDelay time.Duration
+	maxDelay  time.Duration       // the longest grim waits between attempts
+	sleep     func(time.Duration) // time.Sleep, or a fake in tests
+	jitter    func(int64) int64   // rand.Int63n, or a fake in tests
+}
+
+var defaultRetryPolicy = retryPolicy{
+	attempts:  4,
+	baseDelay: 500 * time.Millisecond,
+	maxDelay:  30 * time.Second,
+	sleep:     time.Sleep,
+	jitter:    rand.Int63n,
+}
+
+// do calls f until it succeeds, fails with an error that isn't transient or has been tried
+// the policy's number of times.  Between attempts it waits for as long as the error asks,
+// eg. GitHub's Retry-After, or otherwise a jittered exponential backoff.  If the error asks
+// for a longer wait than the policy allows it is returned rather than waited out, and
+// grimd stops polling until RetryAt.  AWS clients whose calls are retried by do should
+// turn off the SDK's own retries with noSDKRetries.
+func (p retryPolicy) do(f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= p.attempts || Kind(err) != TransientError {
+			return err
+		}
+
+		delay, ok := retryAfter(err)
+		if !ok {
+			delay = p.backoff(attempt)
+		} else if delay > p.maxDelay {
+			return err
+		}
+
+		p.sleep(delay)
+	}
+}
+
+// noSDKRetries stops an AWS client retrying calls itself, which would multiply the
+// attempts of do.
+var noSDKRetries = aws.NewConfig().WithMaxRetries(0)
+
+// backoff is a random delay of up to baseDelay * 2^(attempt-1), capped at maxDelay.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.maxDelay
+	if shift := uint(attempt - 1); shift < 32 && p.baseDelay<<shift < ceiling {
+		ceiling = p.baseDelay << shift
+	}
+
+	if ceiling <= 0 {
+		return 0
+	}
+
+	return time.Duration(p.jitter(int64(ceiling)))
+}
+
+// retryAfter is how long a GitHub error asks to be waited for before trying again.
+func retryAfter(err error) (time.Duration, bool) {
+	switch e := err.(type) {
+	case *gerror:
+		if !e.retryAt.IsZero() {
+			return time.Until(e.retryAt), true
+		}
+		return retryAfter(e.err)
+	case *github.AbuseRateLimitError:
+		if e.RetryAfter != nil {
+			return *e.RetryAfter, true
+		}
+		return headerRetryAfter(e.Response)
+	case *github.RateLimitError:
+		if d := time.Until(e.Rate.Reset.Time); d > 0 {
+			return d, true
+		}
+		return 0, true
+	case *github.ErrorResponse:
+		return headerRetryAfter(e.Response)
+	}
+
+	return 0, false
+}
+
+func headerRetryAfter(res *http.Response) (time.Duration, bool) {
+	if res == nil {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if res.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			if d := time.Until(time.Unix(reset, 0)); d > 0 {
+				return d, true
+			}
+			return 0, true
+		}
+	}
+
+	return 0, false
+}
+
+// awsTransientCodes are the AWS error codes of throttling and of failures on AWS's side.
+var awsTransientCodes = map[string]bool{
+	"RequestError":                           true, // the request couldn't be sent
+	"RequestTimeout":                         true,
+	"RequestTimeoutException":                true,
+	"Throttling":                             true,
+	"ThrottlingException":                    true,
+	"ThrottledException":                     true,
+	"RequestThrottled":                       true,
+	"RequestThrottledException":              true,
+	"RequestLimitExceeded":                   true,
+	"TooManyRequestsException":               true,
+	"ProvisionedThroughputExceededException": true,
+	"SlowDown":                               true,
+	"InternalError":                          true,
+	"InternalFailure":                        true,
+	"ServiceUnavailable":                     true,
+
+	// temporary credentials, eg. of an assumed role or an instance profile, are refreshed
+	// by the SDK once they expire, so these aren't the AuthError that stops grimd
+	"ExpiredToken":          true,
+	"ExpiredTokenException": true,
+}
+
+// awsAuthCodes are the AWS error codes of credentials that were rejected or aren't allowed
+// to do something.
+var awsAuthCodes = map[string]bool{
+	"AccessDenied":                true,
+	"AccessDeniedException":       true,
+	"AuthorizationError":          true,
+	"InvalidAccessKeyId":          true,
+	"InvalidClientTokenId":        true,
+	"MissingAuthenticationToken":  true,
+	"SignatureDoesNotMatch":       true,
+	"UnrecognizedClientException": true,
+}
+
+// classifyError is the kind of an error from a GitHub or AWS client, or UnknownError if it
+// can't tell.
+func classifyError(err error) ErrorKind {
+	switch e := err.(type) {
+	case nil:
+		return UnknownError
+	case *github.RateLimitError, *github.AbuseRateLimitError:
+		return TransientError
+	case *github.ErrorResponse:
+		if e.Response != nil {
+			if _, ok := headerRetryAfter(e.Response); ok {
+				return TransientError
+			}
+			return statusKind(e.Response.StatusCode)
+		}
+	case awserr.Error:
+		if awsTransientCodes[e.Code()] {
+			return TransientError
+		} else if awsAuthCodes[e.Code()] {
+			return AuthError
+		} else if failure, ok := e.(awserr.RequestFailure); ok {
+			return statusKind(failure.StatusCode())
+		}
+	case *url.Error:
+		return classifyError(e.Err)
+	case net.Error:
+		if e.Timeout() || e.Temporary() {
+			return TransientError
+		}
+	}
+
+	if err == context.DeadlineExceeded {
+		return TransientError
+	}
+
+	return UnknownError
+}
+
+func statusKind(code int) ErrorKind {
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return AuthError
+	case code == http.StatusTooManyRequests || code >= 500:
+		return TransientError
+	}
+
+	return UnknownError
+}
@@ -0,0 +1,181 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/google/go-github/github"
+)
+
+func testRetryPolicy(slept *[]time.Duration) retryPolicy {
+	return retryPolicy{
+		attempts:  3,
+		baseDelay: time.Second,
+		maxDelay:  5 * time.Second,
+		sleep:     func(d time.Duration) { *slept = append(*slept, d) },
+		jitter:    func(n int64) int64 { return n }, // always the longest delay
+	}
+}
+
+func failures(errs ...error) func() error {
+	return func() error {
+		if len(errs) == 0 {
+			return nil
+		}
+
+		err := errs[0]
+		errs = errs[1:]
+		return err
+	}
+}
+
+func TestRetryRetriesTransientErrors(t *testing.T) {
+	var slept []time.Duration
+	throttled := awserr.New("Throttling", "Rate exceeded", nil)
+
+	if err := testRetryPolicy(&slept).do(failures(throttled, throttled)); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []time.Duration{time.Second, 2 * time.Second}; !reflect.DeepEqual(slept, expected) {
+		t.Errorf("expected delays of %v but got %v", expected, slept)
+	}
+}
+
+func TestRetryGivesUpAfterAttempts(t *testing.T) {
+	var slept []time.Duration
+	throttled := awserr.New("Throttling", "Rate exceeded", nil)
+
+	if err := testRetryPolicy(&slept).do(failures(throttled, throttled, throttled, throttled)); err != throttled {
+		t.Errorf("expected the last error but got %v", err)
+	}
+
+	if len(slept) != 2 {
+		t.Errorf("expected 3 attempts but waited %v times", len(slept))
+	}
+}
+
+func TestRetryDoesntRetryOtherErrors(t *testing.T) {
+	var slept []time.Duration
+
+	for _, err := range []error{
+		errors.New("not classified"),
+		awserr.New("AccessDenied", "denied", nil),
+		&github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusNotFound}},
+	} {
+		if got := testRetryPolicy(&slept).do(failures(err)); got != err {
+			t.Errorf("expected %v but got %v", err, got)
+		}
+	}
+
+	if len(slept) != 0 {
+		t.Errorf("expected no retries but waited %v", slept)
+	}
+}
+
+func TestRetryWaitsForRetryAfter(t *testing.T) {
+	var slept []time.Duration
+	wait := 3 * time.Second
+
+	if err := testRetryPolicy(&slept).do(failures(&github.AbuseRateLimitError{RetryAfter: &wait})); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []time.Duration{wait}; !reflect.DeepEqual(slept, expected) {
+		t.Errorf("expected delays of %v but got %v", expected, slept)
+	}
+}
+
+func TestRetryDoesntWaitOutLongRateLimits(t *testing.T) {
+	var slept []time.Duration
+	limited := &github.RateLimitError{Rate: github.Rate{Reset: github.Timestamp{Time: time.Now().Add(time.Hour)}}}
+
+	if err := testRetryPolicy(&slept).do(failures(limited)); err != limited {
+		t.Errorf("expected the rate limit error but got %v", err)
+	}
+
+	if len(slept) != 0 {
+		t.Errorf("expected no retries but waited %v", slept)
+	}
+
+	wrapped := grimErrorf("error getting merge commit sha: %v", grimErrorf("github: %v", limited))
+	if retryAt := RetryAt(wrapped); retryAt.Sub(limited.Rate.Reset.Time).Round(time.Second) != 0 {
+		t.Errorf("expected grimd to be told to wait until %v but got %v", limited.Rate.Reset.Time, retryAt)
+	}
+}
+
+func TestRetryBackoffIsCapped(t *testing.T) {
+	var slept []time.Duration
+	p := testRetryPolicy(&slept)
+
+	if backoff := p.backoff(40); backoff != p.maxDelay {
+		t.Errorf("expected %v but got %v", p.maxDelay, backoff)
+	}
+}
+
+func TestHeaderRetryAfter(t *testing.T) {
+	retryAfter := &http.Response{Header: http.Header{"Retry-After": []string{"7"}}}
+	if d, ok := headerRetryAfter(retryAfter); !ok || d != 7*time.Second {
+		t.Errorf("expected 7s but got %v %v", d, ok)
+	}
+
+	reset := fmt.Sprint(time.Now().Add(-time.Minute).Unix())
+	exhausted := &http.Response{Header: http.Header{"X-Ratelimit-Remaining": []string{"0"}, "X-Ratelimit-Reset": []string{reset}}}
+	if d, ok := headerRetryAfter(exhausted); !ok || d != 0 {
+		t.Errorf("expected no wait for a reset rate limit but got %v %v", d, ok)
+	}
+
+	if _, ok := headerRetryAfter(&http.Response{Header: http.Header{}}); ok {
+		t.Error("expected no wait without headers")
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	for _, c := range []struct {
+		err      error
+		expected ErrorKind
+	}{
+		{nil, UnknownError},
+		{errors.New("unclassified"), UnknownError},
+		{awserr.New("ThrottlingException", "slow down", nil), TransientError},
+		{awserr.New("RequestError", "send request failed", nil), TransientError},
+		{awserr.New("InvalidClientTokenId", "bad token", nil), AuthError},
+		{awserr.New("ExpiredToken", "expired", nil), TransientError},
+		{awserr.NewRequestFailure(awserr.New("ExpiredTokenException", "expired", nil), http.StatusForbidden, ""), TransientError},
+		{awserr.NewRequestFailure(awserr.New("Unknown", "", nil), http.StatusServiceUnavailable, ""), TransientError},
+		{awserr.NewRequestFailure(awserr.New("Unknown", "", nil), http.StatusForbidden, ""), AuthError},
+		{awserr.NewRequestFailure(awserr.New("Unknown", "", nil), http.StatusBadRequest, ""), UnknownError},
+		{&github.RateLimitError{}, TransientError},
+		{&github.AbuseRateLimitError{}, TransientError},
+		{&github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusBadGateway}}, TransientError},
+		{&github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusUnauthorized}}, AuthError},
+		{&github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusUnprocessableEntity}}, UnknownError},
+	} {
+		if kind := classifyError(c.err); kind != c.expected {
+			t.Errorf("expected %v to be %v but was %v", c.err, c.expected, kind)
+		}
+	}
+}
+
+func TestGrimErrorsKeepKind(t *testing.T) {
+	throttled := awserr.New("Throttling", "Rate exceeded", nil)
+
+	wrapped := grimErrorf("error retrieving message: %v", grimErrorf("aws error: %v", throttled))
+	if !IsTransient(wrapped) || IsFatal(wrapped) {
+		t.Errorf("expected a transient, recoverable error but got %v %v", Kind(wrapped), IsFatal(wrapped))
+	}
+
+	config := fatalGrimErrorf("error while reading config: %v", errors.New("bad json")).withKind(ConfigError)
+	if Kind(config) != ConfigError || !IsFatal(config) {
+		t.Errorf("expected a fatal config error but got %v %v", Kind(config), IsFatal(config))
+	}
+}
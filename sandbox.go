@@ -0,0 +1,116 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// nobody and nogroup on most linux distributions
+const defaultSandboxID = 65534
+
+// sandboxInitArg is the first argument grim re-executes itself with to set up a sandbox
+// before running a build inside it.
+const sandboxInitArg = "__grim_sandbox_init"
+
+// sandboxConfig is how builds are isolated from grimd and each other.
+type sandboxConfig struct {
+	UID       *int
+	GID       *int
+	Network   bool
+	CacheDirs []string
+
+	// paths the build must not see, like the config root and other repos' workspaces
+	hidden []string
+}
+
+func validateSandbox(sandbox *sandboxConfig) error {
+	if sandbox == nil {
+		return nil
+	}
+
+	for _, id := range []*int{sandbox.UID, sandbox.GID} {
+		if id != nil && *id <= 0 {
+			return fmt.Errorf("Sandbox UID and GID must be positive; builds are never run as root")
+		}
+	}
+
+	for _, dir := range sandbox.CacheDirs {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("Sandbox cache dir %q must be an absolute path", dir)
+		}
+	}
+
+	return nil
+}
+
+func (s *sandboxConfig) uid() int {
+	if s.UID == nil {
+		return defaultSandboxID
+	}
+
+	return *s.UID
+}
+
+func (s *sandboxConfig) gid() int {
+	if s.GID == nil {
+		return defaultSandboxID
+	}
+
+	return *s.GID
+}
+
+// hide adds paths which are replaced with an empty directory inside the sandbox.
+func (s *sandboxConfig) hide(paths ...string) {
+	for _, path := range paths {
+		if path != "" {
+			s.hidden = append(s.hidden, filepath.Clean(path))
+		}
+	}
+}
+
+func (s *sandboxConfig) isHidden(path string) bool {
+	for _, hidden := range s.hidden {
+		if path == hidden || strings.HasPrefix(path, hidden+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sandboxSpec is what the re-executed grim needs to set up a sandbox and start the build.
+type sandboxSpec struct {
+	UID           int
+	GID           int
+	Dir           string
+	Hide          []string
+	Binds         []string
+	ReadOnlyBinds []string
+	MountProc     bool
+	Rlimits       []rlimit `json:",omitempty"`
+}
+
+// spec is the sandbox for one command.  Only the working directory and cache dirs are
+// writable and visible if they are under a hidden path, as is the command itself so
+// that a build script from the config root can still be run.
+func (s *sandboxConfig) spec(workingDir, execPath string) sandboxSpec {
+	spec := sandboxSpec{
+		UID:       s.uid(),
+		GID:       s.gid(),
+		Dir:       workingDir,
+		Hide:      s.hidden,
+		Binds:     append([]string{workingDir}, s.CacheDirs...),
+		MountProc: true,
+	}
+
+	if filepath.IsAbs(execPath) && s.isHidden(execPath) && !strings.HasPrefix(execPath, workingDir+"/") {
+		spec.ReadOnlyBinds = []string{execPath}
+	}
+
+	return spec
+}
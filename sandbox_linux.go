@@ -0,0 +1,249 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+// wrap changes cmd to start grim in new mount and pid namespaces, and a new network
+// namespace unless the sandbox allows network access, where it sets up the sandbox and
+// runs the original command as the sandbox user with the given rlimits.
+func (s *sandboxConfig) wrap(cmd *exec.Cmd, rlimits []rlimit) error {
+	dir, err := filepath.Abs(cmd.Dir)
+	if err != nil {
+		return err
+	}
+
+	sandboxSpec := s.spec(dir, cmd.Path)
+	sandboxSpec.Rlimits = rlimits
+
+	spec, err := json.Marshal(sandboxSpec)
+	if err != nil {
+		return err
+	}
+
+	cmd.Args = append([]string{selfExe, sandboxInitArg, string(spec), cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = selfExe
+
+	cmd.SysProcAttr.Cloneflags = syscall.CLONE_NEWNS | syscall.CLONE_NEWPID
+	if !s.Network {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+
+	return nil
+}
+
+// sandboxInit runs as root and pid 1 of the sandbox's namespaces.  It returns the exit
+// code of the build.
+func sandboxInit(specJSON string, command []string) int {
+	var spec sandboxSpec
+	err := json.Unmarshal([]byte(specJSON), &spec)
+	if err == nil && len(command) == 0 {
+		err = fmt.Errorf("no command to run")
+	}
+
+	if err == nil {
+		err = setupSandbox(spec)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grim sandbox: %v\n", err)
+		return reexecFailed
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	if err := wrapRlimits(cmd, spec.Rlimits); err != nil {
+		fmt.Fprintf(os.Stderr, "grim sandbox: %v\n", err)
+		return reexecFailed
+	}
+
+	cmd.Dir = spec.Dir
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(spec.UID), Gid: uint32(spec.GID), Groups: []uint32{}},
+		Setpgid:    true,
+	}
+
+	// a timed out build is sent SIGTERM, which would end the sandbox and everything in it
+	// if pid 1 didn't pass it on
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, syscall.SIGTERM)
+
+	// staying around as pid 1 rather than exec'ing means orphans of the build are reaped
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "grim sandbox: %v\n", err)
+		return reexecFailed
+	}
+
+	go func() {
+		for range terminate {
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+		}
+	}()
+
+	err = cmd.Wait()
+	if err == nil {
+		return 0
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			return status.ExitStatus()
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "grim sandbox: %v\n", err)
+	return reexecFailed
+}
+
+func setupSandbox(spec sandboxSpec) error {
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("error making mounts private: %v", err)
+	}
+
+	for _, dir := range spec.Binds {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+
+		// everything in the workspace was written by grimd but cache dirs only need their top
+		chown := os.Lchown
+		if dir == spec.Dir {
+			chown = chownTree
+		}
+
+		if err := chown(dir, spec.UID, spec.GID); err != nil {
+			return fmt.Errorf("error giving the sandbox user %v: %v", dir, err)
+		}
+	}
+
+	// the paths to bind are held open so they can be mounted once their parents are hidden
+	binds, err := openAll(spec.Binds)
+	if err != nil {
+		return err
+	}
+	defer closeAll(binds)
+
+	readOnlyBinds, err := openAll(spec.ReadOnlyBinds)
+	if err != nil {
+		return err
+	}
+	defer closeAll(readOnlyBinds)
+
+	for _, path := range spec.Hide {
+		if !fileExists(path) {
+			continue
+		}
+
+		if err := syscall.Mount("tmpfs", path, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755"); err != nil {
+			return fmt.Errorf("error hiding %v: %v", path, err)
+		}
+	}
+
+	if err := syscall.Mount("tmpfs", "/tmp", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("error creating a private /tmp: %v", err)
+	}
+
+	for _, f := range binds {
+		if err := bindMount(f, false); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range readOnlyBinds {
+		if err := bindMount(f, true); err != nil {
+			return err
+		}
+	}
+
+	if spec.MountProc {
+		if err := syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+			return fmt.Errorf("error mounting /proc: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// bindMount mounts the open file or directory f back at its own path.
+func bindMount(f *os.File, readOnly bool) error {
+	target := f.Name()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		err = os.MkdirAll(target, 0755)
+	} else if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil && !fileExists(target) {
+		err = touchFile(target)
+	}
+	if err != nil {
+		return fmt.Errorf("error creating mount point %v: %v", target, err)
+	}
+
+	source := fmt.Sprintf("/proc/self/fd/%d", f.Fd())
+	if err := syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("error binding %v: %v", target, err)
+	}
+
+	if readOnly {
+		if err := syscall.Mount("", target, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+			return fmt.Errorf("error making %v read only: %v", target, err)
+		}
+	}
+
+	return nil
+}
+
+func touchFile(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func chownTree(root string, uid, gid int) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		return os.Lchown(path, uid, gid)
+	})
+}
+
+func openAll(paths []string) ([]*os.File, error) {
+	var files []*os.File
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			closeAll(files)
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	return files, nil
+}
+
+func closeAll(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
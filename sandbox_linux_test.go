@@ -0,0 +1,156 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSandboxedBuild(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("Skipping prepare test in short mode.")
+	}
+
+	if os.Geteuid() != 0 {
+		t.Skipf("Skipping sandbox test when not root.")
+	}
+
+	root, _ := ioutil.TempDir("", "sandboxed-build")
+	defer os.RemoveAll(root)
+
+	configRoot := filepath.Join(root, "config")
+	workspaceRoot := filepath.Join(root, "workspaces")
+	workspace := filepath.Join(workspaceRoot, "MediaMath", "grim", "1")
+	other := filepath.Join(workspaceRoot, "MediaMath", "other", "1")
+
+	os.MkdirAll(filepath.Join(configRoot, "MediaMath", "grim"), 0700)
+	os.MkdirAll(workspace, 0700)
+	os.MkdirAll(other, 0700)
+	ioutil.WriteFile(filepath.Join(configRoot, "config.json"), []byte(`{"GitHubToken": "secret"}`), 0600)
+	ioutil.WriteFile(filepath.Join(other, "file"), []byte("other repo"), 0600)
+
+	buildScript := filepath.Join(configRoot, "MediaMath", "grim", "build.sh")
+	ioutil.WriteFile(buildScript, []byte(`#!/bin/sh
+id -u
+ls `+configRoot+`
+cat `+other+`/file 2>/dev/null
+echo written > output
+touch /tmp/private
+tr '\0' ' ' < /proc/1/cmdline
+`), 0755)
+
+	sandbox := &sandboxConfig{}
+	sandbox.hide(configRoot, workspaceRoot)
+
+	result, err := executeWithOutputChanCollected(sandbox, nil, workspace, buildScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.ExitCode != 0 {
+		t.Fatalf("sandboxed build failed: %v", result.Output)
+	}
+
+	lines := strings.Split(strings.TrimSpace(result.Output), "\n")
+	if lines[0] != "65534" {
+		t.Errorf("build did not run as the sandbox user: %v", result.Output)
+	}
+
+	if strings.Contains(result.Output, "config.json") || strings.Contains(result.Output, "other repo") {
+		t.Errorf("build could see hidden paths: %v", result.Output)
+	}
+
+	if !strings.Contains(result.Output, sandboxInitArg) {
+		t.Errorf("build was not in its own pid namespace: %v", result.Output)
+	}
+
+	if bs, _ := ioutil.ReadFile(filepath.Join(workspace, "output")); string(bs) != "written\n" {
+		t.Errorf("build could not write to its workspace")
+	}
+
+	if fileExists("/tmp/private") {
+		os.Remove("/tmp/private")
+		t.Errorf("build did not have a private /tmp")
+	}
+}
+
+func executeWithOutputChanCollected(sandbox *sandboxConfig, limits *resourceLimits, workingDir, execPath string) (*executeResult, error) {
+	outputChan := make(chan string)
+	done := make(chan string)
+
+	go func() {
+		var out string
+		for line := range outputChan {
+			out += line + "\n"
+		}
+		done <- out
+	}()
+
+	result, err := executeWithOutputChan(outputChan, nil, sandbox, limits, nil, workingDir, execPath, testBuildtimeout, 0)
+	output := <-done
+	if err != nil {
+		return nil, err
+	}
+
+	result.Output = output
+	return result, nil
+}
+
+func TestSandboxedBuildLimits(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("Skipping prepare test in short mode.")
+	}
+
+	if os.Geteuid() != 0 {
+		t.Skipf("Skipping sandbox test when not root.")
+	}
+
+	withTempDir(t, func(path string) {
+		script := filepath.Join(path, "spin.sh")
+		ioutil.WriteFile(script, []byte("#!/bin/sh\nwhile true; do :; done\n"), 0755)
+
+		result, err := executeWithOutputChanCollected(&sandboxConfig{}, &resourceLimits{CPUSeconds: 1}, path, script)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.LimitExceeded != limitCPU {
+			t.Errorf("sandboxed build was not stopped by the cpu limit: %v %v", result.ExitCode, result.Output)
+		}
+	})
+}
+
+func TestSandboxedGracefulTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("Skipping prepare test in short mode.")
+	}
+
+	if os.Geteuid() != 0 {
+		t.Skipf("Skipping sandbox test when not root.")
+	}
+
+	withTempDir(t, func(path string) {
+		script := filepath.Join(path, "trap.sh")
+		ioutil.WriteFile(script, []byte("#!/bin/sh\ntrap 'touch cleaned-up; exit 1' TERM\nwhile true; do sleep 0.1; done\n"), 0755)
+
+		result, err := executeWithOutputChan(nil, nil, &sandboxConfig{}, nil, nil, path, script, 500*time.Millisecond, 10*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !result.TimedOut {
+			t.Errorf("expected the build to time out but got: %+v", result)
+		}
+
+		if !fileExists(filepath.Join(path, "cleaned-up")) {
+			t.Errorf("sandboxed build was not given the chance to clean up")
+		}
+	})
+}
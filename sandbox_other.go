@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func (s *sandboxConfig) wrap(cmd *exec.Cmd, rlimits []rlimit) error {
+	return fmt.Errorf("sandboxed builds need linux namespaces")
+}
@@ -0,0 +1,94 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSandbox(t *testing.T) {
+	config := testLocalConfig(localConfig{local: configMap{"Sandbox": map[string]interface{}{"UID": float64(1500), "CacheDirs": []interface{}{"/var/cache/grim"}}}})
+	sandbox, err := config.sandbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sandbox.uid() != 1500 || sandbox.gid() != defaultSandboxID || sandbox.Network {
+		t.Errorf("unexpected sandbox %+v", sandbox)
+	}
+
+	if sandbox, _ := testLocalConfig(localConfig{local: configMap{}}).sandbox(); sandbox != nil {
+		t.Errorf("builds should not be sandboxed unless configured")
+	}
+
+	invalid := []string{
+		`"yes"`,
+		`{"Network": "off"}`,
+		`{"UID": 0}`,
+		`{"GID": -1}`,
+		`{"CacheDirs": ["relative/cache"]}`,
+	}
+
+	for _, val := range invalid {
+		if err := testSettingError(t, "Sandbox", val, func(lc localConfig) error { _, err := lc.sandbox(); return err }); err == nil {
+			t.Errorf("expected %v to be invalid", val)
+		}
+	}
+}
+
+func TestSandboxIsCopiedForEachBuild(t *testing.T) {
+	config := testLocalConfig(localConfig{local: configMap{}, global: testGlobalConfig(configMap{"Sandbox": map[string]interface{}{}})})
+
+	first, _ := config.sandbox()
+	first.hide("/etc/grim")
+
+	if second, _ := config.sandbox(); len(second.hidden) != 0 {
+		t.Errorf("hiding a path for one build hid it for the next: %v", second.hidden)
+	}
+}
+
+func TestSandboxConfig(t *testing.T) {
+	config := testLocalConfig(localConfig{local: configMap{}, global: testGlobalConfig(configMap{"Sandbox": map[string]interface{}{"Network": true}})})
+	if sandbox, _ := config.sandbox(); sandbox == nil || !sandbox.Network {
+		t.Errorf("server sandbox was not used: %+v", sandbox)
+	}
+
+	config = testLocalConfig(localConfig{local: configMap{"SnsTopicName": "topic", "Sandbox": map[string]interface{}{"UID": float64(0)}}})
+	if errs := config.errors(); len(errs) != 1 {
+		t.Errorf("expected an invalid sandbox error but got %v", errs)
+	}
+
+	config = testLocalConfig(localConfig{local: configMap{}, inRepo: configMap{"Sandbox": map[string]interface{}{}}})
+	if sandbox, _ := config.sandbox(); sandbox != nil {
+		t.Errorf("sandbox should not be read from the repo's own config")
+	}
+}
+
+func TestSandboxSpec(t *testing.T) {
+	sandbox := &sandboxConfig{CacheDirs: []string{"/var/cache/grim"}}
+	sandbox.hide("/etc/grim", "/var/tmp/grim", "")
+
+	spec := sandbox.spec("/var/tmp/grim/MediaMath/grim/123", "/etc/grim/MediaMath/grim/build.sh")
+	expected := sandboxSpec{
+		UID:           defaultSandboxID,
+		GID:           defaultSandboxID,
+		Dir:           "/var/tmp/grim/MediaMath/grim/123",
+		Hide:          []string{"/etc/grim", "/var/tmp/grim"},
+		Binds:         []string{"/var/tmp/grim/MediaMath/grim/123", "/var/cache/grim"},
+		ReadOnlyBinds: []string{"/etc/grim/MediaMath/grim/build.sh"},
+		MountProc:     true,
+	}
+
+	if !reflect.DeepEqual(spec, expected) {
+		t.Errorf("%+v != %+v", spec, expected)
+	}
+
+	for _, execPath := range []string{"/bin/sh", "/var/tmp/grim/MediaMath/grim/123/grim_build.sh"} {
+		if spec := sandbox.spec("/var/tmp/grim/MediaMath/grim/123", execPath); spec.ReadOnlyBinds != nil {
+			t.Errorf("%v should not need to be bound into the sandbox", execPath)
+		}
+	}
+}
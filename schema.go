@@ -0,0 +1,105 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// schemaType is implemented by settings whose JSON Schema can't be derived from their type.
+type schemaType interface {
+	jsonSchema() map[string]interface{}
+}
+
+// GlobalConfigSchema is the JSON Schema of the global config.json.
+func GlobalConfigSchema() ([]byte, error) {
+	return configSchema("grim global config.json", reflect.TypeOf(globalSettings{}))
+}
+
+// RepoConfigSchema is the JSON Schema of a repo's config.json.
+func RepoConfigSchema() ([]byte, error) {
+	return configSchema("grim repo config.json", reflect.TypeOf(repoSettings{}))
+}
+
+func configSchema(title string, typ reflect.Type) ([]byte, error) {
+	properties := map[string]interface{}{
+		schemaKey: map[string]interface{}{"type": "string", "description": "JSON Schema the file is checked against by editors"},
+	}
+
+	for key, field := range settingsFields(typ) {
+		property := typeSchema(field.typ)
+		if field.doc != "" {
+			property["description"] = field.doc
+		}
+		if field.credential {
+			property["description"] = field.doc + "; or an env:NAME or file:/path reference to it"
+		}
+		if def, ok := configDefaults[key]; ok {
+			property["default"] = def
+		}
+		properties[key] = property
+	}
+
+	schema := map[string]interface{}{
+		"$schema":              jsonSchemaDraft,
+		"title":                title,
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+
+	bs, err := json.MarshalIndent(schema, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(bs, '\n'), nil
+}
+
+// typeSchema describes the values a Go type is decoded from.  Structs aren't closed with
+// additionalProperties since encoding/json ignores the keys it doesn't know in them.
+func typeSchema(typ reflect.Type) map[string]interface{} {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	if s, ok := reflect.Zero(typ).Interface().(schemaType); ok {
+		return s.jsonSchema()
+	}
+
+	switch typ.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Int:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(typ.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(typ.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for n := 0; n < typ.NumField(); n++ {
+			field := typ.Field(n)
+			if field.PkgPath != "" {
+				continue
+			}
+
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = typeSchema(field.Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}
+	}
+
+	return map[string]interface{}{}
+}
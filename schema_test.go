@@ -0,0 +1,68 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestPublishedSchemasAreCurrent(t *testing.T) {
+	schemas := map[string]func() ([]byte, error){
+		"docs/config.schema.json":      GlobalConfigSchema,
+		"docs/repo-config.schema.json": RepoConfigSchema,
+	}
+
+	for path, schema := range schemas {
+		generated, err := schema()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		published, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(generated) != string(published) {
+			t.Errorf("%v is out of date, run make schema", path)
+		}
+	}
+}
+
+func TestRepoConfigSchema(t *testing.T) {
+	bs, err := RepoConfigSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema struct {
+		AdditionalProperties bool                              `json:"additionalProperties"`
+		Properties           map[string]map[string]interface{} `json:"properties"`
+	}
+	if err := json.Unmarshal(bs, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	if schema.AdditionalProperties {
+		t.Errorf("unknown keys should not be allowed")
+	}
+
+	for key, field := range repoSettingsFields {
+		if property, ok := schema.Properties[key]; !ok || field.doc == "" || !strings.HasPrefix(property["description"].(string), field.doc) {
+			t.Errorf("%v is not described: %v", key, property)
+		}
+	}
+
+	if schema.Properties["Timeout"]["type"] != "integer" || schema.Properties["Timeout"]["default"] != float64(300) {
+		t.Errorf("unexpected Timeout schema %v", schema.Properties["Timeout"])
+	}
+
+	if _, ok := schema.Properties["GrimQueueName"]; ok {
+		t.Errorf("global keys should not be in the repo schema")
+	}
+}
@@ -0,0 +1,197 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const secretsNonceSize = 24
+
+// SealSecrets encrypts a JSON object of secret names to values with the server's
+// SecretsKeyFile and stores it as the encrypted secrets file of a repo.
+func (i *Instance) SealSecrets(owner, repo string, plain io.Reader) error {
+	configRoot := getEffectiveConfigRoot(i.configRoot)
+
+	config, err := readGlobalConfig(configRoot)
+	if err != nil {
+		return fatalGrimErrorf("error while reading config: %v", err).withKind(ConfigError)
+	}
+
+	if !isConfiguredRepo(configRoot, owner, repo) {
+		return fatalGrimErrorf("%v/%v is not a configured repo", owner, repo)
+	}
+
+	key, err := readSecretsKey(config.secretsKeyFile())
+	if err != nil {
+		return fatalGrimErrorf("%v", err)
+	}
+
+	var secrets map[string]string
+	if err := json.NewDecoder(plain).Decode(&secrets); err != nil {
+		return fatalGrimErrorf("secrets must be a JSON object of strings: %v", err)
+	}
+
+	sealed, err := sealSecrets(key, secrets)
+	if err != nil {
+		return fatalGrimErrorf("error encrypting secrets: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(configRoot, owner, repo, secretsFileName), sealed, 0600); err != nil {
+		return fatalGrimErrorf("error writing encrypted secrets: %v", err)
+	}
+
+	return nil
+}
+
+// secretSource says where the value of one of a repo's secrets comes from: either a file
+// in the repo's configuration directory or an entry in its encrypted secrets file.
+type secretSource struct {
+	File      string `json:"file,omitempty"`
+	Encrypted string `json:"encrypted,omitempty"`
+}
+
+func validateSecrets(sources map[string]secretSource) error {
+	for name, source := range sources {
+		if !validEnvName.MatchString(name) {
+			return fmt.Errorf("secret %q is not a valid environment variable name", name)
+		}
+
+		if (source.File == "") == (source.Encrypted == "") {
+			return fmt.Errorf("secret %q must have exactly one of file or encrypted", name)
+		}
+
+		if source.File != "" {
+			cleaned := filepath.Clean(source.File)
+			if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+				return fmt.Errorf("secret %q file %q must be inside the repo's configuration directory", name, source.File)
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadSecrets reads the values of a repo's secrets and returns them as environment
+// variables along with the bare values so that they can be redacted.
+func loadSecrets(repoConfigDir, keyFile string, sources map[string]secretSource) (env []string, values []string, err error) {
+	var names []string
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var encrypted map[string]string
+	for _, name := range names {
+		source := sources[name]
+
+		var value string
+		if source.File != "" {
+			bs, err := ioutil.ReadFile(filepath.Join(repoConfigDir, filepath.Clean(source.File)))
+			if err != nil {
+				return nil, nil, fmt.Errorf("error reading secret %v: %v", name, err)
+			}
+			value = strings.TrimRight(string(bs), "\r\n")
+		} else {
+			if encrypted == nil {
+				encrypted, err = readSealedSecrets(filepath.Join(repoConfigDir, secretsFileName), keyFile)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+
+			var ok bool
+			if value, ok = encrypted[source.Encrypted]; !ok {
+				return nil, nil, fmt.Errorf("secret %v refers to %q which is not in %v", name, source.Encrypted, secretsFileName)
+			}
+		}
+
+		env = append(env, fmt.Sprintf("%v=%v", name, value))
+		values = append(values, value)
+	}
+
+	return env, values, nil
+}
+
+// readSecretsKey reads the server's 32 byte secrets key, stored hex encoded.
+func readSecretsKey(keyFile string) (*[32]byte, error) {
+	if keyFile == "" {
+		return nil, fmt.Errorf("SecretsKeyFile must be set to use encrypted secrets")
+	}
+
+	bs, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading secrets key: %v", err)
+	}
+
+	decoded, err := hex.DecodeString(strings.TrimSpace(string(bs)))
+	if err != nil || len(decoded) != 32 {
+		return nil, fmt.Errorf("secrets key in %v must be 32 hex encoded bytes", keyFile)
+	}
+
+	key := new([32]byte)
+	copy(key[:], decoded)
+	return key, nil
+}
+
+func readSealedSecrets(path, keyFile string) (map[string]string, error) {
+	key, err := readSecretsKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading encrypted secrets: %v", err)
+	}
+
+	return openSecrets(key, sealed)
+}
+
+// sealSecrets encrypts a map of secrets with NaCl secretbox, prefixing the random nonce.
+func sealSecrets(key *[32]byte, secrets map[string]string) ([]byte, error) {
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [secretsNonceSize]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+
+	return secretbox.Seal(nonce[:], plain, &nonce, key), nil
+}
+
+func openSecrets(key *[32]byte, sealed []byte) (map[string]string, error) {
+	if len(sealed) < secretsNonceSize {
+		return nil, fmt.Errorf("encrypted secrets are too short")
+	}
+
+	var nonce [secretsNonceSize]byte
+	copy(nonce[:], sealed[:secretsNonceSize])
+
+	plain, ok := secretbox.Open(nil, sealed[secretsNonceSize:], &nonce, key)
+	if !ok {
+		return nil, fmt.Errorf("unable to decrypt secrets; is the right SecretsKeyFile configured?")
+	}
+
+	var secrets map[string]string
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, fmt.Errorf("decrypted secrets are not a JSON object of strings: %v", err)
+	}
+
+	return secrets, nil
+}
@@ -0,0 +1,186 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSecretsKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func TestInvalidSecrets(t *testing.T) {
+	invalid := []string{
+		`"not a map"`,
+		`{"TOKEN": "a"}`,
+		`{"1BAD": {"file": "a"}}`,
+		`{"BOTH": {"file": "a", "encrypted": "b"}}`,
+		`{"NEITHER": {}}`,
+		`{"OUTSIDE": {"file": "../other/token"}}`,
+		`{"ABSOLUTE": {"file": "/etc/shadow"}}`,
+	}
+
+	for _, val := range invalid {
+		if err := testSettingError(t, "Secrets", val, func(lc localConfig) error { _, err := lc.secrets(); return err }); err == nil {
+			t.Errorf("expected %v to be invalid", val)
+		}
+	}
+}
+
+func TestSealAndOpenSecrets(t *testing.T) {
+	withTempDir(t, func(path string) {
+		keyFile := filepath.Join(path, "key")
+		ioutil.WriteFile(keyFile, []byte(testSecretsKey+"\n"), 0600)
+
+		key, err := readSecretsKey(keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sealed, err := sealSecrets(key, map[string]string{"deploy": "hunter22"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Contains(string(sealed), "hunter22") {
+			t.Errorf("sealed secrets contain the plain text")
+		}
+
+		opened, err := openSecrets(key, sealed)
+		if err != nil || opened["deploy"] != "hunter22" {
+			t.Errorf("secrets did not round trip: %v %v", opened, err)
+		}
+
+		key[0]++
+		if _, err := openSecrets(key, sealed); err == nil {
+			t.Errorf("expected the wrong key to fail")
+		}
+	})
+}
+
+func TestSealSecretsOnlyForConfiguredRepos(t *testing.T) {
+	withTempDir(t, func(path string) {
+		ioutil.WriteFile(filepath.Join(path, "key"), []byte(testSecretsKey), 0600)
+		writeConfig(t, path, configFileName, `{"SecretsKeyFile": "`+filepath.Join(path, "key")+`"}`)
+		writeConfig(t, path, "MediaMath/grim/config.json", `{}`)
+
+		i := &Instance{}
+		i.SetConfigRoot(path)
+
+		for _, r := range [][2]string{{"", ""}, {"MediaMath", "other"}, {"..", filepath.Base(path)}} {
+			if err := i.SealSecrets(r[0], r[1], strings.NewReader(`{"deploy": "x"}`)); err == nil {
+				t.Errorf("expected sealing secrets for %v/%v to fail", r[0], r[1])
+			}
+		}
+
+		if err := i.SealSecrets("MediaMath", "grim", strings.NewReader(`{"deploy": "x"}`)); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(filepath.Join(path, "MediaMath", "grim", secretsFileName)); err != nil {
+			t.Errorf("expected the secrets to be written: %v", err)
+		}
+	})
+}
+
+func TestLoadSecrets(t *testing.T) {
+	withTempDir(t, func(path string) {
+		keyFile := filepath.Join(path, "key")
+		ioutil.WriteFile(keyFile, []byte(testSecretsKey), 0600)
+		ioutil.WriteFile(filepath.Join(path, "npm_token"), []byte("npm-secret\n"), 0600)
+
+		key, _ := readSecretsKey(keyFile)
+		sealed, _ := sealSecrets(key, map[string]string{"deploy": "deploy-secret"})
+		ioutil.WriteFile(filepath.Join(path, secretsFileName), sealed, 0600)
+
+		sources := map[string]secretSource{
+			"NPM_TOKEN":  {File: "npm_token"},
+			"DEPLOY_KEY": {Encrypted: "deploy"},
+		}
+
+		env, values, err := loadSecrets(path, keyFile, sources)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(env, []string{"DEPLOY_KEY=deploy-secret", "NPM_TOKEN=npm-secret"}) {
+			t.Errorf("unexpected secret env %v", env)
+		}
+
+		if !reflect.DeepEqual(values, []string{"deploy-secret", "npm-secret"}) {
+			t.Errorf("unexpected secret values %v", values)
+		}
+
+		if _, _, err := loadSecrets(path, keyFile, map[string]secretSource{"MISSING": {Encrypted: "missing"}}); err == nil {
+			t.Errorf("expected a missing encrypted entry to be an error")
+		}
+
+		if _, _, err := loadSecrets(path, "", map[string]secretSource{"DEPLOY_KEY": {Encrypted: "deploy"}}); err == nil {
+			t.Errorf("expected encrypted secrets without a key to be an error")
+		}
+	})
+}
+
+func TestFromFork(t *testing.T) {
+	cases := []struct {
+		hook hookEvent
+		fork bool
+	}{
+		{hookEvent{EventName: "pull_request", Owner: "MediaMath", Repo: "grim", HeadRepo: "bhand-mm/grim"}, true},
+		{hookEvent{EventName: "pull_request", Owner: "MediaMath", Repo: "grim", HeadRepo: ""}, true},
+		{hookEvent{EventName: "pull_request", Owner: "MediaMath", Repo: "grim", HeadRepo: "mediamath/grim"}, false},
+		{hookEvent{EventName: "push", Owner: "MediaMath", Repo: "grim"}, false},
+	}
+
+	for _, c := range cases {
+		if c.hook.fromFork() != c.fork {
+			t.Errorf("%+v from fork should be %v", c.hook, c.fork)
+		}
+	}
+}
+
+func TestSecretsInjectedAndRedacted(t *testing.T) {
+	configRoot, _ := ioutil.TempDir("", "secrets-injected")
+	defer os.RemoveAll(configRoot)
+
+	os.MkdirAll(filepath.Join(configRoot, "MediaMath", "grim"), 0700)
+	ioutil.WriteFile(filepath.Join(configRoot, "MediaMath", "grim", "npm_token"), []byte("npm-secret"), 0600)
+
+	config := testLocalConfig(localConfig{owner: "MediaMath", repo: "grim", local: configMap{
+		"Secrets": map[string]interface{}{"NPM_TOKEN": map[string]interface{}{"file": "npm_token"}},
+	}})
+
+	push := hookEvent{EventName: "push", Owner: "MediaMath", Repo: "grim"}
+	ws, err := newWorkspaceBuilder(configRoot, config, push, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, _ := ws.env()
+	if !containsString(env, "NPM_TOKEN=npm-secret") {
+		t.Errorf("secret was not injected: %v", env)
+	}
+
+	if ws.Redactor().redact("npm-secret") != redactedValue {
+		t.Errorf("secret was not registered for redaction")
+	}
+
+	fork := hookEvent{EventName: "pull_request", Owner: "MediaMath", Repo: "grim", HeadRepo: "someone/grim"}
+	ws, err = newWorkspaceBuilder(configRoot, config, fork, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, _ = ws.env()
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "NPM_TOKEN=") {
+			t.Errorf("secret was injected into a pull request from a fork")
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// schemaKey may be set in any config.json to point editors at its JSON Schema.
+const schemaKey = "$schema"
+
+// sharedSettings can be set in the global config.json and overridden in a repo's.  Like
+// the rest of the settings a field is nil when its key isn't set so that false, 0 and ""
+// can be set on purpose.
+type sharedSettings struct {
+	GitHubToken        *string         `env:"GRIM_GITHUB_TOKEN" credential:"true" doc:"token used to set up hooks, download repos, set commit statuses and check membership"`
+	SNSTopicName       *string         `doc:"SNS topic the repo's GitHub events are sent to"`
+	HipChatToken       *string         `env:"GRIM_HIPCHAT_TOKEN" credential:"true" doc:"HipChat token used to send notifications"`
+	HipChatRoom        *string         `env:"GRIM_HIPCHAT_ROOM" doc:"HipChat room notified of builds, none if empty"`
+	HipChatVersion     *int            `doc:"version of the HipChat API the token is for, 1 or 2"`
+	PendingTemplate    *string         `doc:"template of the notification sent when a build starts"`
+	ErrorTemplate      *string         `doc:"template of the notification sent when grim fails to build"`
+	SuccessTemplate    *string         `doc:"template of the notification sent when a build succeeds"`
+	FailureTemplate    *string         `doc:"template of the notification sent when a build fails"`
+	TagTemplate        *string         `doc:"template of the notification sent when a tag or release is built"`
+	LimitTemplate      *string         `doc:"template of the notification sent when a build exceeds its limits"`
+	TimeoutTemplate    *string         `doc:"template of the notification sent when a build times out"`
+	SkippedTemplate    *string         `doc:"template of the notification sent when the in-repo config skips a build"`
+	PendingColor       *string         `doc:"HipChat color of the start notification"`
+	SuccessColor       *string         `doc:"HipChat color of the success notification"`
+	ErrorColor         *string         `doc:"HipChat color of the error notification"`
+	FailureColor       *string         `doc:"HipChat color of the failure notification"`
+	SkippedColor       *string         `doc:"HipChat color of the skipped notification"`
+	Timeout            *int            `doc:"seconds a build may run for"`
+	TimeoutGracePeriod *int            `doc:"seconds a timed out build has to exit after SIGTERM before it is killed"`
+	RedactPatterns     []string        `doc:"regular expressions of values to mask in build output, added to those of the global config"`
+	InheritEnv         *stringOrList   `doc:"\"none\", \"all\" or the names of grimd's environment variables builds inherit"`
+	ForkPolicy         *string         `doc:"whether pull requests from forks are built, never with secrets: build, build-without-secrets or require-approval"`
+	ApprovalLabel      *string         `doc:"label that approves a pull request from a fork for building"`
+	AllowedTeams       []string        `doc:"org/team names whose members may trigger builds"`
+	AllowedOrgs        []string        `doc:"orgs whose members may trigger builds"`
+	AllowCollaborators *bool           `doc:"whether collaborators on the repo may trigger builds"`
+	Sandbox            *sandboxConfig  `doc:"user, network and cache directories of the sandbox builds run in"`
+	Limits             *resourceLimits `doc:"memory, cpu, processes and output a build may use"`
+}
+
+// globalSettings are the keys of the global config.json.
+type globalSettings struct {
+	GrimQueueName         *string `env:"GRIM_QUEUE_NAME" doc:"name of the SQS queue GitHub events are read from"`
+	GrimServerID          *string `env:"GRIM_SERVER_ID" doc:"name of this grim server in commit statuses, at most 15 characters"`
+	ResultRoot            *string `env:"GRIM_RESULT_ROOT" doc:"directory build results and logs are kept in"`
+	WorkspaceRoot         *string `env:"GRIM_WORKSPACE_ROOT" doc:"directory builds are run in"`
+	AWSRegion             *string `env:"GRIM_AWS_REGION" doc:"AWS region of the queue and topics"`
+	AWSKey                *string `env:"GRIM_AWS_KEY" credential:"true" doc:"AWS access key id"`
+	AWSSecret             *string `env:"GRIM_AWS_SECRET" credential:"true" doc:"AWS secret access key"`
+	AWSProfile            *string `env:"GRIM_AWS_PROFILE" doc:"shared credentials profile used when AWSKey and AWSSecret aren't set"`
+	AWSRoleARN            *string `env:"GRIM_AWS_ROLE_ARN" doc:"IAM role assumed with the AWS credentials"`
+	AWSEndpoint           *string `env:"GRIM_AWS_ENDPOINT" doc:"URL AWS requests are sent to in place of AWS's own, eg. of an emulator"`
+	AWSSQSEndpoint        *string `env:"GRIM_AWS_SQS_ENDPOINT" doc:"URL SQS requests are sent to, in place of AWSEndpoint"`
+	AWSSNSEndpoint        *string `env:"GRIM_AWS_SNS_ENDPOINT" doc:"URL SNS requests are sent to, in place of AWSEndpoint"`
+	SecretsKeyFile        *string `env:"GRIM_SECRETS_KEY_FILE" doc:"file holding the key repo secrets are sealed with"`
+	CgroupRoot            *string `env:"GRIM_CGROUP_ROOT" doc:"cgroup v2 directory a cgroup is made in for each build"`
+	CancelSocket          *string `env:"GRIM_CANCEL_SOCKET" doc:"Unix socket grimd cancel talks to grimd on"`
+	HTTPAddress           *string `env:"GRIM_HTTP_ADDRESS" doc:"address builds can be cancelled over HTTP on, none if empty; only a loopback address without HTTPCertFile and HTTPKeyFile"`
+	HTTPCertFile          *string `env:"GRIM_HTTP_CERT_FILE" doc:"certificate file HTTPAddress is served over TLS with"`
+	HTTPKeyFile           *string `env:"GRIM_HTTP_KEY_FILE" doc:"private key file of HTTPCertFile"`
+	AuthorizationCacheTTL *int    `doc:"seconds team, org and collaborator membership is cached for"`
+	ConfigPollInterval    *int    `doc:"seconds between rereading the config root, never if 0 or less"`
+
+	sharedSettings
+}
+
+// repoSettings are the keys of a repo's config.json.  The in-repo .grim.yml and .grim.json
+// are read into them too, once renamed and filtered by repoConfigKeys.
+type repoSettings struct {
+	sharedSettings
+
+	PathToCloneIn              *string                  `doc:"path in the workspace the repo is cloned to"`
+	BuildScript                *string                  `doc:"build script to run, relative to the root of the repo"`
+	OnTimeout                  *string                  `doc:"script run after a build times out, relative to the root of the repo"`
+	SNSTopicARN                *string                  `doc:"ARN of an existing SNS topic the repo's GitHub events are sent to, in place of SNSTopicName"`
+	Env                        map[string]interface{}   `doc:"environment variables set for builds"`
+	Pipeline                   []pipelineStep           `doc:"steps run in place of a build script"`
+	Matrix                     map[string][]interface{} `doc:"environment variables whose values the repo is built with every combination of"`
+	Secrets                    map[string]secretSource  `doc:"environment variables read from a file or sealed with grimd seal-secrets"`
+	UsernameWhitelist          []string                 `doc:"GitHub users who may trigger builds, anyone if empty"`
+	Branches                   []string                 `doc:"branches pushes to are built"`
+	ExcludeBranches            []string                 `doc:"branches pushes to are not built"`
+	PullRequestBranches        []string                 `doc:"branches pull requests to are built"`
+	ExcludePullRequestBranches []string                 `doc:"branches pull requests to are not built"`
+	Tags                       []string                 `doc:"tags that are built"`
+	ExcludeTags                []string                 `doc:"tags that are not built"`
+	Paths                      []string                 `doc:"paths a change has to touch to be built"`
+	ExcludePaths               []string                 `doc:"paths a change is not built for if it only touches them"`
+}
+
+// configDefaults are the values used for the keys that have one when they aren't set.
+var configDefaults = map[string]interface{}{
+	"GrimQueueName":         defaultGrimQueueName,
+	"ResultRoot":            defaultResultRoot,
+	"WorkspaceRoot":         defaultWorkspaceRoot,
+	"CancelSocket":          defaultCancelSocket,
+	"AuthorizationCacheTTL": int(defaultAuthorizationCacheTTL / time.Second),
+	"ConfigPollInterval":    int(defaultConfigPollInterval / time.Second),
+	"HipChatVersion":        defaultHipChatVersion,
+	"PendingTemplate":       *defaultTemplateForStart,
+	"ErrorTemplate":         *defaultTemplateForError,
+	"SuccessTemplate":       *defaultTemplateForSuccess,
+	"FailureTemplate":       *defaultTemplateForFailure,
+	"TagTemplate":           *defaultTemplateForTag,
+	"LimitTemplate":         *defaultTemplateForLimit,
+	"TimeoutTemplate":       *defaultTemplateForTimeout,
+	"SkippedTemplate":       *defaultTemplateForSkipped,
+	"PendingColor":          *defaultColorForPending,
+	"SuccessColor":          *defaultColorForSuccess,
+	"ErrorColor":            *defaultColorForError,
+	"FailureColor":          *defaultColorForFailure,
+	"SkippedColor":          *defaultColorForSkipped,
+	"Timeout":               int(defaultTimeout / time.Second),
+	"TimeoutGracePeriod":    int(defaultTimeoutGracePeriod / time.Second),
+	"InheritEnv":            "none",
+	"ForkPolicy":            forkPolicyWithoutSecrets,
+	"ApprovalLabel":         defaultApprovalLabel,
+}
+
+// stringOrList is a setting that is either a string or a list of strings.  Only one of
+// str and list is set so that "all" and ["all"] can be told apart.
+type stringOrList struct {
+	str  *string
+	list []string
+}
+
+func (s *stringOrList) UnmarshalJSON(bs []byte) error {
+	var str string
+	if err := json.Unmarshal(bs, &str); err == nil {
+		*s = stringOrList{str: &str}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(bs, &list); err != nil {
+		return err
+	}
+
+	*s = stringOrList{list: list}
+	return nil
+}
+
+func (stringOrList) jsonSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		},
+	}
+}
+
+// settingsField is a key of a config.json and the field of the settings it is read into.
+type settingsField struct {
+	index []int
+	typ   reflect.Type
+	doc   string
+
+	// the GRIM_* environment variable that overrides the key in the global config.json
+	env string
+
+	// whether the value may be an env: or file: reference
+	credential bool
+}
+
+// kind describes the values the field takes for error messages.
+func (f settingsField) kind() string {
+	typ := f.typ
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	switch {
+	case typ == reflect.TypeOf(stringOrList{}):
+		return "a string or a list of strings"
+	case typ.Kind() == reflect.String:
+		return "a string"
+	case typ.Kind() == reflect.Int:
+		return "a whole number"
+	case typ.Kind() == reflect.Bool:
+		return "true or false"
+	case typ == reflect.TypeOf([]string{}):
+		return "a list of strings"
+	case typ.Kind() == reflect.Slice:
+		return "a list"
+	}
+
+	return "an object"
+}
+
+var (
+	globalSettingsFields = settingsFields(reflect.TypeOf(globalSettings{}))
+	repoSettingsFields   = settingsFields(reflect.TypeOf(repoSettings{}))
+)
+
+// settingsFields finds the keys of the settings, including those of embedded settings.
+func settingsFields(typ reflect.Type) map[string]settingsField {
+	fields := make(map[string]settingsField)
+
+	for n := 0; n < typ.NumField(); n++ {
+		field := typ.Field(n)
+		if field.Anonymous {
+			for key, embedded := range settingsFields(field.Type) {
+				embedded.index = append([]int{n}, embedded.index...)
+				fields[key] = embedded
+			}
+			continue
+		}
+
+		fields[field.Name] = settingsField{
+			index:      []int{n},
+			typ:        field.Type,
+			doc:        field.Tag.Get("doc"),
+			env:        field.Tag.Get("env"),
+			credential: field.Tag.Get("credential") == "true",
+		}
+	}
+
+	return fields
+}
+
+// decodeSettings copies the values of a config.json into the settings fields with the
+// same, case sensitive, names.  Unlike encoding/json it returns the keys that aren't
+// known and the values of the wrong type, which are left unset, instead of ignoring them
+// or stopping at the first.
+func decodeSettings(config configMap, settings interface{}) (unknown []string, errs []error) {
+	value := reflect.ValueOf(settings).Elem()
+	fields := settingsFields(value.Type())
+
+	for _, key := range sortedKeys(config) {
+		if key == schemaKey {
+			continue
+		}
+
+		field, ok := fields[key]
+		if !ok {
+			unknown = append(unknown, key)
+			continue
+		}
+
+		target := value.FieldByIndex(field.index)
+
+		bs, err := json.Marshal(config[key])
+		if err == nil {
+			err = json.Unmarshal(bs, target.Addr().Interface())
+		}
+
+		if err != nil {
+			target.Set(reflect.Zero(field.typ))
+			errs = append(errs, fmt.Errorf("%v must be %v", key, field.kind()))
+		}
+	}
+
+	return
+}
+
+// settingsErrors are the values of the wrong type decodeSettings found in a config, which
+// make reading it fail.  Unknown keys aren't errors; grimd validate only warns about them.
+type settingsErrors []error
+
+func (errs settingsErrors) Error() string {
+	messages := make([]string, len(errs))
+	for n, err := range errs {
+		messages[n] = err.Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// settingsError is nil rather than an empty settingsErrors when there are no errors.
+func settingsError(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return settingsErrors(errs)
+}
+
+// settings are the repo's config.json and its in-repo config as decoded when they were
+// read.  Settings like Pipeline and Sandbox are checked further by their accessors.
+func (lc localConfig) settings() (local, inRepo repoSettings) {
+	return lc.localSettings, lc.inRepoSettings
+}
+
+// firstString is the first of the values that is set, or the default if none are.
+func firstString(defaultValue string, values ...*string) string {
+	for _, value := range values {
+		if value != nil {
+			return *value
+		}
+	}
+
+	return defaultValue
+}
+
+// firstInt is the first of the values that is set, or the default if none are.
+func firstInt(defaultValue int, values ...*int) int {
+	for _, value := range values {
+		if value != nil {
+			return *value
+		}
+	}
+
+	return defaultValue
+}
+
+// firstBool is the first of the values that is set, or the default if none are.
+func firstBool(defaultValue bool, values ...*bool) bool {
+	for _, value := range values {
+		if value != nil {
+			return *value
+		}
+	}
+
+	return defaultValue
+}
+
+// firstList is the first of the lists that is set, even if it is set to an empty list.
+func firstList(lists ...[]string) []string {
+	for _, list := range lists {
+		if list != nil {
+			return list
+		}
+	}
+
+	return nil
+}
+
+// similarSettingsKey is the known key that only differs by case from the one given.
+func similarSettingsKey(key string) string {
+	for _, fields := range []map[string]settingsField{globalSettingsFields, repoSettingsFields} {
+		for known := range fields {
+			if strings.EqualFold(known, key) {
+				return known
+			}
+		}
+	}
+
+	return ""
+}
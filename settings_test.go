@@ -0,0 +1,126 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeSettings(t *testing.T) {
+	var settings repoSettings
+	unknown, errs := decodeSettings(configMap{
+		"$schema":            "docs/repo-config.schema.json",
+		"Timeout":            "600",
+		"HipChatVersion":     float64(2.5),
+		"HipChatRoom":        "",
+		"TimeoutGracePeriod": float64(0),
+		"AllowCollaborators": false,
+		"Branches":           []interface{}{},
+		"InheritEnv":         "all",
+		"Pipeline":           []interface{}{map[string]interface{}{"name": "test", "command": "make test"}},
+		"hipchatroom":        "builds",
+		"GrimQueueName":      "queue",
+	}, &settings)
+
+	if !reflect.DeepEqual(unknown, []string{"GrimQueueName", "hipchatroom"}) {
+		t.Errorf("unexpected unknown keys %v", unknown)
+	}
+
+	if len(errs) != 2 || errs[0].Error() != "HipChatVersion must be a whole number" || errs[1].Error() != "Timeout must be a whole number" {
+		t.Errorf("unexpected errors %v", errs)
+	}
+
+	if settings.Timeout != nil || settings.HipChatVersion != nil {
+		t.Errorf("values of the wrong type should be left unset")
+	}
+
+	if settings.HipChatRoom == nil || *settings.HipChatRoom != "" || settings.TimeoutGracePeriod == nil || *settings.TimeoutGracePeriod != 0 || settings.AllowCollaborators == nil || *settings.AllowCollaborators {
+		t.Errorf("empty, 0 and false should be set: %+v", settings)
+	}
+
+	if settings.Branches == nil || len(settings.Branches) != 0 {
+		t.Errorf("an empty list should be set: %#v", settings.Branches)
+	}
+
+	if settings.InheritEnv == nil || settings.InheritEnv.str == nil || *settings.InheritEnv.str != "all" || len(settings.Pipeline) != 1 || settings.Pipeline[0].Command != "make test" {
+		t.Errorf("unexpected settings %+v", settings)
+	}
+}
+
+func TestSetSettingsOverrideInherited(t *testing.T) {
+	config := testLocalConfig(localConfig{
+		local: configMap{"HipChatRoom": "", "AllowedTeams": []interface{}{}, "TimeoutGracePeriod": float64(0)},
+		global: testGlobalConfig(configMap{
+			"HipChatRoom":           "builds",
+			"AllowedTeams":          []interface{}{"MediaMath/admins"},
+			"AllowCollaborators":    true,
+			"AuthorizationCacheTTL": float64(0),
+			"ConfigPollInterval":    float64(0),
+		}),
+	})
+
+	if config.hipChatRoom() != "" {
+		t.Errorf("an empty HipChatRoom should stop the global room being notified: %q", config.hipChatRoom())
+	}
+
+	if teams := config.allowedTeams(); len(teams) != 0 {
+		t.Errorf("an empty AllowedTeams should override the global teams: %v", teams)
+	}
+
+	if config.timeoutGracePeriod() != 0 {
+		t.Errorf("a grace period of 0 should be used: %v", config.timeoutGracePeriod())
+	}
+
+	if config.authorizationCacheTTL() != 0 || config.global.configPollInterval() != 0 {
+		t.Errorf("0 should turn off caching and polling: %v %v", config.authorizationCacheTTL(), config.global.configPollInterval())
+	}
+
+	config = testLocalConfig(localConfig{local: configMap{"AllowCollaborators": false}, global: config.global})
+	if config.allowCollaborators() {
+		t.Errorf("AllowCollaborators false should override the global true")
+	}
+}
+
+func TestSettingsOfTheWrongType(t *testing.T) {
+	if _, err := newLocalConfig("MediaMath", "grim", configMap{"Timeout": "600"}, globalConfig{}); err == nil || !strings.Contains(err.Error(), "Timeout must be a whole number") {
+		t.Errorf("expected a Timeout of the wrong type to be an error: %v", err)
+	}
+
+	if _, err := newGlobalConfig(configMap{"AllowedOrgs": "MediaMath", "Timeout": true}); err == nil || !strings.Contains(err.Error(), "AllowedOrgs must be a list of strings; Timeout must be a whole number") {
+		t.Errorf("expected every setting of the wrong type to be an error: %v", err)
+	}
+
+	structured := configMap{"Pipeline": "make", "Matrix": []interface{}{"GO_VERSION"}, "Secrets": "token", "InheritEnv": true, "Sandbox": "yes", "Limits": "lots", "Env": "FOO=bar"}
+	_, err := newLocalConfig("MediaMath", "grim", structured, globalConfig{})
+	for _, key := range sortedKeys(structured) {
+		if err == nil || !strings.Contains(err.Error(), key+" must be") {
+			t.Errorf("expected a %v of the wrong type to be an error: %v", key, err)
+		}
+	}
+
+	withTempDir(t, func(root string) {
+		writeConfig(t, root, configFileName, `{"AWSRegion": "us-east-1", "Timeout": "600"}`)
+		if _, err := readGlobalConfig(root); err == nil {
+			t.Errorf("expected reading a config with a Timeout of the wrong type to fail")
+		}
+	})
+}
+
+func TestConfigDefaultsAreKnown(t *testing.T) {
+	for key := range configDefaults {
+		_, global := globalSettingsFields[key]
+		_, repo := repoSettingsFields[key]
+		if !global && !repo {
+			t.Errorf("default for unknown key %v", key)
+		}
+	}
+
+	if configDefaults["Timeout"] != int(defaultTimeout/time.Second) {
+		t.Errorf("unexpected Timeout default %v", configDefaults["Timeout"])
+	}
+}
@@ -6,7 +6,6 @@ package grim
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -14,8 +13,13 @@ import (
 	"github.com/aws/aws-sdk-go/service/sns"
 )
 
-func prepareSNSTopic(key, secret, region, topic string) (string, error) {
-	session := getSession(key, secret, region)
+const topicNotFoundCode = "NotFound"
+
+func prepareSNSTopic(config awsConfig, topic string) (string, error) {
+	session, err := getSNSSession(config)
+	if err != nil {
+		return "", err
+	}
 
 	topicARN, err := findExistingTopicARN(session, topic)
 	if err != nil || topicARN == "" {
@@ -29,8 +33,11 @@ func prepareSNSTopic(key, secret, region, topic string) (string, error) {
 	return topicARN, nil
 }
 
-func prepareSubscription(key, secret, region, topicARN, queueARN string) error {
-	session := getSession(key, secret, region)
+func prepareSubscription(config awsConfig, topicARN, queueARN string) error {
+	session, err := getSNSSession(config)
+	if err != nil {
+		return err
+	}
 
 	subARN, err := findSubscription(session, topicARN, queueARN)
 	if err != nil {
@@ -48,6 +55,118 @@ func prepareSubscription(key, secret, region, topicARN, queueARN string) error {
 	return err
 }
 
+// removeSubscription stops the topic's messages going to the queue.  It is not an error
+// if the queue isn't subscribed.
+func removeSubscription(config awsConfig, topicARN, queueARN string) error {
+	session, err := getSNSSession(config)
+	if err != nil {
+		return err
+	}
+
+	subARN, err := findSubscription(session, topicARN, queueARN)
+	if err != nil || subARN == "" {
+		return err
+	}
+
+	return deleteSubscription(session, subARN)
+}
+
+// lookupSNSTopic is the ARN of the topic, or "" if it doesn't exist.
+func lookupSNSTopic(config awsConfig, topic string) (string, error) {
+	session, err := getSNSSession(config)
+	if err != nil {
+		return "", err
+	}
+
+	return findExistingTopicARN(session, topic)
+}
+
+// getSubscriberEndpoints are the endpoints, eg. queue ARNs, subscribed to the topic.
+func getSubscriberEndpoints(config awsConfig, topicARN string) ([]string, error) {
+	session, err := getSNSSession(config)
+	if err != nil {
+		return nil, err
+	}
+
+	svc := sns.New(session)
+
+	params := &sns.ListSubscriptionsByTopicInput{
+		TopicArn: aws.String(topicARN),
+	}
+
+	var endpoints []string
+	for {
+		resp, err := svc.ListSubscriptionsByTopic(params)
+		if awserr, ok := err.(awserr.Error); ok {
+			return nil, fmt.Errorf("aws error while listing subscriptions to SNS topic: %v %v", awserr.Code(), awserr.Message())
+		} else if err != nil {
+			return nil, fmt.Errorf("error while listing subscriptions to SNS topic: %v", err)
+		} else if resp == nil {
+			break
+		}
+
+		for _, sub := range resp.Subscriptions {
+			if sub.Endpoint != nil {
+				endpoints = append(endpoints, *sub.Endpoint)
+			}
+		}
+
+		if resp.NextToken == nil {
+			break
+		}
+		params.NextToken = resp.NextToken
+	}
+
+	return endpoints, nil
+}
+
+// snsTopicExists is whether the topic, given by its ARN, exists.
+func snsTopicExists(config awsConfig, topicARN string) (bool, error) {
+	session, err := getSNSSession(config)
+	if err != nil {
+		return false, err
+	}
+
+	svc := sns.New(session)
+
+	params := &sns.GetTopicAttributesInput{
+		TopicArn: aws.String(topicARN),
+	}
+
+	_, err = svc.GetTopicAttributes(params)
+	if awserr, ok := err.(awserr.Error); ok && awserr.Code() == topicNotFoundCode {
+		return false, nil
+	} else if ok {
+		return false, fmt.Errorf("aws error while getting attributes of SNS topic: %v %v", awserr.Code(), awserr.Message())
+	} else if err != nil {
+		return false, fmt.Errorf("error while getting attributes of SNS topic: %v", err)
+	}
+
+	return true, nil
+}
+
+func deleteSNSTopic(config awsConfig, topicARN string) error {
+	session, err := getSNSSession(config)
+	if err != nil {
+		return err
+	}
+
+	svc := sns.New(session)
+
+	params := &sns.DeleteTopicInput{
+		TopicArn: aws.String(topicARN),
+	}
+
+	_, err = svc.DeleteTopic(params)
+	if awserr, ok := err.(awserr.Error); ok {
+		return fmt.Errorf("aws error while deleting SNS topic: %v %v", awserr.Code(), awserr.Message())
+	} else if err != nil {
+		return fmt.Errorf("error while deleting SNS topic: %v", err)
+	}
+
+	return nil
+}
+
 func createSubscription(session *session.Session, topicARN, queueARN string) (string, error) {
 	svc := sns.New(session)
 
@@ -69,6 +188,23 @@ func createSubscription(session *session.Session, topicARN, queueARN string) (st
 	return *resp.SubscriptionArn, nil
 }
 
+func deleteSubscription(session *session.Session, subARN string) error {
+	svc := sns.New(session)
+
+	params := &sns.UnsubscribeInput{
+		SubscriptionArn: aws.String(subARN),
+	}
+
+	_, err := svc.Unsubscribe(params)
+	if awserr, ok := err.(awserr.Error); ok {
+		return fmt.Errorf("aws error while removing subscription to SNS topic: %v %v", awserr.Code(), awserr.Message())
+	} else if err != nil {
+		return fmt.Errorf("error while removing subscription to SNS topic: %v", err)
+	}
+
+	return nil
+}
+
 func findSubscription(session *session.Session, topicARN, queueARN string) (string, error) {
 	svc := sns.New(session)
 
@@ -139,7 +275,7 @@ func findExistingTopicARN(session *session.Session, topic string) (string, error
 		}
 
 		for _, topicPtr := range resp.Topics {
-			if topicPtr != nil && topicPtr.TopicArn != nil && strings.HasSuffix(*topicPtr.TopicArn, topic) {
+			if topicPtr != nil && topicPtr.TopicArn != nil && getResourceFromARN(*topicPtr.TopicArn) == topic {
 				return *topicPtr.TopicArn, nil
 			}
 		}
@@ -5,8 +5,8 @@ package grim
 // license that can be found in the LICENSE file.
 
 import (
-	"encoding/json"
 	"fmt"
+	"strconv"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -14,34 +14,21 @@ import (
 	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
-var policyFormat = `{
-	"Version": "2008-10-17",
-	"Id": "grim-policy",
-	"Statement": [
-	  {
-			"Sid": "1",
-			"Effect": "Allow",
-			"Principal": {
-				"AWS": "*"
-			},
-			"Action": "SQS:*",
-			"Resource": "%v",
-			"Condition": {
-				"ArnEquals": {
-					"aws:SourceArn": %v
-				}
-			}
-		}
-	]
-}`
+const (
+	nonExistentQueueCode  = "AWS.SimpleQueueService.NonExistentQueue"
+	receiveCountAttribute = "ApproximateReceiveCount"
+)
 
 type sqsQueue struct {
 	URL string
 	ARN string
 }
 
-func prepareSQSQueue(key, secret, region, queue string) (*sqsQueue, error) {
-	session := getSession(key, secret, region)
+func prepareSQSQueue(config awsConfig, queue string) (*sqsQueue, error) {
+	session, err := getSQSSession(config)
+	if err != nil {
+		return nil, err
+	}
 
 	queueURL, err := getQueueURLByName(session, queue)
 	if err != nil {
@@ -60,37 +47,104 @@ func prepareSQSQueue(key, secret, region, queue string) (*sqsQueue, error) {
 	return &sqsQueue{queueURL, queueARN}, nil
 }
 
-func getNextMessage(key, secret, region, queueURL string) (string, error) {
-	session := getSession(key, secret, region)
+// lookupSQSQueue is the queue, or nil if it doesn't exist.
+func lookupSQSQueue(config awsConfig, queue string) (*sqsQueue, error) {
+	session, err := getSQSSession(config)
+	if err != nil {
+		return nil, err
+	}
+
+	svc := sqs.New(session)
+
+	params := &sqs.GetQueueUrlInput{
+		QueueName: aws.String(queue),
+	}
+
+	resp, err := svc.GetQueueUrl(params)
+	if awserr, ok := err.(awserr.Error); ok && awserr.Code() == nonExistentQueueCode {
+		return nil, nil
+	} else if ok {
+		return nil, fmt.Errorf("aws error while getting URL for SQS queue: %v %v", awserr.Code(), awserr.Message())
+	} else if err != nil {
+		return nil, fmt.Errorf("error while getting URL for SQS queue: %v", err)
+	} else if resp == nil || resp.QueueUrl == nil {
+		return nil, nil
+	}
+
+	queueARN, err := getARNForQueueURL(session, *resp.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqsQueue{*resp.QueueUrl, queueARN}, nil
+}
+
+// queueMessage is a message received from the Grim queue, which stays on the queue until
+// it is deleted.
+type queueMessage struct {
+	body          string
+	receiptHandle string
+	receiveCount  int
+}
+
+// getNextMessage receives the next message from the queue, or nil if there isn't one.  The
+// message is hidden from other receivers for the queue's visibility timeout, after which
+// it is delivered again unless it has been deleted with deleteNextMessage.
+func getNextMessage(config awsConfig, queueURL string) (*queueMessage, error) {
+	session, err := getSQSSession(config)
+	if err != nil {
+		return nil, err
+	}
 
 	message, err := getMessage(session, queueURL)
 	if err != nil {
-		return "", err
+		return nil, err
 	} else if message == nil || message.ReceiptHandle == nil {
-		return "", nil
+		return nil, nil
 	}
 
-	err = deleteMessage(session, queueURL, *message.ReceiptHandle)
-	if err != nil {
-		return "", err
+	received := &queueMessage{receiptHandle: *message.ReceiptHandle}
+	if message.Body != nil {
+		received.body = *message.Body
 	}
 
-	if message.Body == nil {
-		return "", nil
+	if count, ok := message.Attributes[receiveCountAttribute]; ok && count != nil {
+		received.receiveCount, _ = strconv.Atoi(*count)
 	}
 
-	return *message.Body, nil
+	return received, nil
 }
 
-func setPolicy(key, secret, region, queueARN, queueURL string, topicARNs []string) error {
-	svc := sqs.New(getSession(key, secret, region))
+func deleteNextMessage(config awsConfig, queueURL string, message *queueMessage) error {
+	session, err := getSQSSession(config)
+	if err != nil {
+		return err
+	}
+
+	return deleteMessage(session, queueURL, message.receiptHandle)
+}
 
-	bs, err := json.Marshal(topicARNs)
+// setPolicy sets the statement of the queue's policy with the sid to let the topics send
+// to the queue, removing those with the old sids and leaving the other statements as they
+// are.  Without topics the statement is removed, along with the policy if nothing else is
+// left in it.
+func setPolicy(config awsConfig, sid string, oldSids []string, queueARN, queueURL string, topicARNs []string) error {
+	session, err := getSQSSession(config)
+	if err != nil {
+		return err
+	}
+
+	existing, err := getQueueAttribute(session, queueURL, "Policy")
+	if err != nil {
+		return err
+	}
+
+	policy, err := mergePolicy(existing, sid, oldSids, queueARN, topicARNs)
 	if err != nil {
 		return fmt.Errorf("error while creating policy for SQS queue: %v", err)
 	}
 
-	policy := fmt.Sprintf(policyFormat, queueARN, string(bs))
+	svc := sqs.New(session)
 
 	params := &sqs.SetQueueAttributesInput{
 		Attributes: map[string]*string{
@@ -129,34 +183,46 @@ func getQueueURLByName(session *session.Session, queue string) (string, error) {
 }
 
 func getARNForQueueURL(session *session.Session, queueURL string) (string, error) {
-	svc := sqs.New(session)
+	return getQueueAttribute(session, queueURL, "QueueArn")
+}
 
-	arnKey := "QueueArn"
+// getQueuePolicy is the queue's policy document, or "" if it hasn't got one.
+func getQueuePolicy(config awsConfig, queueURL string) (string, error) {
+	session, err := getSQSSession(config)
+	if err != nil {
+		return "", err
+	}
+
+	return getQueueAttribute(session, queueURL, "Policy")
+}
+
+func getQueueAttribute(session *session.Session, queueURL, name string) (string, error) {
+	svc := sqs.New(session)
 
 	params := &sqs.GetQueueAttributesInput{
 		QueueUrl: aws.String(string(queueURL)),
 		AttributeNames: []*string{
-			aws.String(arnKey),
+			aws.String(name),
 		},
 	}
 
 	resp, err := svc.GetQueueAttributes(params)
 	if awserr, ok := err.(awserr.Error); ok {
-		return "", fmt.Errorf("aws error while getting ARN for SQS queue: %v %v", awserr.Code(), awserr.Message())
+		return "", fmt.Errorf("aws error while getting %v for SQS queue: %v %v", name, awserr.Code(), awserr.Message())
 	} else if err != nil {
-		return "", fmt.Errorf("error while getting ARN for SQS queue: %v", err)
+		return "", fmt.Errorf("error while getting %v for SQS queue: %v", name, err)
 	} else if resp == nil || resp.Attributes == nil {
 		return "", nil
 	}
 
 	atts := resp.Attributes
 
-	arnPtr, ok := atts[arnKey]
-	if !ok || arnPtr == nil {
+	valuePtr, ok := atts[name]
+	if !ok || valuePtr == nil {
 		return "", nil
 	}
 
-	return *arnPtr, nil
+	return *valuePtr, nil
 }
 
 func createQueue(session *session.Session, queue string) (string, error) {
@@ -182,18 +248,25 @@ func createQueue(session *session.Session, queue string) (string, error) {
 }
 
 func getMessage(session *session.Session, queueURL string) (*sqs.Message, error) {
-	svc := sqs.New(session)
+	svc := sqs.New(session, noSDKRetries)
 
 	params := &sqs.ReceiveMessageInput{
 		QueueUrl:            aws.String(queueURL),
 		MaxNumberOfMessages: aws.Int64(1),
+		AttributeNames: []*string{
+			aws.String(receiveCountAttribute),
+		},
 	}
 
-	resp, err := svc.ReceiveMessage(params)
+	var resp *sqs.ReceiveMessageOutput
+	err := defaultRetryPolicy.do(func() (err error) {
+		resp, err = svc.ReceiveMessage(params)
+		return
+	})
 	if awserr, ok := err.(awserr.Error); ok {
-		return nil, fmt.Errorf("aws error while receiving message from SQS: %v %v", awserr.Code(), awserr.Message())
+		return nil, grimErrorf("aws error while receiving message from SQS: %v %v", awserr.Code(), awserr.Message()).withKind(Kind(err))
 	} else if err != nil {
-		return nil, fmt.Errorf("error while receiving message from SQS: %v", err)
+		return nil, grimErrorf("error while receiving message from SQS: %v", err)
 	} else if resp == nil || len(resp.Messages) == 0 {
 		return nil, nil
 	}
@@ -0,0 +1,206 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+)
+
+// teardownStep is one thing Teardown deletes.
+type teardownStep struct {
+	description string
+	run         func() error
+}
+
+// Teardown reverses what PrepareRepos set up for a repo, or for every configured repo if
+// owner and repo are empty: it deletes the repos' AmazonSNS hooks, unsubscribes grim's
+// queue from their topics, deletes the topics and removes them from grim's statement in
+// the queue's policy.  A topic that another configured repo uses, that has other
+// subscribers or that was given by its ARN is kept.  If dryRun is set the steps are only
+// logged.  The queue itself isn't deleted.
+//
+// A single repo must have been removed from the config root first, as PrepareRepos would
+// otherwise set it up again on the next reload.
+func (i *Instance) Teardown(owner, repo string, dryRun bool, logger *log.Logger) error {
+	configRoot := getEffectiveConfigRoot(i.configRoot)
+
+	global, err := readGlobalConfig(configRoot)
+	if err != nil {
+		return fatalGrimErrorf("error while reading config: %v", err).withKind(ConfigError)
+	}
+
+	var targets, kept []localConfig
+	for _, r := range getAllConfiguredRepos(configRoot) {
+		config, err := readLocalConfig(configRoot, r.owner, r.name)
+		if err != nil {
+			return fatalGrimErrorf("Error with config for %s/%s. %v", r.owner, r.name, err).withKind(ConfigError)
+		}
+
+		if owner == "" || (r.owner == owner && r.name == repo) {
+			targets = append(targets, config)
+		} else {
+			kept = append(kept, config)
+		}
+	}
+
+	if owner != "" && len(targets) > 0 {
+		err := fmt.Errorf("%v/%v is still configured; remove %v before tearing it down or grimd will set it up again", owner, repo, filepath.Join(configRoot, owner, repo))
+		if !dryRun {
+			return fatalGrimErrorf("%v", err).withKind(ConfigError)
+		}
+		logger.Print(err)
+	}
+
+	if owner != "" && len(targets) == 0 {
+		// a repo whose config directory has been removed had the defaults
+		targets = append(targets, localConfig{owner: owner, repo: repo, local: configMap{}, global: global})
+	}
+
+	p := i.getProvisioner()
+
+	queue, err := p.findQueue(global)
+	if err != nil {
+		return grimErrorf("%v", err)
+	}
+
+	steps, err := teardownSteps(p, global, queue, targets, kept, owner == "", logger)
+	if err != nil {
+		return grimErrorf("%v", err)
+	}
+
+	if len(steps) == 0 {
+		logger.Print("nothing to tear down")
+		return nil
+	}
+
+	failed := 0
+	for _, step := range steps {
+		if dryRun {
+			logger.Printf("would %v", step.description)
+			continue
+		}
+
+		if err := step.run(); err != nil {
+			logger.Printf("failed to %v: %v", step.description, err)
+			failed++
+			continue
+		}
+
+		logger.Print(step.description)
+	}
+
+	if failed > 0 {
+		return grimErrorf("%v of %v teardown steps failed", failed, len(steps))
+	}
+
+	return nil
+}
+
+func teardownSteps(p provisioner, global globalConfig, queue *sqsQueue, targets, kept []localConfig, all bool, logger *log.Logger) ([]teardownStep, error) {
+	keptTopics := make(map[string]bool)
+	for _, config := range kept {
+		keptTopics[config.snsTopicName()] = true
+	}
+
+	var hookSteps, topicSteps []teardownStep
+	var removedTopics []string
+	seen := make(map[string]bool)
+
+	for _, target := range targets {
+		config := target
+
+		hasHook, err := p.hasHook(config)
+		if err != nil {
+			return nil, err
+		}
+
+		if hasHook {
+			hookSteps = append(hookSteps, teardownStep{
+				fmt.Sprintf("delete the AmazonSNS hook of %v/%v", config.owner, config.repo),
+				func() error { return p.removeHook(config) },
+			})
+		}
+
+		name := config.snsTopicName()
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		if keptTopics[name] {
+			logger.Printf("keeping SNS topic %v, other configured repos use it", name)
+			continue
+		}
+
+		topicARN, err := p.findTopic(config)
+		if err != nil {
+			return nil, err
+		} else if topicARN == "" {
+			continue
+		}
+
+		subscribers, err := p.subscribers(global, topicARN)
+		if err != nil {
+			return nil, err
+		}
+
+		others := 0
+		for _, subscriber := range subscribers {
+			if queue != nil && subscriber == queue.ARN {
+				topicSteps = append(topicSteps, teardownStep{
+					fmt.Sprintf("unsubscribe queue %v from SNS topic %v", queue.ARN, topicARN),
+					func() error { return p.unsubscribe(global, topicARN, queue) },
+				})
+			} else {
+				others++
+			}
+		}
+
+		if config.snsTopicARN() != "" {
+			logger.Printf("keeping SNS topic %v, grim didn't create it", topicARN)
+		} else if others > 0 {
+			logger.Printf("keeping SNS topic %v, %v other endpoints are subscribed to it", topicARN, others)
+		} else {
+			topicSteps = append(topicSteps, teardownStep{
+				fmt.Sprintf("delete SNS topic %v", topicARN),
+				func() error { return p.deleteTopic(global, topicARN) },
+			})
+		}
+
+		removedTopics = append(removedTopics, topicARN)
+	}
+
+	steps := append(hookSteps, topicSteps...)
+
+	if queue == nil || len(removedTopics) == 0 {
+		return steps, nil
+	}
+
+	if all {
+		return append(steps, teardownStep{
+			fmt.Sprintf("remove grim's statement from the policy of queue %v", queue.ARN),
+			func() error { return p.setPolicy(global, queue, nil) },
+		}), nil
+	}
+
+	var remaining []string
+	for _, config := range kept {
+		topicARN, err := p.findTopic(config)
+		if err != nil {
+			return nil, err
+		} else if topicARN != "" && !containsString(remaining, topicARN) {
+			remaining = append(remaining, topicARN)
+		}
+	}
+	sort.Strings(remaining)
+
+	return append(steps, teardownStep{
+		fmt.Sprintf("remove %v from the policy of queue %v", removedTopics, queue.ARN),
+		func() error { return p.setPolicy(global, queue, remaining) },
+	}), nil
+}
@@ -0,0 +1,189 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func withTeardownConfig(t *testing.T, f func(path string)) {
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, configFileName, `{}`)
+		writeConfig(t, path, "MediaMath/a/config.json", `{}`)
+		writeConfig(t, path, "MediaMath/b/config.json", `{}`)
+		writeConfig(t, path, "MediaMath/shared1/config.json", `{"SNSTopicName": "shared"}`)
+		writeConfig(t, path, "MediaMath/shared2/config.json", `{"SNSTopicName": "shared"}`)
+
+		f(path)
+	})
+}
+
+func TestTeardownEverything(t *testing.T) {
+	withTeardownConfig(t, func(path string) {
+		p := &testProvisioner{
+			missingHooks:  map[string]bool{"shared2": true},
+			subscriptions: map[string][]string{"arn:grim-MediaMath-b-repo-topic": {"arn:queue", "arn:other-queue"}},
+		}
+		i := testInstance(path, p)
+
+		var buf bytes.Buffer
+		if err := i.Teardown("", "", false, log.New(&buf, "", 0)); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{
+			"remove hook MediaMath/a",
+			"remove hook MediaMath/b",
+			"remove hook MediaMath/shared1",
+			"unsubscribe arn:grim-MediaMath-a-repo-topic",
+			"delete topic arn:grim-MediaMath-a-repo-topic",
+			"unsubscribe arn:grim-MediaMath-b-repo-topic",
+			"unsubscribe arn:shared",
+			"delete topic arn:shared",
+			"set policy",
+		}
+
+		if !reflect.DeepEqual(p.calls, expected) {
+			t.Errorf("expected %v but got %v", expected, p.calls)
+		}
+
+		if len(p.policy) != 0 {
+			t.Errorf("expected the policy to be removed but got %v", p.policy)
+		}
+
+		if !strings.Contains(buf.String(), "keeping SNS topic arn:grim-MediaMath-b-repo-topic, 1 other endpoints are subscribed to it") {
+			t.Errorf("kept topic was not logged: %v", buf.String())
+		}
+	})
+}
+
+func TestTeardownDryRun(t *testing.T) {
+	withTeardownConfig(t, func(path string) {
+		p := &testProvisioner{}
+		i := testInstance(path, p)
+
+		var buf bytes.Buffer
+		if err := i.Teardown("", "", true, log.New(&buf, "", 0)); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(p.calls) != 0 {
+			t.Errorf("a dry run deleted %v", p.calls)
+		}
+
+		for _, expected := range []string{
+			"would delete the AmazonSNS hook of MediaMath/a",
+			"would unsubscribe queue arn:queue from SNS topic arn:shared",
+			"would delete SNS topic arn:shared",
+			"would remove grim's statement from the policy of queue arn:queue",
+		} {
+			if !strings.Contains(buf.String(), expected) {
+				t.Errorf("expected %q to be logged: %v", expected, buf.String())
+			}
+		}
+	})
+}
+
+func TestTeardownRepoKeepsSharedTopic(t *testing.T) {
+	withTeardownConfig(t, func(path string) {
+		p := &testProvisioner{}
+		i := testInstance(path, p)
+
+		var buf bytes.Buffer
+		if err := i.Teardown("MediaMath", "shared1", true, log.New(&buf, "", 0)); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(buf.String(), "would delete the AmazonSNS hook of MediaMath/shared1\n") || strings.Count(buf.String(), "would ") != 1 {
+			t.Errorf("expected only the hook to be removed but got %v", buf.String())
+		}
+
+		if !strings.Contains(buf.String(), "keeping SNS topic shared, other configured repos use it") {
+			t.Errorf("kept topic was not logged: %v", buf.String())
+		}
+	})
+}
+
+func TestTeardownRefusesConfiguredRepo(t *testing.T) {
+	withTeardownConfig(t, func(path string) {
+		p := &testProvisioner{}
+		i := testInstance(path, p)
+
+		var buf bytes.Buffer
+		err := i.Teardown("MediaMath", "a", false, log.New(&buf, "", 0))
+		if err == nil || !strings.Contains(err.Error(), "MediaMath/a is still configured") {
+			t.Fatalf("expected tearing down a configured repo to be refused but got %v", err)
+		}
+
+		if len(p.calls) > 0 {
+			t.Errorf("expected nothing to be torn down but got %v", p.calls)
+		}
+
+		if err := i.Teardown("MediaMath", "a", true, log.New(&buf, "", 0)); err != nil {
+			t.Errorf("expected a dry run to only warn but got %v", err)
+		} else if !strings.Contains(buf.String(), "MediaMath/a is still configured") {
+			t.Errorf("dry run didn't warn that the repo is still configured: %v", buf.String())
+		}
+	})
+}
+
+func TestTeardownRemovedRepo(t *testing.T) {
+	withTeardownConfig(t, func(path string) {
+		p := &testProvisioner{missingTopics: map[string]bool{"grim-MediaMath-b-repo-topic": true}}
+		i := testInstance(path, p)
+
+		if err := i.Teardown("MediaMath", "gone", false, log.New(&bytes.Buffer{}, "", 0)); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{
+			"remove hook MediaMath/gone",
+			"unsubscribe arn:grim-MediaMath-gone-repo-topic",
+			"delete topic arn:grim-MediaMath-gone-repo-topic",
+			"set policy",
+		}
+
+		if !reflect.DeepEqual(p.calls, expected) {
+			t.Errorf("expected %v but got %v", expected, p.calls)
+		}
+
+		if !reflect.DeepEqual(p.policy, []string{"arn:grim-MediaMath-a-repo-topic", "arn:shared"}) {
+			t.Errorf("expected the remaining topics in the policy but got %v", p.policy)
+		}
+	})
+}
+
+func TestTeardownKeepsTopicsGivenByARN(t *testing.T) {
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, configFileName, `{}`)
+		writeConfig(t, path, "MediaMath/existing/config.json", `{"SNSTopicARN": "arn:aws:sns:us-east-1:123456789012:existing"}`)
+
+		p := &testProvisioner{}
+		i := testInstance(path, p)
+
+		var buf bytes.Buffer
+		if err := i.Teardown("", "", false, log.New(&buf, "", 0)); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{
+			"remove hook MediaMath/existing",
+			"unsubscribe arn:aws:sns:us-east-1:123456789012:existing",
+			"set policy",
+		}
+
+		if !reflect.DeepEqual(p.calls, expected) {
+			t.Errorf("expected %v but got %v", expected, p.calls)
+		}
+
+		if !strings.Contains(buf.String(), "keeping SNS topic arn:aws:sns:us-east-1:123456789012:existing, grim didn't create it") {
+			t.Errorf("kept topic was not logged: %v", buf.String())
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"context"
+	"fmt"
+)
+
+// tokenChecker asks GitHub and HipChat whether the tokens in a config work.
+type tokenChecker interface {
+	checkGitHub(token string) error
+	checkHipChat(token, room string, version int) error
+}
+
+// liveTokenChecker asks each question once, since most repos use the global tokens.
+type liveTokenChecker struct {
+	answers map[string]error
+}
+
+func newLiveTokenChecker() *liveTokenChecker {
+	return &liveTokenChecker{make(map[string]error)}
+}
+
+func (c *liveTokenChecker) checkGitHub(token string) error {
+	return c.ask(fmt.Sprintf("github:%v", token), func() error {
+		client, err := getClientForToken(token)
+		if err != nil {
+			return err
+		}
+
+		u, _, err := client.Users.Get(context.Background(), "")
+		if err == nil && u.Login == nil {
+			err = fmt.Errorf("no user")
+		}
+		return err
+	})
+}
+
+func (c *liveTokenChecker) checkHipChat(token, room string, version int) error {
+	return c.ask(fmt.Sprintf("hipchat:%v:%v:%v", version, room, token), func() error {
+		return getHipChatRoom(token, room, version)
+	})
+}
+
+func (c *liveTokenChecker) ask(key string, check func() error) error {
+	if err, ok := c.answers[key]; ok {
+		return err
+	}
+
+	err := check()
+	c.answers[key] = err
+	return err
+}
@@ -0,0 +1,256 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var hipChatColors = []messageColor{ColorYellow, ColorRed, ColorGreen, ColorPurple, ColorGray, ColorRandom}
+
+// configProblems is what is wrong with grim's configuration, by the config.json it is in.
+type configProblems struct {
+	errors   []string
+	warnings []string
+}
+
+func (p *configProblems) errorf(source, format string, args ...interface{}) {
+	p.errors = append(p.errors, fmt.Sprintf("%v: %v", source, fmt.Sprintf(format, args...)))
+}
+
+func (p *configProblems) warnf(source, format string, args ...interface{}) {
+	p.warnings = append(p.warnings, fmt.Sprintf("%v: %v", source, fmt.Sprintf(format, args...)))
+}
+
+// ValidateConfig checks the global config and that of every configured repo, logging
+// each problem found.  It returns an error if any of them would stop grim working.
+func (i *Instance) ValidateConfig(logger *log.Logger) error {
+	return i.validateConfig(logger, nil)
+}
+
+// ValidateConfigAndTokens is ValidateConfig that also asks GitHub and HipChat whether the
+// tokens work and, for HipChat, can see the room.
+func (i *Instance) ValidateConfigAndTokens(logger *log.Logger) error {
+	return i.validateConfig(logger, newLiveTokenChecker())
+}
+
+func (i *Instance) validateConfig(logger *log.Logger, tokens tokenChecker) error {
+	problems := validateConfig(getEffectiveConfigRoot(i.configRoot), tokens)
+	problems.log(logger)
+
+	if len(problems.errors) > 0 {
+		return fatalGrimErrorf("found %d errors and %d warnings in the config", len(problems.errors), len(problems.warnings))
+	}
+
+	return nil
+}
+
+func (p *configProblems) log(logger *log.Logger) {
+	for _, warning := range p.warnings {
+		logger.Printf("warning: %v", warning)
+	}
+
+	for _, err := range p.errors {
+		logger.Printf("error: %v", err)
+	}
+}
+
+// validateConfig only checks the tokens with GitHub and HipChat if tokens isn't nil.
+func validateConfig(configRoot string, tokens tokenChecker) *configProblems {
+	problems := &configProblems{}
+
+	// values of the wrong type are reported by validateKeys along with the other problems
+	global, err := readGlobalConfig(configRoot)
+	if _, ok := err.(settingsErrors); err != nil && !ok {
+		problems.errorf(filepath.Join(configRoot, configFileName), "%v", err)
+		return problems
+	}
+
+	validateGlobal(problems, configRoot, global)
+
+	for _, repo := range getAllConfiguredRepos(configRoot) {
+		config, err := readLocalConfigWith(global, configRoot, repo.owner, repo.name)
+		if _, ok := err.(settingsErrors); err != nil && !ok {
+			problems.errorf(filepath.Join(configRoot, repo.owner, repo.name, configFileName), "%v", err)
+			continue
+		}
+
+		validateRepo(problems, configRoot, repo, config, tokens)
+	}
+
+	return problems
+}
+
+func validateGlobal(problems *configProblems, configRoot string, global globalConfig) {
+	source := filepath.Join(configRoot, configFileName)
+
+	validateKeys(problems, source, global.raw, true)
+	validateTemplates(problems, source, localConfig{global: global}, global.raw)
+
+	for _, err := range global.errors() {
+		problems.errorf(source, "%v", err)
+	}
+
+	for _, err := range global.warnings() {
+		problems.warnf(source, "%v", err)
+	}
+}
+
+func validateRepo(problems *configProblems, configRoot string, r repo, config localConfig, tokens tokenChecker) {
+	source := filepath.Join(configRoot, r.owner, r.name, configFileName)
+
+	validateKeys(problems, source, config.local, false)
+
+	for _, err := range config.errors() {
+		problems.errorf(source, "%v", err)
+	}
+
+	for _, err := range config.warnings() {
+		problems.warnf(source, "%v", err)
+	}
+
+	validateTemplates(problems, source, config, config.local)
+	validateNotifiers(problems, source, config, tokens)
+	validateBuildScript(problems, source, configRoot, r, config)
+}
+
+// validateKeys decodes the config.json strictly, reporting keys grim doesn't know, values
+// of the wrong type and keys in the config.json they aren't read from.
+func validateKeys(problems *configProblems, source string, config configMap, global bool) {
+	var (
+		unknown []string
+		errs    []error
+		other   map[string]settingsField
+	)
+
+	if global {
+		unknown, errs = decodeSettings(config, new(globalSettings))
+		other = repoSettingsFields
+	} else {
+		unknown, errs = decodeSettings(config, new(repoSettings))
+		other = globalSettingsFields
+	}
+
+	for _, err := range errs {
+		problems.errorf(source, "%v", err)
+	}
+
+	for _, key := range unknown {
+		if _, ok := other[key]; ok && global {
+			problems.warnf(source, "%v is ignored in the global config; it can only be set for a repo", key)
+		} else if ok {
+			problems.warnf(source, "%v is ignored in a repo's config; it can only be set globally", key)
+		} else if similar := similarSettingsKey(key); similar != "" {
+			problems.errorf(source, "unknown key %q; keys are case sensitive, did you mean %q?", key, similar)
+		} else {
+			problems.warnf(source, "unknown key %q", key)
+		}
+	}
+}
+
+// validateBuildScript can only check a build.sh in the config root since the others are
+// found in the repo when it is built.
+func validateBuildScript(problems *configProblems, source, configRoot string, r repo, config localConfig) {
+	configBuildScript := filepath.Join(configRoot, r.owner, r.name, buildScriptName)
+
+	fi, err := os.Stat(configBuildScript)
+	if err == nil {
+		if fi.Mode()&0111 == 0 {
+			problems.errorf(source, "%v is not executable", configBuildScript)
+		}
+		return
+	}
+
+	if pipeline, _ := config.pipeline(); len(pipeline) > 0 {
+		return
+	}
+
+	if script, _ := config.buildScript(); script != "" {
+		return
+	}
+
+	problems.warnf(source, "there is no %v so the repo must have a %v, %v or in-repo configuration", buildScriptName, repoBuildScriptName, repoHiddenBuildScriptName)
+}
+
+// validateTemplates renders each template set in the config.json with an empty build and
+// checks its colors are ones HipChat knows.
+func validateTemplates(problems *configProblems, source string, config localConfig, set configMap) {
+	values := map[string]string{
+		"PendingTemplate": config.pendingTemplate(),
+		"ErrorTemplate":   config.errorTemplate(),
+		"SuccessTemplate": config.successTemplate(),
+		"FailureTemplate": config.failureTemplate(),
+		"TagTemplate":     config.tagTemplate(),
+		"LimitTemplate":   config.limitTemplate(),
+		"TimeoutTemplate": config.timeoutTemplate(),
+		"SkippedTemplate": config.skippedTemplate(),
+		"PendingColor":    config.pendingColor(),
+		"SuccessColor":    config.successColor(),
+		"ErrorColor":      config.errorColor(),
+		"FailureColor":    config.failureColor(),
+		"SkippedColor":    config.skippedColor(),
+	}
+
+	var names []string
+	for name := range values {
+		if _, ok := set[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if strings.HasSuffix(name, "Color") {
+			if !validHipChatColor(values[name]) {
+				problems.errorf(source, "%v %q is not one of %v", name, values[name], hipChatColors)
+			}
+		} else if _, err := new(grimNotificationContext).render(values[name]); err != nil {
+			problems.errorf(source, "%v: %v", name, err)
+		}
+	}
+
+	if _, ok := set["HipChatVersion"]; ok {
+		if version := config.hipChatVersion(); version != 1 && version != 2 {
+			problems.errorf(source, "HipChatVersion %v is not 1 or 2", version)
+		}
+	}
+}
+
+// validateNotifiers checks that a repo's builds can set commit statuses and that its
+// HipChat settings aren't half done, and with tokens that GitHub and HipChat accept them.
+func validateNotifiers(problems *configProblems, source string, config localConfig, tokens tokenChecker) {
+	if config.gitHubToken() == "" {
+		problems.errorf(source, "there is no GitHubToken to download the repo and set commit statuses with")
+	} else if tokens != nil {
+		if err := tokens.checkGitHub(config.gitHubToken()); err != nil {
+			problems.errorf(source, "GitHubToken was not accepted by GitHub: %v", err)
+		}
+	}
+
+	if config.hipChatToken() != "" && config.hipChatRoom() == "" {
+		problems.warnf(source, "HipChatToken is set but there is no HipChatRoom to notify")
+	} else if config.hipChatToken() == "" && config.hipChatRoom() != "" {
+		problems.warnf(source, "HipChatRoom %q will not be notified without a HipChatToken", config.hipChatRoom())
+	} else if config.hipChatToken() != "" && tokens != nil {
+		if err := tokens.checkHipChat(config.hipChatToken(), config.hipChatRoom(), config.hipChatVersion()); err != nil {
+			problems.errorf(source, "HipChatRoom %q can't be notified with the HipChatToken: %v", config.hipChatRoom(), err)
+		}
+	}
+}
+
+func validHipChatColor(color string) bool {
+	for _, valid := range hipChatColors {
+		if color == string(valid) {
+			return true
+		}
+	}
+
+	return false
+}
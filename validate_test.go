@@ -0,0 +1,179 @@
+package grim
+
+// Copyright 2015 MediaMath <http://www.mediamath.com>.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, configFileName, `{
+			"AWSRegion": "us-east-1",
+			"AWSKey": "key",
+			"AWSSecret": "secret",
+			"GrimServerID": "grim",
+			"GitHubToken": "token",
+			"Timeout": "600",
+			"FailureTemplate": "{{.Owner",
+			"SuccessTemplate": "{{.Nope}}",
+			"ErrorColor": "orange",
+			"Pipeline": [],
+			"Colour": "red"
+		}`)
+
+		writeConfig(t, path, "MediaMath/grim/config.json", `{
+			"AWSKey": "key",
+			"hipchatroom": "builds",
+			"HipChatRoom": "builds"
+		}`)
+		writeConfig(t, path, "MediaMath/grim/build.sh", "#!/bin/sh\n")
+
+		writeConfig(t, path, "MediaMath/other/config.json", `{"Pipeline": [{"name": "test"}]}`)
+
+		problems := validateConfig(path, nil)
+
+		expectProblems(t, "error", problems.errors, []string{
+			"Timeout must be a whole number",
+			"FailureTemplate: Error parsing notification template",
+			"SuccessTemplate: Error applying template",
+			`ErrorColor "orange" is not one of`,
+			`unknown key "hipchatroom"; keys are case sensitive, did you mean "HipChatRoom"?`,
+			"build.sh is not executable",
+			`pipeline step "test" has no command`,
+		})
+
+		expectProblems(t, "warning", problems.warnings, []string{
+			`unknown key "Colour"`,
+			"Pipeline is ignored in the global config",
+			"AWSKey is ignored in a repo's config",
+			`HipChatRoom "builds" will not be notified without a HipChatToken`,
+		})
+	})
+}
+
+func TestValidConfig(t *testing.T) {
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, configFileName, `{"AWSRegion": "us-east-1", "AWSKey": "key", "AWSSecret": "secret", "GrimServerID": "grim", "GitHubToken": "token"}`)
+		writeConfig(t, path, "MediaMath/grim/config.json", `{"Timeout": 600, "InheritEnv": ["LANG"], "Branches": ["master"], "BuildScript": "ci.sh"}`)
+
+		var buf bytes.Buffer
+		var i Instance
+		i.SetConfigRoot(path)
+
+		if err := i.ValidateConfig(log.New(&buf, "", 0)); err != nil || buf.Len() != 0 {
+			t.Errorf("expected a valid config but got %v: %v", err, buf.String())
+		}
+	})
+}
+
+func TestValidateConfigFails(t *testing.T) {
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, configFileName, `{"Timeout": "600"}`)
+
+		var buf bytes.Buffer
+		var i Instance
+		i.SetConfigRoot(path)
+
+		if err := i.ValidateConfig(log.New(&buf, "", 0)); !IsFatal(err) {
+			t.Errorf("expected a fatal error but got %v", err)
+		}
+
+		if !strings.Contains(buf.String(), "error: "+filepath.Join(path, configFileName)+": Timeout must be a whole number") {
+			t.Errorf("problem was not logged: %v", buf.String())
+		}
+	})
+}
+
+type testTokens struct {
+	rejected map[string]bool
+	checked  []string
+}
+
+func (c *testTokens) checkGitHub(token string) error {
+	c.checked = append(c.checked, "github "+token)
+	if c.rejected[token] {
+		return fmt.Errorf("401 Bad credentials")
+	}
+	return nil
+}
+
+func (c *testTokens) checkHipChat(token, room string, version int) error {
+	c.checked = append(c.checked, fmt.Sprintf("hipchat %v %v v%v", token, room, version))
+	if c.rejected[token] {
+		return fmt.Errorf("HipChat answered with response code 401")
+	}
+	return nil
+}
+
+func TestValidateTokens(t *testing.T) {
+	withTempDir(t, func(path string) {
+		writeConfig(t, path, configFileName, `{"AWSRegion": "us-east-1", "GrimServerID": "grim", "GitHubToken": "token"}`)
+		writeConfig(t, path, "MediaMath/grim/config.json", `{"HipChatToken": "revoked", "HipChatRoom": "builds"}`)
+		writeConfig(t, path, "MediaMath/other/config.json", `{"GitHubToken": "expired"}`)
+
+		tokens := &testTokens{rejected: map[string]bool{"revoked": true, "expired": true}}
+		problems := validateConfig(path, tokens)
+
+		expectProblems(t, "error", problems.errors, []string{
+			`HipChatRoom "builds" can't be notified with the HipChatToken: HipChat answered with response code 401`,
+			"GitHubToken was not accepted by GitHub: 401 Bad credentials",
+		})
+
+		if expected := []string{"github token", "hipchat revoked builds v1", "github expired"}; !reflect.DeepEqual(tokens.checked, expected) {
+			t.Errorf("expected %v to be checked but got %v", expected, tokens.checked)
+		}
+
+		if problems := validateConfig(path, nil); len(problems.errors) != 0 {
+			t.Errorf("tokens were checked without a checker: %v", problems.errors)
+		}
+	})
+}
+
+func TestRepoConfigKeysAreKnown(t *testing.T) {
+	for repoKey, key := range repoConfigKeys {
+		if _, ok := repoSettingsFields[key]; !ok {
+			t.Errorf("in-repo key %v stands in for %v which can't be set for a repo", repoKey, key)
+		}
+	}
+}
+
+func writeConfig(t *testing.T, root, name, contents string) {
+	path := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectProblems(t *testing.T, kind string, actual, expected []string) {
+	for _, e := range expected {
+		found := false
+		for _, a := range actual {
+			if strings.Contains(a, e) {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("expected %v %q in %v", kind, e, actual)
+		}
+	}
+
+	if len(actual) != len(expected) {
+		t.Errorf("expected %d %vs but got %d: %v", len(expected), kind, len(actual), actual)
+	}
+}